@@ -0,0 +1,73 @@
+package common
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// 客户端类型
+const (
+	ClientTypeClaudeCode = "claude_code"
+	ClientTypeUnknown    = "unknown"
+
+	// 客户端类型、版本的最大长度
+	maxClientFieldLength = 50
+)
+
+var (
+	// claudeCodeUserAgentPattern Claude Code 的 User-Agent，如 claude-cli/1.0.44 (external, cli)
+	claudeCodeUserAgentPattern = regexp.MustCompile(`claude-cli/(\d+\.\d+\.\d+)`)
+	// userAgentProductPattern User-Agent 中第一个 产品名/版本 片段，如 curl/8.4.0、Anthropic/Python 0.40.0
+	userAgentProductPattern = regexp.MustCompile(`^([A-Za-z0-9._-]+)(?:/([A-Za-z0-9._-]+))?(?:\s+v?(\d[A-Za-z0-9._-]*))?`)
+)
+
+// ClientInfo 根据请求头识别出的客户端
+type ClientInfo struct {
+	Type    string // 如 claude_code、anthropic-sdk-python、curl，无法识别时为 unknown
+	Version string
+}
+
+// DetectClient 根据 User-Agent 及 Anthropic SDK 的 X-Stainless-* 请求头识别客户端类型和版本
+func DetectClient(header http.Header) ClientInfo {
+	client := detectClient(header)
+	client.Type = truncateClientField(client.Type)
+	client.Version = truncateClientField(client.Version)
+	return client
+}
+
+// detectClient 识别客户端，结果未截断
+func detectClient(header http.Header) ClientInfo {
+	userAgent := strings.TrimSpace(header.Get("User-Agent"))
+	if match := claudeCodeUserAgentPattern.FindStringSubmatch(userAgent); match != nil {
+		return ClientInfo{Type: ClientTypeClaudeCode, Version: match[1]}
+	}
+
+	// Anthropic 官方SDK（Python、TypeScript、Go等）都会携带 X-Stainless-Lang 和 X-Stainless-Package-Version
+	if lang := strings.ToLower(header.Get("X-Stainless-Lang")); lang != "" {
+		return ClientInfo{Type: "anthropic-sdk-" + lang, Version: header.Get("X-Stainless-Package-Version")}
+	}
+
+	match := userAgentProductPattern.FindStringSubmatch(userAgent)
+	if match == nil {
+		return ClientInfo{Type: ClientTypeUnknown}
+	}
+	product := strings.ToLower(match[1])
+	// Anthropic/Python 0.40.0 这类格式的语言在斜杠后，版本在空格后
+	if product == "anthropic" && match[2] != "" {
+		return ClientInfo{Type: "anthropic-sdk-" + strings.ToLower(match[2]), Version: match[3]}
+	}
+	version := match[2]
+	if version == "" {
+		version = match[3]
+	}
+	return ClientInfo{Type: product, Version: version}
+}
+
+// truncateClientField 截断客户端类型、版本，与日志表的字段长度一致
+func truncateClientField(value string) string {
+	if len(value) > maxClientFieldLength {
+		return value[:maxClientFieldLength]
+	}
+	return value
+}
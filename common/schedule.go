@@ -0,0 +1,157 @@
+package common
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AvailabilitySchedule 账号可用时间表
+// 格式: 多条规则用分号分隔，每条规则为"星期 开始时间-结束时间"，例如 mon-fri 19:00-08:00;sat,sun 00:00-24:00
+// 星期支持 mon/tue/wed/thu/fri/sat/sun、逗号列表、区间及 *；结束时间不大于开始时间时表示跨越午夜到次日
+type AvailabilitySchedule struct {
+	rules    []scheduleRule
+	location *time.Location
+}
+
+// scheduleRule 单条时间规则，时间以当天0点起的分钟数表示
+type scheduleRule struct {
+	days  [7]bool
+	start int
+	end   int
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// ParseAvailabilitySchedule 解析可用时间表，timezone 为空时使用服务器本地时区
+func ParseAvailabilitySchedule(spec, timezone string) (*AvailabilitySchedule, error) {
+	location := time.Local
+	if timezone != "" {
+		loc, err := time.LoadLocation(timezone)
+		if err != nil {
+			return nil, fmt.Errorf("无效的时区: %s", timezone)
+		}
+		location = loc
+	}
+
+	schedule := &AvailabilitySchedule{location: location}
+	for _, item := range strings.Split(spec, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		fields := strings.Fields(item)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("无效的时间规则: %s", item)
+		}
+
+		rule := scheduleRule{}
+		if err := parseScheduleDays(strings.ToLower(fields[0]), &rule.days); err != nil {
+			return nil, err
+		}
+
+		startStr, endStr, ok := strings.Cut(fields[1], "-")
+		if !ok {
+			return nil, fmt.Errorf("无效的时间段: %s", fields[1])
+		}
+		var err error
+		if rule.start, err = parseScheduleClock(startStr); err != nil {
+			return nil, err
+		}
+		if rule.end, err = parseScheduleClock(endStr); err != nil {
+			return nil, err
+		}
+
+		schedule.rules = append(schedule.rules, rule)
+	}
+
+	if len(schedule.rules) == 0 {
+		return nil, fmt.Errorf("时间表不能为空")
+	}
+	return schedule, nil
+}
+
+// Contains 判断指定时间是否在可用时间表内
+func (s *AvailabilitySchedule) Contains(t time.Time) bool {
+	t = t.In(s.location)
+	weekday := t.Weekday()
+	previous := (weekday + 6) % 7
+	minute := t.Hour()*60 + t.Minute()
+
+	for _, rule := range s.rules {
+		if rule.start < rule.end {
+			if rule.days[weekday] && minute >= rule.start && minute < rule.end {
+				return true
+			}
+			continue
+		}
+
+		// 跨越午夜：开始当天的晚段，或前一天开始规则的次日早段
+		if rule.days[weekday] && minute >= rule.start {
+			return true
+		}
+		if rule.days[previous] && minute < rule.end {
+			return true
+		}
+	}
+	return false
+}
+
+// parseScheduleDays 解析星期部分，如 mon-fri、sat,sun、*
+func parseScheduleDays(value string, days *[7]bool) error {
+	for _, part := range strings.Split(value, ",") {
+		if part == "*" {
+			for i := range days {
+				days[i] = true
+			}
+			continue
+		}
+
+		startName, endName, isRange := strings.Cut(part, "-")
+		start, ok := weekdayNames[startName]
+		if !ok {
+			return fmt.Errorf("无效的星期: %s", startName)
+		}
+		if !isRange {
+			days[start] = true
+			continue
+		}
+
+		end, ok := weekdayNames[endName]
+		if !ok {
+			return fmt.Errorf("无效的星期: %s", endName)
+		}
+		for day := start; ; day = (day + 1) % 7 {
+			days[day] = true
+			if day == end {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// parseScheduleClock 解析 HH:MM 格式的时间，允许 24:00 表示当天结束
+func parseScheduleClock(value string) (int, error) {
+	hourStr, minuteStr, ok := strings.Cut(strings.TrimSpace(value), ":")
+	if !ok {
+		return 0, fmt.Errorf("无效的时间: %s", value)
+	}
+
+	hour, err1 := strconv.Atoi(hourStr)
+	minute, err2 := strconv.Atoi(minuteStr)
+	if err1 != nil || err2 != nil || hour < 0 || hour > 24 || minute < 0 || minute > 59 || (hour == 24 && minute != 0) {
+		return 0, fmt.Errorf("无效的时间: %s", value)
+	}
+	return hour*60 + minute, nil
+}
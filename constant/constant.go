@@ -40,6 +40,32 @@ const (
 	PlatformClaudeConsole = "claude_console"
 	PlatformOpenAI        = "openai"
 	PlatformGemini        = "gemini"
+	PlatformBedrock       = "bedrock"
+	PlatformVertex        = "vertex"
+	PlatformAzureOpenAI   = "azure_openai"
+
+	// OpenAI账号的上游协议
+	UpstreamProtocolChatCompletions = "chat_completions" // /chat/completions
+	UpstreamProtocolResponses       = "responses"        // /responses
+
+	// OpenAI账号开启 thinking 时传给上游的推理参数，为空表示不传递
+	ThinkingParamReasoningEffort = "reasoning_effort" // reasoning_effort(OpenAI o系列、DeepSeek等)
+	ThinkingParamEnableThinking  = "enable_thinking"  // enable_thinking(Qwen)
+	ThinkingParamThinkingType    = "thinking"         // thinking.type(GLM)
+
+	// 账号调度策略
+	ScheduleStrategyLeastUsed      = "least_used"      // 按优先级，同优先级内今日使用次数最少优先
+	ScheduleStrategyWeightedRandom = "weighted_random" // 按优先级，同优先级内按权重随机
+
+	// API Key 优先级通道
+	PriorityClassInteractive = "interactive" // 交互式会话（如人工使用的 Claude Code），优先保障
+	PriorityClassBatch       = "batch"       // 批处理任务（如CI中的Agent）
+	PriorityClassBackground  = "background"  // 后台任务，资源紧张时最先被限流
+
+	// API Key 客户端策略
+	ClientPolicyClaudeCode = "claude_code" // 仅允许 Claude Code（校验 User-Agent 和系统提示词）
+	ClientPolicyAnthropic  = "anthropic"   // 允许任何兼容 Anthropic API 的客户端（携带 anthropic-version 请求头）
+	ClientPolicyCustom     = "custom"      // 仅允许 User-Agent 命中自定义正则白名单的客户端
 
 	ClaudeCodeSystemPrompt = "You are Claude Code, Anthropic's official CLI for Claude."
 )
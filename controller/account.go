@@ -77,7 +77,10 @@ func CreateAccount(c *gin.Context) {
 		constant.PlatformClaude:        true,
 		constant.PlatformClaudeConsole: true,
 		constant.PlatformOpenAI:        true,
+		constant.PlatformAzureOpenAI:   true,
 		constant.PlatformGemini:        true,
+		constant.PlatformBedrock:       true,
+		constant.PlatformVertex:        true,
 	}
 	if !validPlatformTypes[req.PlatformType] {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -103,6 +106,45 @@ func CreateAccount(c *gin.Context) {
 	})
 }
 
+// GetAccountsHealth 获取账号健康度（首字时间、耗时、错误率的滑动平均值及健康分）
+func GetAccountsHealth(c *gin.Context) {
+	user := c.MustGet("user").(*model.User)
+	var userID *uint
+
+	// 如果是普通用户，只能查看自己的账号
+	if user.Role != "admin" {
+		userID = &user.ID
+	} else if userIDParam := c.Query("user_id"); userIDParam != "" {
+		// 管理员可以通过参数指定查看特定用户的账号
+		id, err := strconv.ParseUint(userIDParam, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "无效的用户ID",
+				"code":  constant.InvalidParams,
+			})
+			return
+		}
+		uid := uint(id)
+		userID = &uid
+	}
+
+	accountService := service.NewAccountService()
+	items, err := accountService.GetAccountsHealth(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+			"code":  constant.InternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "获取成功",
+		"code":    constant.Success,
+		"data":    items,
+	})
+}
+
 // GetAccount 获取账号详情
 func GetAccount(c *gin.Context) {
 	idParam := c.Param("id")
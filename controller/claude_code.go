@@ -1,14 +1,22 @@
 package controller
 
 import (
+	"bytes"
 	"claude-code-relay/common"
 	"claude-code-relay/constant"
 	"claude-code-relay/model"
 	"claude-code-relay/relay"
+	"claude-code-relay/service"
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"io"
+	"log"
 	"net/http"
 	"strconv"
+	"time"
 )
 
 type ExchangeRequest struct {
@@ -90,42 +98,607 @@ func GetMessages(c *gin.Context) {
 	// 从上下文中获取API Key的详细信息
 	apiKey, _ := c.Get("api_key")
 	keyInfo := apiKey.(*model.ApiKey)
+	group := service.GetApiKeyGroup(keyInfo)
 
-	// 根据API Key的分组ID查询可用账号列表
-	accounts, err := model.GetAvailableAccountsByGroupID(keyInfo.GroupID)
+	// 缓存请求体，切换账号重试时需要重新发送
+	body, err := io.ReadAll(c.Request.Body)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "查询账号列表失败",
-			"code":    constant.InternalServerError,
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "读取请求体失败",
+			"code":    constant.InvalidParams,
+		})
+		return
+	}
+
+	newRelaySession(c, keyInfo, group, body).serve(group)
+}
+
+// ChatCompletions OpenAI Chat Completions 兼容接口
+// 请求转换为Claude Messages格式后由分组内的Claude、Claude Console账号处理，响应再转换回OpenAI格式，配额和计费与对话接口一致
+func ChatCompletions(c *gin.Context) {
+	apiKey, _ := c.Get("api_key")
+	keyInfo := apiKey.(*model.ApiKey)
+	group := service.GetApiKeyGroup(keyInfo)
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": map[string]any{
+				"type":    "invalid_request_error",
+				"message": "Failed to read request body",
+			},
 		})
 		return
 	}
 
-	if len(accounts) == 0 {
+	claudeBody, err := relay.ConvertOpenAIChatToClaude(body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": map[string]any{
+				"type":    "invalid_request_error",
+				"message": err.Error(),
+			},
+		})
+		return
+	}
+
+	writer := relay.NewOpenAIChatWriter(c.Writer, body)
+	c.Writer = writer
+	defer writer.Finish()
+
+	session := newRelaySession(c, keyInfo, group, claudeBody)
+	session.accountFilter = isClaudeAccount
+	session.serve(group)
+}
+
+// isClaudeAccount 判断账号是否原生使用Claude Messages协议（Claude官方、Claude Console、Bedrock、Vertex）
+func isClaudeAccount(account *model.Account) bool {
+	switch account.PlatformType {
+	case constant.PlatformClaude, constant.PlatformClaudeConsole, constant.PlatformBedrock, constant.PlatformVertex:
+		return true
+	default:
+		return false
+	}
+}
+
+// CountTokens 计算消息的输入token数，与对话接口使用相同的账号调度，不排队、不计费
+func CountTokens(c *gin.Context) {
+	apiKey, _ := c.Get("api_key")
+	keyInfo := apiKey.(*model.ApiKey)
+	group := service.GetApiKeyGroup(keyInfo)
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "读取请求体失败",
+			"code":    constant.InvalidParams,
+		})
+		return
+	}
+
+	session := newRelaySession(c, keyInfo, group, body)
+	session.handler = countTokensToAccount
+	session.lightweight = true
+	if !session.checkModelRestriction() {
+		return
+	}
+
+	result, found, err := session.relay()
+	if err != nil {
+		respondScheduleError(c, session.modelName, err)
+		return
+	}
+	if result != nil {
+		if result.Retryable {
+			respondAccountsExhausted(c, result)
+		}
+		return
+	}
+
+	if !found {
 		c.JSON(http.StatusForbidden, gin.H{
 			"message": "没有可用的账号",
 			"code":    constant.NotFound,
 		})
 		return
 	}
+	respondAccountsBusy(c, keyInfo, "All accounts are busy, please retry later")
+}
+
+// ListModels 返回API Key可以使用的模型列表（Anthropic 格式）
+func ListModels(c *gin.Context) {
+	apiKey, _ := c.Get("api_key")
+	keyInfo := apiKey.(*model.ApiKey)
+
+	models, err := service.ListApiKeyModels(keyInfo)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": map[string]any{
+				"type":    "api_error",
+				"message": "Failed to list models",
+			},
+		})
+		return
+	}
+
+	response := gin.H{
+		"data":     models,
+		"has_more": false,
+		"first_id": nil,
+		"last_id":  nil,
+	}
+	if len(models) > 0 {
+		response["first_id"] = models[0].ID
+		response["last_id"] = models[len(models)-1].ID
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// serve 调度账号并转发请求，没有可用账号时按分组配置排队或返回错误
+func (s *relaySession) serve(group *model.Group) {
+	c := s.c
+	if !s.checkModelRestriction() {
+		return
+	}
+
+	// 队列中已有同级或更高优先级的请求在等待时直接排队，避免插队抢占刚释放的账号
+	if group != nil && group.QueueEnabled && service.HasPriorityWaiters(group.ID, s.keyInfo.PriorityClass) {
+		relayFromWaitQueue(s, group)
+		return
+	}
+
+	result, found, err := s.relay()
+	if err != nil {
+		respondScheduleError(c, s.modelName, err)
+		return
+	}
+	if result != nil {
+		if result.Retryable {
+			respondAccountsExhausted(c, result)
+		}
+		return
+	}
+
+	// 主分组及备用分组都没有可用账号，或所有账号并发已满
+	if group == nil || !group.QueueEnabled {
+		if !found {
+			c.JSON(http.StatusForbidden, gin.H{
+				"message": "没有可用的账号",
+				"code":    constant.NotFound,
+			})
+			return
+		}
+		respondAccountsBusy(c, s.keyInfo, "All accounts are busy, please retry later")
+		return
+	}
+	relayFromWaitQueue(s, group)
+}
+
+// relaySession 单个客户端请求的中转上下文，在主分组、备用分组和排队重试之间共享
+type relaySession struct {
+	c                 *gin.Context
+	keyInfo           *model.ApiKey
+	chain             []service.GroupHop
+	body              []byte
+	modelName         string
+	sessionHash       string
+	requestID         string
+	writer            *firstByteWriter
+	handler           relayHandler              // 向单个账号转发请求的处理器
+	accountFilter     func(*model.Account) bool // 只使用满足条件的账号，可为nil
+	lightweight       bool                      // 轻量请求（如 count_tokens）：不对冲，也不计入账号健康度
+	offline           bool                      // 离线请求（如批处理）：不关心延迟，不对冲
+	attempts          int                       // 累计尝试次数，用于记录尝试序号
+	remainingAttempts int                       // 剩余尝试次数，所有分组、对冲和排队重试共用，用尽后不再尝试
+	boundAccountID    uint                      // 当前分组中会话绑定的账号ID，没有绑定记录时为0
+	onAdmitted        func()                    // 第一次占用到账号槽位时调用，可为nil
+}
+
+// relayHandler 向单个账号转发请求并返回本次尝试的结果
+type relayHandler func(c *gin.Context, account *model.Account) *relay.RelayResult
+
+// newRelaySession 创建中转上下文
+func newRelaySession(c *gin.Context, keyInfo *model.ApiKey, group *model.Group, body []byte) *relaySession {
+	// 记录首次写入响应的时间，用于统计账号的首字时间
+	writer := &firstByteWriter{ResponseWriter: c.Writer}
+	c.Writer = writer
+
+	return &relaySession{
+		c:         c,
+		keyInfo:   keyInfo,
+		chain:     service.ResolveGroupChain(keyInfo, group),
+		body:      body,
+		modelName: gjson.GetBytes(body, "model").String(),
+		// 会话粘性：同一会话优先使用上次处理它的账号，以保持提示词缓存命中
+		sessionHash:       service.SessionHashFromBody(body),
+		requestID:         c.GetString("request_id"),
+		writer:            writer,
+		handler:           relayToAccount,
+		remainingAttempts: relay.GetMaxAttempts(),
+	}
+}
+
+// checkModelRestriction 按客户端请求的原始模型校验API Key的模型限制，不允许时已写回错误
+// 只在调度前校验一次，切换到备用分组后映射出的模型不再受限制
+func (s *relaySession) checkModelRestriction() bool {
+	if s.modelName == "" {
+		return true
+	}
+	return relay.ValidateModelRestriction(s.c, s.keyInfo, s.modelName) == nil
+}
+
+// relay 依次在主分组和备用分组中调度账号并转发，剩余尝试次数用尽后不再切换到后面的分组
+// 没有任何账号可以尝试时返回nil，found 表示是否有分组存在可用账号（只是并发已满）
+// 所有分组都没有账号支持所请求的模型时返回 ErrModelNotAvailable
+func (s *relaySession) relay() (result *relay.RelayResult, found bool, err error) {
+	modelSupported := false
+	for i, hop := range s.chain {
+		if s.remainingAttempts <= 0 {
+			break
+		}
+		modelName := service.MapModelName(s.modelName, hop.ModelMapping)
+		accounts, err := service.ScheduleAccounts(hop.GroupID, hop.Group, modelName)
+		if errors.Is(err, service.ErrModelNotAvailable) {
+			continue
+		}
+		if err != nil {
+			return nil, found, err
+		}
+		modelSupported = true
+		if s.accountFilter != nil {
+			accounts = filterAccounts(accounts, s.accountFilter)
+		}
+		if len(accounts) == 0 {
+			continue
+		}
+		found = true
+
+		body := s.body
+		if modelName != s.modelName {
+			if body, err = sjson.SetBytes(s.body, "model", modelName); err != nil {
+				return nil, found, err
+			}
+		}
+		if i > 0 {
+			log.Printf("[%s] 切换到备用分组 %d，模型: %s", s.requestID, hop.GroupID, modelName)
+		}
+
+		accounts, s.boundAccountID = service.ApplySessionAffinity(hop.GroupID, s.sessionHash, accounts)
+		relay.SetGroupBetaPolicy(s.c, hop.Group)
+		var hopResult *relay.RelayResult
+		if len(accounts) > 1 && s.shouldHedge(hop.Group) {
+			hopResult = s.relayHedged(hop.GroupID, hop.Group, accounts, body, i < len(s.chain)-1)
+		} else {
+			hopResult = s.relayWithFailover(hop.GroupID, accounts, body, i < len(s.chain)-1)
+		}
+		if hopResult == nil {
+			continue
+		}
+		result = hopResult
+		if !result.Retryable {
+			return result, found, nil
+		}
+	}
+
+	if !modelSupported {
+		return nil, false, service.ErrModelNotAvailable
+	}
+	return result, found, nil
+}
+
+// filterAccounts 保留满足条件的账号，保持原有顺序
+func filterAccounts(accounts []model.Account, keep func(*model.Account) bool) []model.Account {
+	filtered := make([]model.Account, 0, len(accounts))
+	for i := range accounts {
+		if keep(&accounts[i]) {
+			filtered = append(filtered, accounts[i])
+		}
+	}
+	return filtered
+}
+
+// respondScheduleError 调度账号失败时返回错误
+func respondScheduleError(c *gin.Context, modelName string, err error) {
+	if errors.Is(err, service.ErrModelNotAvailable) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": map[string]any{
+				"type":    "model_not_available",
+				"message": fmt.Sprintf("No account in this group supports model %q", modelName),
+			},
+		})
+		return
+	}
 
-	// 选择第一个账号（已按优先级和使用次数排序）
-	selectedAccount := accounts[0]
+	c.JSON(http.StatusInternalServerError, gin.H{
+		"message": "查询账号列表失败",
+		"code":    constant.InternalServerError,
+	})
+}
+
+// relayFromWaitQueue 在主分组队列中排队，按先进先出的顺序等待账号空闲或限流到期后再转发
+func relayFromWaitQueue(s *relaySession, group *model.Group) {
+	c := s.c
+	ticket, err := service.EnterWaitQueue(group, s.keyInfo.PriorityClass)
+	if err != nil {
+		respondAccountsBusy(c, s.keyInfo, "Too many requests are waiting for an available account")
+		return
+	}
+	defer ticket.Leave()
+
+	// 一旦占用到账号槽位立即离开队列，让后面的请求继续调度
+	s.onAdmitted = ticket.Leave
+
+	deadline := time.Now().Add(service.GetQueueMaxWait(group))
+	for ticket.Wait(c.Request.Context(), deadline) {
+		result, _, err := s.relay()
+		if errors.Is(err, service.ErrModelNotAvailable) {
+			respondScheduleError(c, s.modelName, err)
+			return
+		}
+		if err != nil {
+			log.Printf("[%s] 排队期间查询账号列表失败: %v", s.requestID, err)
+			continue
+		}
+		if result == nil {
+			continue
+		}
+		if result.Retryable {
+			respondAccountsExhausted(c, result)
+		}
+		return
+	}
 
-	// 根据平台类型路由到不同的处理器
-	switch selectedAccount.PlatformType {
+	// 客户端已断开时无需响应
+	if c.Request.Context().Err() != nil {
+		return
+	}
+	respondAccountsBusy(c, s.keyInfo, "Timed out waiting for an available account")
+}
+
+// respondAccountsBusy 账号全部满载或排队失败时返回错误，并通过 Retry-After 提示客户端重试时间
+// 交互式通道返回 overloaded_error，低优先级通道返回 429 rate_limit_error，让批处理任务先退避
+func respondAccountsBusy(c *gin.Context, keyInfo *model.ApiKey, message string) {
+	statusCode := 529
+	errorType := "overloaded_error"
+	if service.IsLowPriorityClass(keyInfo.PriorityClass) {
+		statusCode = http.StatusTooManyRequests
+		errorType = "rate_limit_error"
+	}
+
+	c.Header("Retry-After", strconv.Itoa(service.EstimateRetryAfter(keyInfo.GroupID)))
+	c.JSON(statusCode, gin.H{
+		"error": map[string]any{
+			"type":    errorType,
+			"message": message,
+		},
+	})
+}
+
+// relayWithFailover 按调度顺序尝试分组内的候选账号，直到成功或请求的剩余尝试次数用尽
+// 并发已满的账号会被跳过且不计入尝试次数；没有任何账号可以尝试时返回nil
+// hasFallback 表示后面还有备用分组，此时最后一个账号的可重试失败也不会写回客户端
+func (s *relaySession) relayWithFailover(groupID int, accounts []model.Account, body []byte, hasFallback bool) *relay.RelayResult {
+	c := s.c
+
+	var result *relay.RelayResult
+	for i := range accounts {
+		if s.remainingAttempts <= 0 {
+			break
+		}
+		selectedAccount := &accounts[i]
+
+		// 熔断中或探测名额已满的账号与并发已满的账号一样直接跳过
+		permit, ok := service.AcquireBreakerPermit(selectedAccount.ID)
+		if !ok {
+			continue
+		}
+		lease, ok := service.AcquireAccountSlot(c.Request.Context(), selectedAccount, s.keyInfo.PriorityClass)
+		if !ok {
+			permit.Abandon()
+			continue
+		}
+		s.attempts++
+		s.remainingAttempts--
+		if s.onAdmitted != nil {
+			s.onAdmitted()
+		}
+
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		relay.SetFailover(c, s.remainingAttempts > 0 && (i < len(accounts)-1 || hasFallback))
+
+		attemptStart := time.Now()
+		result = s.handler(c, selectedAccount)
+		lease.Release()
+		duration := time.Since(attemptStart)
+		recordRelayAttempt(s.requestID, s.attempts, s.keyInfo.ID, selectedAccount.ID, result, duration)
+		if !s.lightweight {
+			recordAccountHealth(c, selectedAccount.ID, result, attemptStart, s.writer.firstWrite)
+		}
+		reportCircuitBreaker(c, permit, result)
+
+		if result.Success() {
+			s.bindSession(groupID, selectedAccount.ID)
+			// 普通故障转移同样记录成功的非流式请求耗时，使对冲延迟按分组的实际延迟计算
+			if !s.lightweight && !gjson.GetBytes(body, "stream").Bool() {
+				service.RecordGroupLatency(groupID, duration)
+			}
+		}
+		if !result.Retryable {
+			return result
+		}
+		log.Printf("[%s] 账号 %s 第%d次尝试失败: %s，切换下一个账号", s.requestID, selectedAccount.Name, s.attempts, result.Reason)
+	}
+
+	return result
+}
+
+// bindSession 请求成功后将会话绑定到最终处理请求的账号，并记录一次会话粘性结果（轻量请求不计入统计）
+func (s *relaySession) bindSession(groupID int, accountID uint) {
+	service.BindSession(groupID, s.sessionHash, accountID)
+	if !s.lightweight {
+		service.RecordSessionAffinity(s.sessionHash, s.boundAccountID, accountID)
+	}
+}
+
+// respondAccountsExhausted 所有尝试过的账号都失败时返回最后一次失败对应的错误
+func respondAccountsExhausted(c *gin.Context, lastResult *relay.RelayResult) {
+	statusCode := 529
+	errorType := "overloaded_error"
+	message := "All accounts failed: " + lastResult.Reason
+
+	// 所有账号都因客户端请求本身被拒绝时，按请求错误返回
+	if lastResult.ClientError {
+		statusCode = lastResult.StatusCode
+		errorType = "invalid_request_error"
+	} else if lastResult.StatusCode == http.StatusTooManyRequests {
+		statusCode = http.StatusTooManyRequests
+		errorType = "rate_limit_error"
+	}
+
+	c.JSON(statusCode, gin.H{
+		"error": map[string]any{
+			"type":    errorType,
+			"message": message,
+		},
+	})
+}
+
+// relayToAccount 根据平台类型将请求路由到不同的处理器
+func relayToAccount(c *gin.Context, account *model.Account) *relay.RelayResult {
+	switch account.PlatformType {
+	case constant.PlatformClaude:
+		return relay.HandleClaudeRequest(c, account)
+	case constant.PlatformClaudeConsole:
+		return relay.HandleClaudeConsoleRequest(c, account)
+	case constant.PlatformOpenAI, constant.PlatformAzureOpenAI:
+		return relay.HandleOpenAIRequest(c, account)
+	case constant.PlatformGemini:
+		return relay.HandleGeminiRequest(c, account)
+	case constant.PlatformBedrock:
+		return relay.HandleBedrockRequest(c, account)
+	case constant.PlatformVertex:
+		return relay.HandleVertexRequest(c, account)
+	default:
+		reason := "不支持的平台类型: " + account.PlatformType
+		if relay.CanFailover(c) {
+			return &relay.RelayResult{Retryable: true, Reason: reason}
+		}
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": reason,
+			"code":    constant.InvalidParams,
+		})
+		return &relay.RelayResult{StatusCode: http.StatusBadRequest, Reason: reason}
+	}
+}
+
+// countTokensToAccount 根据平台类型将 count_tokens 请求路由到不同的处理器
+func countTokensToAccount(c *gin.Context, account *model.Account) *relay.RelayResult {
+	switch account.PlatformType {
 	case constant.PlatformClaude:
-		relay.HandleClaudeRequest(c, &selectedAccount)
+		return relay.HandleClaudeCountTokens(c, account)
 	case constant.PlatformClaudeConsole:
-		relay.HandleClaudeConsoleRequest(c, &selectedAccount)
-	case constant.PlatformOpenAI:
-		relay.HandleOpenAIRequest(c, &selectedAccount)
+		return relay.HandleClaudeConsoleCountTokens(c, account)
+	case constant.PlatformOpenAI, constant.PlatformAzureOpenAI, constant.PlatformGemini, constant.PlatformBedrock, constant.PlatformVertex:
+		return relay.HandleEstimatedCountTokens(c, account)
 	default:
+		reason := "不支持的平台类型: " + account.PlatformType
+		if relay.CanFailover(c) {
+			return &relay.RelayResult{Retryable: true, Reason: reason}
+		}
 		c.JSON(http.StatusBadRequest, gin.H{
-			"message": "不支持的平台类型: " + selectedAccount.PlatformType,
+			"message": reason,
 			"code":    constant.InvalidParams,
 		})
+		return &relay.RelayResult{StatusCode: http.StatusBadRequest, Reason: reason}
+	}
+}
+
+// recordRelayAttempt 异步记录单次中转尝试
+func recordRelayAttempt(requestID string, attempt int, apiKeyID, accountID uint, result *relay.RelayResult, duration time.Duration) {
+	relayAttempt := &model.RelayAttempt{
+		RequestID:  requestID,
+		Attempt:    attempt,
+		AccountID:  accountID,
+		ApiKeyID:   apiKeyID,
+		StatusCode: result.StatusCode,
+		Success:    result.Success(),
+		Reason:     result.Reason,
+		Duration:   duration.Milliseconds(),
+	}
+
+	go func() {
+		if err := model.CreateRelayAttempt(relayAttempt); err != nil {
+			log.Printf("保存中转尝试记录失败: %v", err)
+		}
+	}()
+}
+
+// recordAccountHealth 根据本次尝试结果更新账号健康度，客户端主动断开或客户端请求错误不计入
+func recordAccountHealth(c *gin.Context, accountID uint, result *relay.RelayResult, start, firstWrite time.Time) {
+	if c.Request.Context().Err() != nil {
+		return
+	}
+
+	if result.Success() {
+		ttft := time.Duration(-1)
+		if !firstWrite.IsZero() {
+			ttft = firstWrite.Sub(start)
+		}
+		go service.RecordAccountHealth(accountID, false, ttft, time.Since(start))
+		return
+	}
+
+	if isAccountFault(result) {
+		go service.RecordAccountHealth(accountID, true, -1, -1)
+	}
+}
+
+// reportCircuitBreaker 将本次尝试结果反馈给账号熔断器
+// 限流、认证失败由账号的限流、异常状态单独处理，不计入熔断
+func reportCircuitBreaker(c *gin.Context, permit *service.BreakerPermit, result *relay.RelayResult) {
+	switch {
+	case result.Success():
+		permit.Success()
+	case c.Request.Context().Err() != nil, !isAccountFault(result),
+		result.StatusCode == http.StatusTooManyRequests, result.StatusCode == http.StatusUnauthorized, result.StatusCode == http.StatusForbidden:
+		permit.Abandon()
+	default:
+		permit.Failure()
+	}
+}
+
+// isAccountFault 判断失败是否由账号或上游导致（而非客户端请求本身有误）
+func isAccountFault(result *relay.RelayResult) bool {
+	switch {
+	case result.ClientError:
+		return false
+	case result.Retryable, result.StatusCode == 0, result.StatusCode >= http.StatusInternalServerError:
+		return true
+	case result.StatusCode == http.StatusUnauthorized, result.StatusCode == http.StatusForbidden, result.StatusCode == http.StatusTooManyRequests:
+		return true
+	default:
+		return false
+	}
+}
+
+// firstByteWriter 记录首次写入响应体的时间
+type firstByteWriter struct {
+	gin.ResponseWriter
+	firstWrite time.Time
+}
+
+func (w *firstByteWriter) Write(data []byte) (int, error) {
+	if w.firstWrite.IsZero() {
+		w.firstWrite = time.Now()
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *firstByteWriter) WriteString(s string) (int, error) {
+	if w.firstWrite.IsZero() {
+		w.firstWrite = time.Now()
 	}
+	return w.ResponseWriter.WriteString(s)
 }
 
 // TestGetMessages 测试账号连接
@@ -204,8 +777,14 @@ func executeAccountTest(account *model.Account) TestAccountResponse {
 		statusCode, errorMsg = relay.TestsHandleClaudeRequest(account)
 	case constant.PlatformClaudeConsole:
 		statusCode, errorMsg = relay.TestHandleClaudeConsoleRequest(account)
-	case constant.PlatformOpenAI:
+	case constant.PlatformOpenAI, constant.PlatformAzureOpenAI:
 		statusCode, errorMsg = relay.TestHandleOpenAIRequest(account)
+	case constant.PlatformGemini:
+		statusCode, errorMsg = relay.TestHandleGeminiRequest(account)
+	case constant.PlatformBedrock:
+		statusCode, errorMsg = relay.TestHandleBedrockRequest(account)
+	case constant.PlatformVertex:
+		statusCode, errorMsg = relay.TestHandleVertexRequest(account)
 	default:
 		return TestAccountResponse{
 			Success:      false,
@@ -0,0 +1,84 @@
+package controller
+
+import (
+	"claude-code-relay/relay"
+	"claude-code-relay/service"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+func newTestContext() (*gin.Context, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	return c, recorder
+}
+
+func TestIsAccountFault(t *testing.T) {
+	tests := []struct {
+		name   string
+		result *relay.RelayResult
+		want   bool
+	}{
+		{name: "retryable upstream failure", result: &relay.RelayResult{StatusCode: http.StatusServiceUnavailable, Retryable: true, Reason: "503"}, want: true},
+		{name: "network error", result: &relay.RelayResult{Reason: "timeout"}, want: true},
+		{name: "rate limited", result: &relay.RelayResult{StatusCode: http.StatusTooManyRequests, Reason: "429"}, want: true},
+		{name: "bad request", result: &relay.RelayResult{StatusCode: http.StatusBadRequest, Reason: "400"}, want: false},
+		{name: "unsupported beta", result: &relay.RelayResult{StatusCode: http.StatusBadRequest, Retryable: true, ClientError: true, Reason: "beta"}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isAccountFault(tt.result); got != tt.want {
+				t.Errorf("isAccountFault() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientErrorDoesNotTripBreaker(t *testing.T) {
+	const accountID = 9301
+	t.Cleanup(func() { service.ResetCircuitBreaker(accountID) })
+
+	c, _ := newTestContext()
+	result := &relay.RelayResult{StatusCode: http.StatusBadRequest, Retryable: true, ClientError: true, Reason: "账号不支持 beta: context-1m-2025-08-07"}
+	for i := 0; i < 20; i++ {
+		permit, ok := service.AcquireBreakerPermit(accountID)
+		if !ok {
+			t.Fatalf("attempt %d: permit refused, state %s", i+1, service.GetCircuitBreakerStatus(accountID).State)
+		}
+		reportCircuitBreaker(c, permit, result)
+	}
+	if state := service.GetCircuitBreakerStatus(accountID).State; state != service.CircuitClosed {
+		t.Errorf("state after client errors = %s, want %s", state, service.CircuitClosed)
+	}
+}
+
+func TestRespondAccountsExhausted(t *testing.T) {
+	tests := []struct {
+		name       string
+		result     *relay.RelayResult
+		wantStatus int
+		wantType   string
+	}{
+		{name: "upstream failures", result: &relay.RelayResult{StatusCode: http.StatusBadGateway, Retryable: true, Reason: "502"}, wantStatus: 529, wantType: "overloaded_error"},
+		{name: "rate limited", result: &relay.RelayResult{StatusCode: http.StatusTooManyRequests, Retryable: true, Reason: "429"}, wantStatus: http.StatusTooManyRequests, wantType: "rate_limit_error"},
+		{name: "client error", result: &relay.RelayResult{StatusCode: http.StatusBadRequest, Retryable: true, ClientError: true, Reason: "beta"}, wantStatus: http.StatusBadRequest, wantType: "invalid_request_error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, recorder := newTestContext()
+			respondAccountsExhausted(c, tt.result)
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+			if got := gjson.GetBytes(recorder.Body.Bytes(), "error.type").String(); got != tt.wantType {
+				t.Errorf("error.type = %q, want %q", got, tt.wantType)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package controller
+
+import (
+	"bytes"
+	"claude-code-relay/model"
+	"claude-code-relay/relay"
+	"claude-code-relay/service"
+	"context"
+	"io"
+	"log"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// hedgeEngine 对冲请求使用的独立 gin 引擎，仅用于创建写入内存的上下文
+var hedgeEngine = gin.New()
+
+// hedgeAttempt 对冲请求中的一次尝试，响应先写入内存，胜出后再复制给客户端
+type hedgeAttempt struct {
+	id       int32
+	account  *model.Account
+	ctx      *gin.Context
+	writer   *firstByteWriter
+	recorder *httptest.ResponseRecorder
+	cancel   context.CancelFunc
+	winner   *atomic.Int32
+	result   *relay.RelayResult
+}
+
+// claim 争夺计费权，最先成功完成的尝试胜出，可重复调用
+func (a *hedgeAttempt) claim() bool {
+	return a.winner.CompareAndSwap(0, a.id) || a.winner.Load() == a.id
+}
+
+// shouldHedge 判断请求是否在该分组内使用对冲：分组开启对冲且客户端请求为非流式的在线请求
+func (s *relaySession) shouldHedge(group *model.Group) bool {
+	return !s.lightweight && !s.offline && service.IsHedgeEnabled(group) && !gjson.GetBytes(s.body, "stream").Bool()
+}
+
+// relayHedged 对冲转发：先向主账号发出请求，超过分组的延迟百分位仍未完成（或主账号失败）时
+// 向第二个账号发出同样的请求，返回最先成功的响应并取消另一个；只有胜出的尝试会记录日志和计费
+// 两个账号都可重试地失败时，继续按普通故障转移尝试剩余账号；对冲请求同样消耗请求的剩余尝试次数
+func (s *relaySession) relayHedged(groupID int, group *model.Group, accounts []model.Account, body []byte, hasFallback bool) *relay.RelayResult {
+	var winner atomic.Int32
+	results := make(chan *hedgeAttempt, 2)
+
+	primary, next := s.startHedgeAttempt(groupID, accounts, 0, body, &winner, results)
+	if primary == nil {
+		return nil
+	}
+	defer primary.cancel()
+
+	delay := service.GetHedgeDelay(groupID, group)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	var secondary *hedgeAttempt
+	defer func() {
+		if secondary != nil {
+			secondary.cancel()
+		}
+	}()
+	startSecondary := func(reason string) int {
+		if secondary != nil || s.remainingAttempts <= 0 {
+			return 0
+		}
+		secondary, next = s.startHedgeAttempt(groupID, accounts, next, body, &winner, results)
+		if secondary == nil {
+			return 0
+		}
+		log.Printf("[%s] %s，对冲请求发往账号 %s", s.requestID, reason, secondary.account.Name)
+		return 1
+	}
+
+	var result *relay.RelayResult
+	pending := 1
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			pending += startSecondary("主账号 " + primary.account.Name + " 超过 " + delay.String() + " 未完成")
+		case attempt := <-results:
+			pending--
+			if attempt.result.Success() {
+				// 另一个尝试已先完成并取得计费权时，等待它的结果
+				if !attempt.claim() {
+					continue
+				}
+				s.writeHedgeResponse(attempt)
+				s.bindSession(groupID, attempt.account.ID)
+				return attempt.result
+			}
+			if !attempt.result.Retryable {
+				s.writeHedgeResponse(attempt)
+				return attempt.result
+			}
+			result = attempt.result
+			log.Printf("[%s] 账号 %s 第%d次尝试失败: %s", s.requestID, attempt.account.Name, attempt.id, result.Reason)
+			pending += startSecondary("账号 " + attempt.account.Name + " 失败")
+		case <-s.c.Request.Context().Done():
+			return &relay.RelayResult{Reason: "客户端已断开"}
+		}
+	}
+
+	if next < len(accounts) && s.remainingAttempts > 0 {
+		if rest := s.relayWithFailover(groupID, accounts[next:], body, hasFallback); rest != nil {
+			return rest
+		}
+	}
+	return result
+}
+
+// startHedgeAttempt 从 start 开始占用第一个可用账号并在后台发出请求，返回该尝试及下一个候选账号的下标
+func (s *relaySession) startHedgeAttempt(groupID int, accounts []model.Account, start int, body []byte, winner *atomic.Int32, results chan<- *hedgeAttempt) (*hedgeAttempt, int) {
+	c := s.c
+	for i := start; i < len(accounts); i++ {
+		account := &accounts[i]
+		permit, ok := service.AcquireBreakerPermit(account.ID)
+		if !ok {
+			continue
+		}
+		lease, ok := service.AcquireAccountSlot(c.Request.Context(), account, s.keyInfo.PriorityClass)
+		if !ok {
+			permit.Abandon()
+			continue
+		}
+		s.attempts++
+		s.remainingAttempts--
+		if s.onAdmitted != nil {
+			s.onAdmitted()
+		}
+
+		attempt := newHedgeAttempt(c, int32(s.attempts), account, body, winner)
+		go func() {
+			attemptStart := time.Now()
+			attempt.result = s.handler(attempt.ctx, account)
+			lease.Release()
+			duration := time.Since(attemptStart)
+			recordRelayAttempt(s.requestID, int(attempt.id), s.keyInfo.ID, account.ID, attempt.result, duration)
+			recordAccountHealth(attempt.ctx, account.ID, attempt.result, attemptStart, attempt.writer.firstWrite)
+			reportCircuitBreaker(attempt.ctx, permit, attempt.result)
+			if attempt.result.Success() {
+				service.RecordGroupLatency(groupID, duration)
+			}
+			results <- attempt
+		}()
+		return attempt, i + 1
+	}
+	return nil, len(accounts)
+}
+
+// newHedgeAttempt 为一次对冲尝试创建独立的请求上下文，响应写入内存，可单独取消
+func newHedgeAttempt(c *gin.Context, id int32, account *model.Account, body []byte, winner *atomic.Int32) *hedgeAttempt {
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	recorder := httptest.NewRecorder()
+	hc := gin.CreateTestContextOnly(recorder, hedgeEngine)
+	writer := &firstByteWriter{ResponseWriter: hc.Writer}
+	hc.Writer = writer
+
+	hc.Request = c.Request.Clone(ctx)
+	hc.Request.Body = io.NopCloser(bytes.NewReader(body))
+	hc.Request.ContentLength = int64(len(body))
+	for key, value := range c.Keys {
+		hc.Set(key, value)
+	}
+
+	attempt := &hedgeAttempt{
+		id:       id,
+		account:  account,
+		ctx:      hc,
+		writer:   writer,
+		recorder: recorder,
+		cancel:   cancel,
+		winner:   winner,
+	}
+	// 可重试的失败不写入响应，由对冲逻辑决定是否切换账号
+	relay.SetFailover(hc, true)
+	relay.SetHedgeClaim(hc, attempt.claim)
+	return attempt
+}
+
+// writeHedgeResponse 将胜出尝试的响应复制给客户端
+func (s *relaySession) writeHedgeResponse(attempt *hedgeAttempt) {
+	header := s.c.Writer.Header()
+	for key, values := range attempt.recorder.Header() {
+		header[key] = values
+	}
+	s.c.Writer.WriteHeader(attempt.recorder.Code)
+	if _, err := s.c.Writer.Write(attempt.recorder.Body.Bytes()); err != nil {
+		log.Printf("[%s] 写入对冲响应失败: %v", s.requestID, err)
+	}
+}
@@ -18,6 +18,7 @@ type LogQueryRequest struct {
 	Limit     int      `form:"limit"`      // 每页数量，默认为10，最大100
 	UserID    uint     `form:"user_id"`    // 用户ID筛选
 	AccountID uint     `form:"account_id"` // 账号ID筛选
+	GroupID   int      `form:"group_id"`   // 实际处理请求的分组ID筛选
 	ApiKeyID  uint     `form:"api_key_id"` // API Key ID筛选
 	ModelName string   `form:"model_name"` // 模型名称筛选
 	IsStream  *bool    `form:"is_stream"`  // 是否流式请求筛选
@@ -96,6 +97,27 @@ func GetLogById(c *gin.Context) {
 	})
 }
 
+// GetRelayAttempts 获取指定请求ID的故障转移尝试记录（管理员）
+func GetRelayAttempts(c *gin.Context) {
+	requestID := c.Param("request_id")
+
+	logService := service.NewLogService()
+	attempts, err := logService.GetRelayAttempts(requestID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+			"code":  constant.InvalidParams,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "获取尝试记录成功",
+		"code":    constant.Success,
+		"data":    attempts,
+	})
+}
+
 // GetMyLogs 获取当前用户的日志记录
 func GetMyLogs(c *gin.Context) {
 	// 从上下文获取当前用户信息
@@ -373,8 +395,16 @@ func GetMyUsageStats(c *gin.Context) {
 
 // GetDashboardStats 获取仪表盘统计数据
 func GetDashboardStats(c *gin.Context) {
+	user := c.MustGet("user").(*model.User)
+	var userID *uint
+
+	// 普通用户只能查看自己账号的预算
+	if user.Role != "admin" {
+		userID = &user.ID
+	}
+
 	logService := service.NewLogService()
-	result, err := logService.GetDashboardStats()
+	result, err := logService.GetDashboardStats(userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "获取仪表盘统计数据失败: " + err.Error(),
@@ -402,6 +432,10 @@ func buildLogFilters(req *LogQueryRequest) *model.LogFilters {
 		filters.AccountID = &req.AccountID
 	}
 
+	if req.GroupID > 0 {
+		filters.GroupID = &req.GroupID
+	}
+
 	if req.ApiKeyID > 0 {
 		filters.ApiKeyID = &req.ApiKeyID
 	}
@@ -0,0 +1,319 @@
+package controller
+
+import (
+	"bytes"
+	"claude-code-relay/common"
+	"claude-code-relay/model"
+	"claude-code-relay/relay"
+	"claude-code-relay/service"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"gorm.io/gorm"
+)
+
+const (
+	// 批处理接口路径，用于生成结果下载地址
+	messageBatchesPath = "/v1/messages/batches"
+
+	// 批处理列表的默认及最大分页大小
+	defaultMessageBatchLimit = 20
+	maxMessageBatchLimit     = 1000
+)
+
+// batchEngine 批处理执行使用的独立 gin 引擎，仅用于创建写入内存的上下文
+var batchEngine = gin.New()
+
+// CreateMessageBatchRequest 创建批处理请求参数
+type CreateMessageBatchRequest struct {
+	Requests []service.MessageBatchRequest `json:"requests"`
+}
+
+// CreateMessageBatch 创建消息批处理，请求保存后由后台执行器在分组内的账号上异步执行
+func CreateMessageBatch(c *gin.Context) {
+	keyInfo := c.MustGet("api_key").(*model.ApiKey)
+
+	var req CreateMessageBatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBatchError(c, http.StatusBadRequest, "invalid_request_error", "Invalid request body: "+err.Error())
+		return
+	}
+	if err := service.ValidateMessageBatchRequests(keyInfo, req.Requests); err != nil {
+		respondBatchError(c, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+
+	batch, err := service.CreateMessageBatch(keyInfo, req.Requests, c.MustGet("client_info").(common.ClientInfo))
+	if err != nil {
+		respondBatchError(c, http.StatusInternalServerError, "api_error", "Failed to create message batch")
+		return
+	}
+	respondMessageBatch(c, batch)
+}
+
+// GetMessageBatch 查询批处理状态
+func GetMessageBatch(c *gin.Context) {
+	batch, ok := loadMessageBatch(c)
+	if !ok {
+		return
+	}
+	respondMessageBatch(c, batch)
+}
+
+// ListMessageBatches 按创建时间倒序分页列出API Key的批处理
+func ListMessageBatches(c *gin.Context) {
+	keyInfo := c.MustGet("api_key").(*model.ApiKey)
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultMessageBatchLimit)))
+	if err != nil || limit < 1 || limit > maxMessageBatchLimit {
+		respondBatchError(c, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("limit: must be between 1 and %d", maxMessageBatchLimit))
+		return
+	}
+
+	var beforeID, afterID uint
+	for param, target := range map[string]*uint{"before_id": &beforeID, "after_id": &afterID} {
+		cursor := c.Query(param)
+		if cursor == "" {
+			continue
+		}
+		batch, err := model.GetMessageBatch(cursor, keyInfo.ID)
+		if err != nil {
+			respondBatchError(c, http.StatusBadRequest, "invalid_request_error", param+": message batch not found")
+			return
+		}
+		*target = batch.ID
+	}
+
+	batches, err := model.GetMessageBatches(keyInfo.ID, beforeID, afterID, limit+1)
+	if err != nil {
+		respondBatchError(c, http.StatusInternalServerError, "api_error", "Failed to list message batches")
+		return
+	}
+	hasMore := len(batches) > limit
+	if hasMore {
+		// 多查询的一条位于离游标最远的一端
+		if beforeID > 0 {
+			batches = batches[1:]
+		} else {
+			batches = batches[:limit]
+		}
+	}
+
+	infos, err := service.BuildMessageBatchInfos(batches, messageBatchResultsURL(c))
+	if err != nil {
+		respondBatchError(c, http.StatusInternalServerError, "api_error", "Failed to list message batches")
+		return
+	}
+
+	response := gin.H{
+		"data":     infos,
+		"has_more": hasMore,
+		"first_id": nil,
+		"last_id":  nil,
+	}
+	if len(infos) > 0 {
+		response["first_id"] = infos[0].ID
+		response["last_id"] = infos[len(infos)-1].ID
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// CancelMessageBatch 取消批处理：尚未执行的请求标记为已取消，执行中的请求完成后批处理结束
+func CancelMessageBatch(c *gin.Context) {
+	batch, ok := loadMessageBatch(c)
+	if !ok {
+		return
+	}
+	if err := model.CancelMessageBatch(batch); err != nil {
+		respondBatchError(c, http.StatusInternalServerError, "api_error", "Failed to cancel message batch")
+		return
+	}
+	respondMessageBatch(c, batch)
+}
+
+// GetMessageBatchResults 以JSONL格式流式返回已结束批处理的全部结果
+func GetMessageBatchResults(c *gin.Context) {
+	batch, ok := loadMessageBatch(c)
+	if !ok {
+		return
+	}
+	if batch.ProcessingStatus != model.BatchStatusEnded {
+		respondBatchError(c, http.StatusBadRequest, "invalid_request_error", "Message batch "+batch.BatchID+" has not ended yet")
+		return
+	}
+
+	c.Header("Content-Type", "application/x-jsonl")
+	c.Status(http.StatusOK)
+	err := service.WriteMessageBatchResults(batch, func(line []byte) error {
+		_, err := c.Writer.Write(line)
+		return err
+	})
+	if err != nil {
+		common.SysError(fmt.Sprintf("导出批处理 %s 结果失败: %v", batch.BatchID, err))
+	}
+}
+
+// loadMessageBatch 获取路径参数对应的批处理，不存在时已写回错误
+func loadMessageBatch(c *gin.Context) (*model.MessageBatch, bool) {
+	keyInfo := c.MustGet("api_key").(*model.ApiKey)
+	batch, err := model.GetMessageBatch(c.Param("id"), keyInfo.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		respondBatchError(c, http.StatusNotFound, "not_found_error", "Message batch not found")
+		return nil, false
+	}
+	if err != nil {
+		respondBatchError(c, http.StatusInternalServerError, "api_error", "Failed to query message batch")
+		return nil, false
+	}
+	return batch, true
+}
+
+// respondMessageBatch 返回单个批处理的信息
+func respondMessageBatch(c *gin.Context, batch *model.MessageBatch) {
+	infos, err := service.BuildMessageBatchInfos([]model.MessageBatch{*batch}, messageBatchResultsURL(c))
+	if err != nil {
+		respondBatchError(c, http.StatusInternalServerError, "api_error", "Failed to query message batch")
+		return
+	}
+	c.JSON(http.StatusOK, infos[0])
+}
+
+// respondBatchError 返回 Anthropic 格式的错误
+func respondBatchError(c *gin.Context, statusCode int, errorType, message string) {
+	c.JSON(statusCode, gin.H{
+		"error": map[string]any{
+			"type":    errorType,
+			"message": message,
+		},
+	})
+}
+
+// messageBatchResultsURL 根据当前请求地址生成结果下载地址（SDK 直接请求该地址，因此需要完整URL）
+func messageBatchResultsURL(c *gin.Context) func(batchID string) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	prefix := c.Request.URL.Path
+	if idx := strings.Index(prefix, messageBatchesPath); idx >= 0 {
+		prefix = prefix[:idx]
+	}
+	baseURL := scheme + "://" + c.Request.Host + prefix + messageBatchesPath
+
+	return func(batchID string) string {
+		return baseURL + "/" + batchID + "/results"
+	}
+}
+
+// ExecuteMessageBatchItem 以API Key的身份执行一条批处理请求
+// 与对话接口使用相同的账号调度、故障转移和计费，响应写入内存后转换为批处理结果，日志记录提交批处理的客户端
+func ExecuteMessageBatchItem(ctx context.Context, apiKey *model.ApiKey, client common.ClientInfo, params []byte) service.BatchItemOutcome {
+	body, err := sjson.DeleteBytes(params, "stream")
+	if err != nil {
+		return service.BatchItemOutcome{Error: batchErrorObject(http.StatusBadRequest, err.Error())}
+	}
+
+	recorder := httptest.NewRecorder()
+	c := gin.CreateTestContextOnly(recorder, batchEngine)
+	c.Request, err = http.NewRequestWithContext(ctx, http.MethodPost, "/v1/messages", bytes.NewReader(body))
+	if err != nil {
+		return service.BatchItemOutcome{Error: batchErrorObject(http.StatusInternalServerError, err.Error())}
+	}
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Request.Header.Set("anthropic-version", "2023-06-01")
+	c.Set("request_id", common.GenerateUUID())
+	c.Set("api_key_id", apiKey.ID)
+	c.Set("api_key", apiKey)
+	c.Set("user_id", apiKey.UserID)
+	c.Set("group_id", apiKey.GroupID)
+	c.Set("client_info", client)
+
+	group := service.GetApiKeyGroup(apiKey)
+	session := newRelaySession(c, apiKey, group, body)
+	session.offline = true
+	if !session.checkModelRestriction() {
+		return service.BatchItemOutcome{Counted: true, Error: batchErrorFromResponse(recorder.Code, recorder.Body.Bytes())}
+	}
+
+	result, _, err := session.relay()
+	switch {
+	case errors.Is(err, service.ErrModelNotAvailable):
+		message := fmt.Sprintf("No account in this group supports model %q", session.modelName)
+		return service.BatchItemOutcome{Error: batchErrorObject(http.StatusBadRequest, message)}
+	case err != nil:
+		return service.BatchItemOutcome{Retry: true, Error: batchErrorObject(http.StatusInternalServerError, err.Error())}
+	case result == nil:
+		// 没有空闲账号，稍后重试，不计入执行次数
+		return service.BatchItemOutcome{Retry: true}
+	case result.Retryable:
+		message := "All accounts failed: " + result.Reason
+		return service.BatchItemOutcome{Retry: true, Counted: true, Error: batchErrorObject(529, message)}
+	}
+
+	if recorder.Code >= http.StatusOK && recorder.Code < http.StatusMultipleChoices {
+		message, err := relay.AssembleClaudeMessage(recorder.Body.Bytes())
+		if err != nil {
+			return service.BatchItemOutcome{Retry: true, Counted: true, Error: batchErrorObject(http.StatusBadGateway, err.Error())}
+		}
+		return service.BatchItemOutcome{Message: message, Counted: true}
+	}
+
+	// 限流和上游错误稍后重试，其余错误（如请求参数错误）直接作为结果
+	return service.BatchItemOutcome{
+		Retry:   recorder.Code == http.StatusTooManyRequests || recorder.Code >= http.StatusInternalServerError,
+		Counted: true,
+		Error:   batchErrorFromResponse(recorder.Code, recorder.Body.Bytes()),
+	}
+}
+
+// batchErrorFromResponse 将错误响应转换为 Anthropic 格式的错误对象
+// 上游错误保留原有的类型和信息，中转自身的错误按状态码推断类型
+func batchErrorFromResponse(statusCode int, body []byte) map[string]any {
+	errorField := gjson.GetBytes(body, "error")
+	if errorField.IsObject() && errorField.Get("type").Exists() {
+		return map[string]any{
+			"type":    errorField.Get("type").String(),
+			"message": errorField.Get("message").String(),
+		}
+	}
+
+	message := errorField.String()
+	if message == "" {
+		message = gjson.GetBytes(body, "message").String()
+	}
+	if message == "" {
+		message = strings.TrimSpace(string(body))
+	}
+	return batchErrorObject(statusCode, message)
+}
+
+// batchErrorObject 按状态码构造 Anthropic 格式的错误对象
+func batchErrorObject(statusCode int, message string) map[string]any {
+	errorType := "api_error"
+	switch statusCode {
+	case http.StatusBadRequest:
+		errorType = "invalid_request_error"
+	case http.StatusUnauthorized:
+		errorType = "authentication_error"
+	case http.StatusForbidden:
+		errorType = "permission_error"
+	case http.StatusNotFound:
+		errorType = "not_found_error"
+	case http.StatusTooManyRequests:
+		errorType = "rate_limit_error"
+	case 529:
+		errorType = "overloaded_error"
+	}
+	return map[string]any{"type": errorType, "message": message}
+}
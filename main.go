@@ -2,10 +2,12 @@ package main
 
 import (
 	"claude-code-relay/common"
+	"claude-code-relay/controller"
 	"claude-code-relay/middleware"
 	"claude-code-relay/model"
 	"claude-code-relay/router"
 	"claude-code-relay/scheduled"
+	"claude-code-relay/service"
 	"fmt"
 	"log"
 	"net/http"
@@ -60,6 +62,9 @@ func main() {
 	}
 	defer scheduled.Shutdown()
 
+	// 启动消息批处理执行器
+	service.StartMessageBatchWorker(controller.ExecuteMessageBatchItem)
+
 	// 初始化HTTP服务器
 	server := gin.New()
 	server.Use(gin.CustomRecovery(func(c *gin.Context, err any) {
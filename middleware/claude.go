@@ -1,8 +1,10 @@
 package middleware
 
 import (
+	"claude-code-relay/common"
 	"claude-code-relay/constant"
 	"claude-code-relay/model"
+	"claude-code-relay/service"
 	"encoding/json"
 	"io"
 	"net/http"
@@ -23,62 +25,128 @@ type RequestBody struct {
 	System any `json:"system"`
 }
 
-// ClaudeCodeAuth API Key鉴权中间件
-func ClaudeCodeAuth() gin.HandlerFunc {
+// ClientPolicyAuth API Key鉴权中间件，并按API Key的客户端策略校验请求来源
+// 策略只决定哪些客户端可以使用该API Key，不影响转发到上游的请求
+func ClientPolicyAuth() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// 判断是否来自真实的 Claude Code 请求
-		if !isRealClaudeCodeRequest(c) {
+		if !authenticateApiKey(c) {
+			return
+		}
+
+		keyInfo := c.MustGet("api_key").(*model.ApiKey)
+		if message := checkClientPolicy(c, keyInfo); message != "" {
 			c.JSON(http.StatusForbidden, gin.H{
-				"error": "仅支持来自 Claude Code 的请求",
-				"code":  40003,
+				"error": message,
+				"code":  constant.Forbidden,
 			})
 			c.Abort()
 			return
 		}
 
-		// 从多个可能的请求头中获取API Key
-		apiKey := getApiKeyFromHeaders(c)
-		if apiKey == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "缺少API Key",
-				"code":  40001,
-			})
-			c.Abort()
+		c.Next()
+	}
+}
+
+// checkClientPolicy 校验请求的客户端是否符合API Key的客户端策略，不符合时返回错误信息
+func checkClientPolicy(c *gin.Context, keyInfo *model.ApiKey) string {
+	switch keyInfo.ClientPolicy {
+	case constant.ClientPolicyAnthropic:
+		// 兼容 Anthropic API 的客户端都会携带 anthropic-version 请求头
+		if c.GetHeader("anthropic-version") == "" {
+			return "仅支持兼容 Anthropic API 的客户端（缺少 anthropic-version 请求头）"
+		}
+	case constant.ClientPolicyCustom:
+		if !service.MatchClientAllowlist(keyInfo.ClientAllowlist, c.GetHeader("User-Agent")) {
+			return "客户端不在该API Key允许的范围内"
+		}
+	default:
+		// 判断是否来自真实的 Claude Code 请求
+		if !isRealClaudeCodeRequest(c) {
+			return "仅支持来自 Claude Code 的请求"
+		}
+	}
+	return ""
+}
+
+// ApiKeyAuth 仅校验API Key的鉴权中间件，用于不校验客户端策略的接口（如模型列表）
+func ApiKeyAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !authenticateApiKey(c) {
 			return
 		}
 
-		// 从数据库查询API Key
-		keyInfo, err := model.GetApiKeyByKey(apiKey)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "无效的API Key",
-				"code":  40001,
-			})
-			c.Abort()
+		c.Next()
+	}
+}
+
+// CompatEndpointAuth OpenAI 兼容接口和批处理接口的鉴权中间件
+// 这些接口的客户端（Cursor、OpenAI SDK、批处理脚本等）无法满足客户端策略，因此不校验策略，
+// 只校验API Key是否禁用了这些接口，需要严格限制客户端的API Key可以单独关闭
+func CompatEndpointAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !authenticateApiKey(c) {
 			return
 		}
 
-		// 判断是否达到每日限额
-		if keyInfo.DailyLimit > 0 && keyInfo.TodayTotalCost >= keyInfo.DailyLimit {
-			c.JSON(http.StatusTooManyRequests, gin.H{
-				"error": "API Key已达到每日使用限额",
-				"code":  40004,
+		keyInfo := c.MustGet("api_key").(*model.ApiKey)
+		if keyInfo.DisableCompatEndpoints {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "该API Key已禁用OpenAI兼容接口和批处理接口",
+				"code":  constant.Forbidden,
 			})
 			c.Abort()
 			return
 		}
 
-		// API Key已经在model层验证了状态和过期时间
-		// 将API Key信息存储到上下文中供后续使用
-		c.Set("api_key_id", keyInfo.ID)
-		c.Set("api_key", keyInfo)
-		c.Set("user_id", keyInfo.UserID)
-		c.Set("group_id", keyInfo.GroupID)
-
 		c.Next()
 	}
 }
 
+// authenticateApiKey 校验请求中的API Key并写入上下文，失败时已写回错误并中止请求
+func authenticateApiKey(c *gin.Context) bool {
+	// 从多个可能的请求头中获取API Key
+	apiKey := getApiKeyFromHeaders(c)
+	if apiKey == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "缺少API Key",
+			"code":  40001,
+		})
+		c.Abort()
+		return false
+	}
+
+	// 从数据库查询API Key
+	keyInfo, err := model.GetApiKeyByKey(apiKey)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "无效的API Key",
+			"code":  40001,
+		})
+		c.Abort()
+		return false
+	}
+
+	// 判断是否达到每日限额
+	if keyInfo.DailyLimit > 0 && keyInfo.TodayTotalCost >= keyInfo.DailyLimit {
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error": "API Key已达到每日使用限额",
+			"code":  40004,
+		})
+		c.Abort()
+		return false
+	}
+
+	// API Key已经在model层验证了状态和过期时间
+	// 将API Key信息存储到上下文中供后续使用
+	c.Set("api_key_id", keyInfo.ID)
+	c.Set("api_key", keyInfo)
+	c.Set("user_id", keyInfo.UserID)
+	c.Set("group_id", keyInfo.GroupID)
+	// 识别客户端类型和版本，记录到请求日志
+	c.Set("client_info", common.DetectClient(c.Request.Header))
+	return true
+}
+
 // getApiKeyFromHeaders 从多个可能的请求头中提取API Key
 func getApiKeyFromHeaders(c *gin.Context) string {
 	// 1. 检查 X-API-Key
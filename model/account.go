@@ -1,16 +1,24 @@
 package model
 
 import (
+	"claude-code-relay/common"
+	"errors"
 	"gorm.io/gorm"
+	"path"
+	"strings"
 	"time"
 )
 
 type Account struct {
 	ID                            uint           `json:"id" gorm:"primaryKey"`
 	Name                          string         `json:"name" gorm:"type:varchar(100);not null;comment:账号名称"`
-	PlatformType                  string         `json:"platform_type" gorm:"type:varchar(50);not null;comment:平台类型(claude/claude_console)"`
+	PlatformType                  string         `json:"platform_type" gorm:"type:varchar(50);not null;comment:平台类型(claude/claude_console/openai/azure_openai/gemini/bedrock/vertex)"`
 	RequestURL                    string         `json:"request_url" gorm:"type:varchar(500);comment:请求地址"`
-	SecretKey                     string         `json:"secret_key" gorm:"type:text;comment:请求秘钥"`
+	SecretKey                     string         `json:"secret_key" gorm:"type:text;comment:请求秘钥(Bedrock账号为AWS Secret Access Key，Vertex账号为服务账号JSON)"`
+	AccessKeyID                   string         `json:"access_key_id" gorm:"type:varchar(128);comment:AWS Access Key ID，仅Bedrock账号使用"`
+	SessionToken                  string         `json:"session_token" gorm:"type:text;comment:AWS临时凭证的Session Token(可选)，仅Bedrock账号使用"`
+	Region                        string         `json:"region" gorm:"type:varchar(50);comment:区域(Bedrock为AWS区域如us-east-1，Vertex为location如us-east5)"`
+	ApiVersion                    string         `json:"api_version" gorm:"type:varchar(50);comment:API版本(如2024-10-21)，仅Azure OpenAI账号使用"`
 	AccessToken                   string         `json:"access_token" gorm:"type:text;comment:claude的官方token"`
 	RefreshToken                  string         `json:"refresh_token" gorm:"type:text;comment:claude的官方刷新token"`
 	ExpiresAt                     int            `json:"expires_at" gorm:"default:0;comment:token过期时间戳"`
@@ -18,15 +26,30 @@ type Account struct {
 	GroupID                       int            `json:"group_id" gorm:"default:0;comment:分组ID"`
 	Priority                      int            `json:"priority" gorm:"default:100;comment:优先级(数字越小越高)"`
 	Weight                        int            `json:"weight" gorm:"default:100;comment:权重(数字越大越高)"`
+	MaxConcurrency                int            `json:"max_concurrency" gorm:"default:0;comment:最大并发请求数(0表示不限制)"`
 	TodayUsageCount               int            `json:"today_usage_count" gorm:"default:0;comment:今日使用次数"`
+	TodayCountTokensCount         int            `json:"today_count_tokens_count" gorm:"default:0;comment:今日count_tokens请求次数(不计费,不参与调度)"`
 	TodayInputTokens              int            `json:"today_input_tokens" gorm:"default:0;comment:今日输入tokens"`
 	TodayOutputTokens             int            `json:"today_output_tokens" gorm:"default:0;comment:今日输出tokens"`
 	TodayCacheReadInputTokens     int            `json:"today_cache_read_input_tokens" gorm:"default:0;comment:今日缓存读取输入tokens"`
 	TodayCacheCreationInputTokens int            `json:"today_cache_creation_input_tokens" gorm:"default:0;comment:今日缓存创建输入tokens"`
 	TodayTotalCost                float64        `json:"today_total_cost" gorm:"default:0;comment:今日使用总费用(USD)"`
+	MonthTokens                   int64          `json:"month_tokens" gorm:"default:0;comment:本月使用tokens(含缓存)"`
+	MonthTotalCost                float64        `json:"month_total_cost" gorm:"default:0;comment:本月使用总费用(USD)"`
+	DailyCostLimit                float64        `json:"daily_cost_limit" gorm:"default:0;comment:每日费用预算(USD,0表示不限制)"`
+	MonthlyCostLimit              float64        `json:"monthly_cost_limit" gorm:"default:0;comment:每月费用预算(USD,0表示不限制)"`
+	DailyTokenLimit               int64          `json:"daily_token_limit" gorm:"default:0;comment:每日tokens预算(0表示不限制)"`
+	MonthlyTokenLimit             int64          `json:"monthly_token_limit" gorm:"default:0;comment:每月tokens预算(0表示不限制)"`
 	EnableProxy                   bool           `json:"enable_proxy" gorm:"default:false;comment:是否启用代理"`
 	ProxyURI                      string         `json:"proxy_uri" gorm:"type:varchar(500);comment:代理URI字符串"`
 	ModelMapping                  string         `json:"model_mapping" gorm:"type:text;comment:模型映射配置(格式:claude-model:openai-model,多个用逗号分隔)"`
+	BetaPolicy                    string         `json:"beta_policy" gorm:"type:text;comment:anthropic-beta策略(格式:白名单,-去掉,+追加,多个用逗号分隔)"`
+	UpstreamProtocol              string         `json:"upstream_protocol" gorm:"type:varchar(30);default:chat_completions;comment:上游协议(chat_completions/responses)，仅OpenAI账号使用"`
+	ThinkingParam                 string         `json:"thinking_param" gorm:"type:varchar(30);comment:推理参数(reasoning_effort/enable_thinking/thinking,为空表示不传递)，仅OpenAI账号使用"`
+	SupportedModels               string         `json:"supported_models" gorm:"type:text;comment:支持的模型(逗号分隔,支持*通配符,为空表示全部)"`
+	AvailabilitySchedule          string         `json:"availability_schedule" gorm:"type:text;comment:可用时间表(格式:mon-fri 19:00-08:00;sat,sun 00:00-24:00,为空表示全天)"`
+	ScheduleTimezone              string         `json:"schedule_timezone" gorm:"type:varchar(64);comment:可用时间表时区(如Asia/Shanghai,为空表示服务器时区)"`
+	InSchedule                    bool           `json:"in_schedule" gorm:"default:true;comment:当前是否处于可用时间内(定时任务维护)"`
 	LastUsedTime                  *Time          `json:"last_used_time" gorm:"comment:最后使用时间;type:datetime"`
 	RateLimitEndTime              *Time          `json:"rate_limit_end_time" gorm:"comment:限流结束时间;type:datetime"`
 	CurrentStatus                 int            `json:"current_status" gorm:"default:1;comment:当前状态(1:正常,2:接口异常,3:账号异常/限流)"`
@@ -43,6 +66,24 @@ type Account struct {
 	// 最近一周统计数据（不存储到数据库，运行时计算）
 	WeeklyCost  float64 `json:"weekly_cost" gorm:"-"`  // 最近一周使用费用
 	WeeklyCount int64   `json:"weekly_count" gorm:"-"` // 最近一周使用次数
+
+	// 当前正在处理的请求数（来自Redis，运行时计算）
+	CurrentConcurrency int `json:"current_concurrency" gorm:"-"`
+
+	// 熔断器状态（closed/open/half_open，进程内，运行时计算）
+	CircuitState string `json:"circuit_state" gorm:"-"`
+
+	// 预算剩余情况（运行时计算）
+	Budget *AccountBudget `json:"budget" gorm:"-"`
+}
+
+// AccountBudget 账号预算剩余情况，Remaining 为nil表示该项未设置预算
+type AccountBudget struct {
+	DailyCostRemaining    *float64 `json:"daily_cost_remaining"`
+	MonthlyCostRemaining  *float64 `json:"monthly_cost_remaining"`
+	DailyTokenRemaining   *int64   `json:"daily_token_remaining"`
+	MonthlyTokenRemaining *int64   `json:"monthly_token_remaining"`
+	Exhausted             bool     `json:"exhausted"` // 是否已超出任一预算
 }
 
 // 账号列表请求参数
@@ -62,42 +103,72 @@ type AccountListResponse struct {
 
 // 账号创建请求参数
 type CreateAccountRequest struct {
-	Name            string `json:"name" binding:"required,min=1,max=100"`
-	PlatformType    string `json:"platform_type" binding:"required,oneof=claude claude_console gemini openai"`
-	RequestURL      string `json:"request_url"`
-	SecretKey       string `json:"secret_key"`
-	GroupID         int    `json:"group_id"`
-	Priority        int    `json:"priority"`
-	Weight          int    `json:"weight" binding:"min=1"`
-	EnableProxy     bool   `json:"enable_proxy"`
-	ProxyURI        string `json:"proxy_uri"`
-	ModelMapping    string `json:"model_mapping"`
-	ActiveStatus    int    `json:"active_status" binding:"oneof=1 2"`
-	IsMax           bool   `json:"is_max"` // 是否是max账号
-	AccessToken     string `json:"access_token"`
-	RefreshToken    string `json:"refresh_token"`
-	ExpiresAt       int    `json:"expires_at" binding:"min=0"`
-	TodayUsageCount int    `json:"today_usage_count"` // 今日使用次数
+	Name                 string  `json:"name" binding:"required,min=1,max=100"`
+	PlatformType         string  `json:"platform_type" binding:"required,oneof=claude claude_console gemini openai azure_openai bedrock vertex"`
+	RequestURL           string  `json:"request_url"`
+	SecretKey            string  `json:"secret_key"`
+	AccessKeyID          string  `json:"access_key_id"` // AWS Access Key ID，仅Bedrock账号使用
+	SessionToken         string  `json:"session_token"` // AWS Session Token，仅Bedrock账号使用
+	Region               string  `json:"region"`        // 区域，仅Bedrock、Vertex账号使用
+	ApiVersion           string  `json:"api_version"`   // API版本，仅Azure OpenAI账号使用，为空时使用默认版本
+	GroupID              int     `json:"group_id"`
+	Priority             int     `json:"priority"`
+	Weight               int     `json:"weight" binding:"min=1"`
+	MaxConcurrency       int     `json:"max_concurrency" binding:"min=0"`     // 最大并发请求数，0表示不限制
+	DailyCostLimit       float64 `json:"daily_cost_limit" binding:"min=0"`    // 每日费用预算(USD)，0表示不限制
+	MonthlyCostLimit     float64 `json:"monthly_cost_limit" binding:"min=0"`  // 每月费用预算(USD)，0表示不限制
+	DailyTokenLimit      int64   `json:"daily_token_limit" binding:"min=0"`   // 每日tokens预算，0表示不限制
+	MonthlyTokenLimit    int64   `json:"monthly_token_limit" binding:"min=0"` // 每月tokens预算，0表示不限制
+	EnableProxy          bool    `json:"enable_proxy"`
+	ProxyURI             string  `json:"proxy_uri"`
+	ModelMapping         string  `json:"model_mapping"`
+	BetaPolicy           string  `json:"beta_policy"` // anthropic-beta策略，为空表示不限制
+	UpstreamProtocol     string  `json:"upstream_protocol" binding:"omitempty,oneof=chat_completions responses"`
+	ThinkingParam        string  `json:"thinking_param" binding:"omitempty,oneof=reasoning_effort enable_thinking thinking"`
+	SupportedModels      string  `json:"supported_models"`      // 支持的模型，逗号分隔，支持*通配符，为空表示全部
+	AvailabilitySchedule string  `json:"availability_schedule"` // 可用时间表，为空表示全天可用
+	ScheduleTimezone     string  `json:"schedule_timezone"`     // 可用时间表时区
+	ActiveStatus         int     `json:"active_status" binding:"oneof=1 2"`
+	IsMax                bool    `json:"is_max"` // 是否是max账号
+	AccessToken          string  `json:"access_token"`
+	RefreshToken         string  `json:"refresh_token"`
+	ExpiresAt            int     `json:"expires_at" binding:"min=0"`
+	TodayUsageCount      int     `json:"today_usage_count"` // 今日使用次数
 }
 
 // 账号更新请求参数
 type UpdateAccountRequest struct {
-	Name            string `json:"name" binding:"required,min=1,max=100"`
-	PlatformType    string `json:"platform_type" binding:"required,oneof=claude claude_console openai gemini"`
-	RequestURL      string `json:"request_url"`
-	SecretKey       string `json:"secret_key"`
-	GroupID         *int   `json:"group_id" binding:"omitempty,min=0"`
-	Priority        int    `json:"priority" binding:"min=1"`
-	Weight          int    `json:"weight" binding:"min=1"`
-	EnableProxy     bool   `json:"enable_proxy"`
-	ProxyURI        string `json:"proxy_uri"`
-	ModelMapping    string `json:"model_mapping"`
-	ActiveStatus    int    `json:"active_status" binding:"oneof=1 2"`
-	IsMax           bool   `json:"is_max"` // 是否是max账号
-	AccessToken     string `json:"access_token"`
-	RefreshToken    string `json:"refresh_token"`
-	ExpiresAt       int    `json:"expires_at" binding:"min=0"`
-	TodayUsageCount int    `json:"today_usage_count"` // 今日使用次数
+	Name                 string  `json:"name" binding:"required,min=1,max=100"`
+	PlatformType         string  `json:"platform_type" binding:"required,oneof=claude claude_console openai azure_openai gemini bedrock vertex"`
+	RequestURL           string  `json:"request_url"`
+	SecretKey            string  `json:"secret_key"`
+	AccessKeyID          string  `json:"access_key_id"` // AWS Access Key ID，仅Bedrock账号使用
+	SessionToken         string  `json:"session_token"` // AWS Session Token，仅Bedrock账号使用
+	Region               string  `json:"region"`        // 区域，仅Bedrock、Vertex账号使用
+	ApiVersion           string  `json:"api_version"`   // API版本，仅Azure OpenAI账号使用，为空时使用默认版本
+	GroupID              *int    `json:"group_id" binding:"omitempty,min=0"`
+	Priority             int     `json:"priority" binding:"min=1"`
+	Weight               int     `json:"weight" binding:"min=1"`
+	MaxConcurrency       int     `json:"max_concurrency" binding:"min=0"`     // 最大并发请求数，0表示不限制
+	DailyCostLimit       float64 `json:"daily_cost_limit" binding:"min=0"`    // 每日费用预算(USD)，0表示不限制
+	MonthlyCostLimit     float64 `json:"monthly_cost_limit" binding:"min=0"`  // 每月费用预算(USD)，0表示不限制
+	DailyTokenLimit      int64   `json:"daily_token_limit" binding:"min=0"`   // 每日tokens预算，0表示不限制
+	MonthlyTokenLimit    int64   `json:"monthly_token_limit" binding:"min=0"` // 每月tokens预算，0表示不限制
+	EnableProxy          bool    `json:"enable_proxy"`
+	ProxyURI             string  `json:"proxy_uri"`
+	ModelMapping         string  `json:"model_mapping"`
+	BetaPolicy           string  `json:"beta_policy"` // anthropic-beta策略，为空表示不限制
+	UpstreamProtocol     string  `json:"upstream_protocol" binding:"omitempty,oneof=chat_completions responses"`
+	ThinkingParam        string  `json:"thinking_param" binding:"omitempty,oneof=reasoning_effort enable_thinking thinking"`
+	SupportedModels      string  `json:"supported_models"`      // 支持的模型，逗号分隔，支持*通配符，为空表示全部
+	AvailabilitySchedule string  `json:"availability_schedule"` // 可用时间表，为空表示全天可用
+	ScheduleTimezone     string  `json:"schedule_timezone"`     // 可用时间表时区
+	ActiveStatus         int     `json:"active_status" binding:"oneof=1 2"`
+	IsMax                bool    `json:"is_max"` // 是否是max账号
+	AccessToken          string  `json:"access_token"`
+	RefreshToken         string  `json:"refresh_token"`
+	ExpiresAt            int     `json:"expires_at" binding:"min=0"`
+	TodayUsageCount      int     `json:"today_usage_count"` // 今日使用次数
 }
 
 // 账号激活状态更新请求参数
@@ -114,6 +185,77 @@ func (a *Account) TableName() string {
 	return "accounts"
 }
 
+// SupportsModel 判断账号是否支持指定模型
+// SupportedModels 为空表示支持全部模型，否则按逗号分隔的模式逐个匹配（不区分大小写，支持*和?通配符）
+func (a *Account) SupportsModel(modelName string) bool {
+	if strings.TrimSpace(a.SupportedModels) == "" || modelName == "" {
+		return true
+	}
+
+	modelName = strings.ToLower(modelName)
+	for _, pattern := range strings.Split(a.SupportedModels, ",") {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == "" {
+			continue
+		}
+		if pattern == modelName {
+			return true
+		}
+		if matched, err := path.Match(pattern, modelName); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// TodayTokens 今日使用的tokens总数（含缓存读写）
+func (a *Account) TodayTokens() int64 {
+	return int64(a.TodayInputTokens + a.TodayOutputTokens + a.TodayCacheReadInputTokens + a.TodayCacheCreationInputTokens)
+}
+
+// GetBudget 计算账号各项预算的剩余额度
+func (a *Account) GetBudget() *AccountBudget {
+	budget := &AccountBudget{}
+	if a.DailyCostLimit > 0 {
+		remaining := a.DailyCostLimit - a.TodayTotalCost
+		budget.DailyCostRemaining = &remaining
+		budget.Exhausted = budget.Exhausted || remaining <= 0
+	}
+	if a.MonthlyCostLimit > 0 {
+		remaining := a.MonthlyCostLimit - a.MonthTotalCost
+		budget.MonthlyCostRemaining = &remaining
+		budget.Exhausted = budget.Exhausted || remaining <= 0
+	}
+	if a.DailyTokenLimit > 0 {
+		remaining := a.DailyTokenLimit - a.TodayTokens()
+		budget.DailyTokenRemaining = &remaining
+		budget.Exhausted = budget.Exhausted || remaining <= 0
+	}
+	if a.MonthlyTokenLimit > 0 {
+		remaining := a.MonthlyTokenLimit - a.MonthTokens
+		budget.MonthlyTokenRemaining = &remaining
+		budget.Exhausted = budget.Exhausted || remaining <= 0
+	}
+	return budget
+}
+
+// CheckInSchedule 判断账号在指定时间是否处于可用时间表内，未设置或配置无效时视为全天可用
+func (a *Account) CheckInSchedule(now time.Time) bool {
+	if strings.TrimSpace(a.AvailabilitySchedule) == "" {
+		return true
+	}
+	schedule, err := common.ParseAvailabilitySchedule(a.AvailabilitySchedule, a.ScheduleTimezone)
+	if err != nil {
+		return true
+	}
+	return schedule.Contains(now)
+}
+
+// HasBudget 是否设置了任一预算
+func (a *Account) HasBudget() bool {
+	return a.DailyCostLimit > 0 || a.MonthlyCostLimit > 0 || a.DailyTokenLimit > 0 || a.MonthlyTokenLimit > 0
+}
+
 // 创建账号
 func CreateAccount(account *Account) error {
 	account.ID = 0
@@ -139,9 +281,9 @@ func GetAccountByID(id uint) (*Account, error) {
 	return &account, nil
 }
 
-// 更新账号
+// 更新账号，count_tokens次数只通过原子累加修改，避免请求开始时加载的旧值覆盖
 func UpdateAccount(account *Account) error {
-	return DB.Save(account).Error
+	return DB.Omit("today_count_tokens_count").Save(account).Error
 }
 
 // 删除账号（软删除）
@@ -149,6 +291,11 @@ func DeleteAccount(id uint) error {
 	return DB.Delete(&Account{}, id).Error
 }
 
+// IncrementAccountCountTokens 原子累加账号今日count_tokens请求次数，不修改使用次数、费用和最后使用时间
+func IncrementAccountCountTokens(id uint) error {
+	return DB.Model(&Account{}).Where("id = ?", id).UpdateColumn("today_count_tokens_count", gorm.Expr("today_count_tokens_count + 1")).Error
+}
+
 // 分页获取账号列表
 func GetAccountList(page, limit int, userID *uint) ([]Account, int64, error) {
 	var accounts []Account
@@ -231,10 +378,24 @@ func GetAccountsByUserID(userID uint) ([]Account, error) {
 	return accounts, nil
 }
 
+// 获取账号列表（不分页），userID为nil时返回全部账号
+func GetAccounts(userID *uint) ([]Account, error) {
+	var accounts []Account
+	query := DB.Model(&Account{})
+	if userID != nil {
+		query = query.Where("user_id = ?", *userID)
+	}
+	err := query.Order("group_id ASC, priority ASC, id ASC").Find(&accounts).Error
+	if err != nil {
+		return nil, err
+	}
+	return accounts, nil
+}
+
 // 根据分组ID获取可用账号列表（按优先级和使用次数排序）
 func GetAvailableAccountsByGroupID(groupID int) ([]Account, error) {
 	var accounts []Account
-	err := DB.Where("group_id = ? AND active_status = 1 AND (current_status = 1 OR (current_status = 3 AND (rate_limit_end_time IS NULL OR rate_limit_end_time < ?)))", groupID, time.Now()).
+	err := DB.Where("group_id = ? AND active_status = 1 AND in_schedule = 1 AND (current_status = 1 OR (current_status = 3 AND (rate_limit_end_time IS NULL OR rate_limit_end_time < ?)))", groupID, time.Now()).
 		Order("priority ASC, today_usage_count ASC").
 		Find(&accounts).Error
 	if err != nil {
@@ -243,6 +404,66 @@ func GetAvailableAccountsByGroupID(groupID int) ([]Account, error) {
 	return accounts, nil
 }
 
+// 获取分组内所有激活的账号（不区分当前状态）
+func GetActiveAccountsByGroupID(groupID int) ([]Account, error) {
+	var accounts []Account
+	err := DB.Where("group_id = ? AND active_status = 1", groupID).Find(&accounts).Error
+	if err != nil {
+		return nil, err
+	}
+	return accounts, nil
+}
+
+// 获取设置了可用时间表的账号，以及当前被标记为不可用时间的账号（时间表被清空后需要恢复）
+func GetScheduledAccounts() ([]Account, error) {
+	var accounts []Account
+	err := DB.Where("availability_schedule <> '' OR in_schedule = 0").Find(&accounts).Error
+	if err != nil {
+		return nil, err
+	}
+	return accounts, nil
+}
+
+// 批量更新账号是否处于可用时间内
+func UpdateAccountsInSchedule(ids []uint, inSchedule bool) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return DB.Model(&Account{}).Where("id IN ?", ids).Update("in_schedule", inSchedule).Error
+}
+
+// 获取设置了预算的账号列表，userID 不为空时只查询该用户的账号
+func GetBudgetedAccounts(userID *uint) ([]Account, error) {
+	var accounts []Account
+	query := DB.Where("daily_cost_limit > 0 OR monthly_cost_limit > 0 OR daily_token_limit > 0 OR monthly_token_limit > 0")
+	if userID != nil {
+		query = query.Where("user_id = ?", *userID)
+	}
+	err := query.Order("group_id ASC, priority ASC, id ASC").
+		Find(&accounts).Error
+	if err != nil {
+		return nil, err
+	}
+	return accounts, nil
+}
+
+// 获取分组内激活账号中最早的限流结束时间（仅限未来的时间），没有限流账号时返回nil
+func GetEarliestRateLimitEndTime(groupID int) (*time.Time, error) {
+	var account Account
+	err := DB.Where("group_id = ? AND active_status = 1 AND current_status = 3 AND rate_limit_end_time > ?", groupID, time.Now()).
+		Order("rate_limit_end_time ASC").
+		First(&account).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	endTime := time.Time(*account.RateLimitEndTime)
+	return &endTime, nil
+}
+
 // 获取指定用户、分组和优先级下可用账号的最大今日请求次数
 func GetMaxTodayUsageCountFromAvailableAccounts(userID uint, groupID int, priority int) (int, error) {
 	var maxUsageCount int
@@ -17,6 +17,7 @@ type ApiKey struct {
 	GroupID                       int            `json:"group_id" gorm:"default:0;index"`
 	UserID                        uint           `json:"user_id" gorm:"not null;index"`
 	TodayUsageCount               int            `json:"today_usage_count" gorm:"default:0;comment:今日使用次数"`
+	TodayCountTokensCount         int            `json:"today_count_tokens_count" gorm:"default:0;comment:今日count_tokens请求次数(不计费,不参与调度)"`
 	TodayInputTokens              int            `json:"today_input_tokens" gorm:"default:0;comment:今日输入tokens"`
 	TodayOutputTokens             int            `json:"today_output_tokens" gorm:"default:0;comment:今日输出tokens"`
 	TodayCacheReadInputTokens     int            `json:"today_cache_read_input_tokens" gorm:"default:0;comment:今日缓存读取输入tokens"`
@@ -24,6 +25,10 @@ type ApiKey struct {
 	TodayTotalCost                float64        `json:"today_total_cost" gorm:"default:0;comment:今日使用总费用(USD)"`
 	ModelRestriction              string         `json:"model_restriction" gorm:"type:text;comment:模型限制,逗号分隔"`
 	DailyLimit                    float64        `json:"daily_limit" gorm:"default:0;comment:日限额(美元),0表示不限制"`
+	PriorityClass                 string         `json:"priority_class" gorm:"type:varchar(20);default:interactive;comment:优先级通道(interactive/batch/background)"`
+	ClientPolicy                  string         `json:"client_policy" gorm:"type:varchar(20);default:claude_code;comment:客户端策略(claude_code/anthropic/custom)"`
+	ClientAllowlist               string         `json:"client_allowlist" gorm:"type:text;comment:自定义客户端白名单(User-Agent正则,每行一个)"`
+	DisableCompatEndpoints        bool           `json:"disable_compat_endpoints" gorm:"default:false;comment:禁用OpenAI兼容接口和批处理接口(这些接口不校验客户端策略)"`
 	LastUsedTime                  *Time          `json:"last_used_time" gorm:"comment:最后使用时间;type:datetime"`
 	CreatedAt                     Time           `json:"created_at" gorm:"type:datetime;default:CURRENT_TIMESTAMP"`
 	UpdatedAt                     Time           `json:"updated_at" gorm:"type:datetime;default:CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP"`
@@ -37,22 +42,30 @@ type ApiKey struct {
 }
 
 type CreateApiKeyRequest struct {
-	Name             string  `json:"name" binding:"required"`
-	Key              string  `json:"key"`
-	ExpiresAt        *Time   `json:"expires_at"`
-	Status           int     `json:"status" binding:"oneof=1 2"`
-	GroupID          int     `json:"group_id"`
-	ModelRestriction string  `json:"model_restriction"`
-	DailyLimit       float64 `json:"daily_limit"`
+	Name                   string  `json:"name" binding:"required"`
+	Key                    string  `json:"key"`
+	ExpiresAt              *Time   `json:"expires_at"`
+	Status                 int     `json:"status" binding:"oneof=1 2"`
+	GroupID                int     `json:"group_id"`
+	ModelRestriction       string  `json:"model_restriction"`
+	DailyLimit             float64 `json:"daily_limit"`
+	PriorityClass          string  `json:"priority_class" binding:"omitempty,oneof=interactive batch background"`
+	ClientPolicy           string  `json:"client_policy" binding:"omitempty,oneof=claude_code anthropic custom"`
+	ClientAllowlist        string  `json:"client_allowlist"`
+	DisableCompatEndpoints bool    `json:"disable_compat_endpoints"`
 }
 
 type UpdateApiKeyRequest struct {
-	Name             string   `json:"name"`
-	ExpiresAt        *Time    `json:"expires_at"`
-	Status           *int     `json:"status"`
-	GroupID          *int     `json:"group_id"`
-	ModelRestriction *string  `json:"model_restriction"`
-	DailyLimit       *float64 `json:"daily_limit"`
+	Name                   string   `json:"name"`
+	ExpiresAt              *Time    `json:"expires_at"`
+	Status                 *int     `json:"status"`
+	GroupID                *int     `json:"group_id"`
+	ModelRestriction       *string  `json:"model_restriction"`
+	DailyLimit             *float64 `json:"daily_limit"`
+	PriorityClass          *string  `json:"priority_class" binding:"omitempty,oneof=interactive batch background"`
+	ClientPolicy           *string  `json:"client_policy" binding:"omitempty,oneof=claude_code anthropic custom"`
+	ClientAllowlist        *string  `json:"client_allowlist"`
+	DisableCompatEndpoints *bool    `json:"disable_compat_endpoints"`
 }
 
 type ApiKeyListResult struct {
@@ -125,14 +138,20 @@ func GetApiKeyByKey(key string) (*ApiKey, error) {
 	return &apiKey, nil
 }
 
+// UpdateApiKey 更新API Key，count_tokens次数只通过原子累加修改，避免请求开始时加载的旧值覆盖
 func UpdateApiKey(apiKey *ApiKey) error {
-	return DB.Save(apiKey).Error
+	return DB.Omit("today_count_tokens_count").Save(apiKey).Error
 }
 
 func DeleteApiKey(id uint) error {
 	return DB.Delete(&ApiKey{}, id).Error
 }
 
+// IncrementApiKeyCountTokens 原子累加API Key今日count_tokens请求次数，不修改使用次数、费用和最后使用时间
+func IncrementApiKeyCountTokens(id uint) error {
+	return DB.Model(&ApiKey{}).Where("id = ?", id).UpdateColumn("today_count_tokens_count", gorm.Expr("today_count_tokens_count + 1")).Error
+}
+
 // GetApiKeys 分页获取API Keys
 func GetApiKeys(page, limit int, userID uint, groupID *uint) ([]ApiKey, int64, error) {
 	var apiKeys []ApiKey
@@ -208,9 +227,9 @@ func setWeeklyStatsForApiKeys(apiKeys []ApiKey) error {
 	if weekday == 0 { // 如果是周日，调整为7
 		weekday = 7
 	}
-	
+
 	// 计算本周一的日期
-	weekStart := now.AddDate(0, 0, -(weekday-1))
+	weekStart := now.AddDate(0, 0, -(weekday - 1))
 	weekStart = time.Date(weekStart.Year(), weekStart.Month(), weekStart.Day(), 0, 0, 0, 0, weekStart.Location())
 
 	// 提取所有API Key ID
@@ -105,6 +105,9 @@ func InitDB() error {
 		&Group{},
 		&ApiKey{},
 		&Log{},
+		&RelayAttempt{},
+		&MessageBatch{},
+		&MessageBatchItem{},
 	)
 	if err != nil {
 		return err
@@ -5,14 +5,22 @@ import (
 )
 
 type Group struct {
-	ID        uint           `json:"id" gorm:"primaryKey"`
-	Name      string         `json:"name" gorm:"type:varchar(100);not null;uniqueIndex:idx_groups_user_name"`
-	Remark    string         `json:"remark" gorm:"type:text"`
-	Status    int            `json:"status" gorm:"default:1"` // 1:启用 0:禁用
-	UserID    uint           `json:"user_id" gorm:"not null;uniqueIndex:idx_groups_user_name"`
-	CreatedAt Time           `json:"created_at" gorm:"type:datetime;default:CURRENT_TIMESTAMP"`
-	UpdatedAt Time           `json:"updated_at" gorm:"type:datetime;default:CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP"`
-	DeletedAt gorm.DeletedAt `json:"-" gorm:"uniqueIndex:idx_groups_user_name"`
+	ID               uint           `json:"id" gorm:"primaryKey"`
+	Name             string         `json:"name" gorm:"type:varchar(100);not null;uniqueIndex:idx_groups_user_name"`
+	Remark           string         `json:"remark" gorm:"type:text"`
+	Status           int            `json:"status" gorm:"default:1"` // 1:启用 0:禁用
+	ScheduleStrategy string         `json:"schedule_strategy" gorm:"type:varchar(50);default:least_used;comment:账号调度策略(least_used/weighted_random)"`
+	QueueEnabled     bool           `json:"queue_enabled" gorm:"default:false;comment:无可用账号时是否排队等待"`
+	QueueMaxWait     int            `json:"queue_max_wait" gorm:"default:60;comment:排队最长等待时间(秒)"`
+	QueueMaxDepth    int            `json:"queue_max_depth" gorm:"default:100;comment:最大排队请求数"`
+	FallbackGroups   string         `json:"fallback_groups" gorm:"type:text;comment:备用分组(格式:3;5|源模型:目标模型,...)"`
+	HedgeEnabled     bool           `json:"hedge_enabled" gorm:"default:false;comment:非流式请求是否启用对冲"`
+	HedgePercentile  int            `json:"hedge_percentile" gorm:"default:95;comment:对冲触发的延迟百分位(50-99)"`
+	BetaPolicy       string         `json:"beta_policy" gorm:"type:text;comment:anthropic-beta策略(格式:白名单,-去掉,+追加,多个用逗号分隔)"`
+	UserID           uint           `json:"user_id" gorm:"not null;uniqueIndex:idx_groups_user_name"`
+	CreatedAt        Time           `json:"created_at" gorm:"type:datetime;default:CURRENT_TIMESTAMP"`
+	UpdatedAt        Time           `json:"updated_at" gorm:"type:datetime;default:CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP"`
+	DeletedAt        gorm.DeletedAt `json:"-" gorm:"uniqueIndex:idx_groups_user_name"`
 
 	// 统计字段，不存储在数据库中
 	ApiKeyCount  int `json:"api_key_count" gorm:"-"`
@@ -20,15 +28,31 @@ type Group struct {
 }
 
 type CreateGroupRequest struct {
-	Name   string `json:"name" binding:"required"`
-	Remark string `json:"remark"`
-	Status int    `json:"status"`
+	Name             string `json:"name" binding:"required"`
+	Remark           string `json:"remark"`
+	Status           int    `json:"status"`
+	ScheduleStrategy string `json:"schedule_strategy" binding:"omitempty,oneof=least_used weighted_random"`
+	QueueEnabled     bool   `json:"queue_enabled"`
+	QueueMaxWait     int    `json:"queue_max_wait" binding:"min=0"`
+	QueueMaxDepth    int    `json:"queue_max_depth" binding:"min=0"`
+	FallbackGroups   string `json:"fallback_groups"`
+	HedgeEnabled     bool   `json:"hedge_enabled"`
+	HedgePercentile  int    `json:"hedge_percentile" binding:"omitempty,min=50,max=99"`
+	BetaPolicy       string `json:"beta_policy"`
 }
 
 type UpdateGroupRequest struct {
-	Name   string `json:"name"`
-	Remark string `json:"remark"`
-	Status *int   `json:"status"`
+	Name             string  `json:"name"`
+	Remark           string  `json:"remark"`
+	Status           *int    `json:"status"`
+	ScheduleStrategy *string `json:"schedule_strategy" binding:"omitempty,oneof=least_used weighted_random"`
+	QueueEnabled     *bool   `json:"queue_enabled"`
+	QueueMaxWait     *int    `json:"queue_max_wait" binding:"omitempty,min=0"`
+	QueueMaxDepth    *int    `json:"queue_max_depth" binding:"omitempty,min=0"`
+	FallbackGroups   *string `json:"fallback_groups"`
+	HedgeEnabled     *bool   `json:"hedge_enabled"`
+	HedgePercentile  *int    `json:"hedge_percentile" binding:"omitempty,min=50,max=99"`
+	BetaPolicy       *string `json:"beta_policy"`
 }
 
 type GroupListResult struct {
@@ -14,6 +14,7 @@ type Log struct {
 	ID                       string  `json:"id" gorm:"primaryKey;type:varchar(19)"`                     // 雪花算法ID，支持排序
 	ModelName                string  `json:"model_name" gorm:"type:varchar(100);not null;index"`        // 模型名称，如claude-3-5-sonnet-20241022
 	AccountID                uint    `json:"account_id" gorm:"index"`                                   // 账户ID
+	GroupID                  int     `json:"group_id" gorm:"default:0;index"`                           // 实际处理请求的分组ID（可能是备用分组）
 	UserID                   uint    `json:"user_id" gorm:"index"`                                      // 用户ID
 	ApiKeyID                 uint    `json:"api_key_id" gorm:"index"`                                   // API Key ID
 	InputTokens              int     `json:"input_tokens" gorm:"default:0"`                             // 输入tokens数量
@@ -27,6 +28,8 @@ type Log struct {
 	TotalCost                float64 `json:"total_cost" gorm:"default:0"`                               // 总费用(USD)
 	IsStream                 bool    `json:"is_stream" gorm:"default:false"`                            // 是否为流式输出
 	Duration                 int64   `json:"duration"`                                                  // 请求总耗时(毫秒)
+	ClientType               string  `json:"client_type" gorm:"type:varchar(50);index"`                 // 客户端类型，如claude_code、anthropic-sdk-python
+	ClientVersion            string  `json:"client_version" gorm:"type:varchar(50)"`                    // 客户端版本
 	CreatedAt                Time    `json:"created_at" gorm:"type:datetime;default:CURRENT_TIMESTAMP"` // 创建时间
 
 	// 关联关系
@@ -38,6 +41,7 @@ type Log struct {
 type LogCreateRequest struct {
 	ModelName                string  `json:"model_name" binding:"required"`
 	AccountID                uint    `json:"account_id"`
+	GroupID                  int     `json:"group_id"`
 	UserID                   uint    `json:"user_id" binding:"required"`
 	ApiKeyID                 uint    `json:"api_key_id"`
 	InputTokens              int     `json:"input_tokens"`
@@ -51,6 +55,8 @@ type LogCreateRequest struct {
 	TotalCost                float64 `json:"total_cost"`
 	IsStream                 bool    `json:"is_stream"`
 	Duration                 int64   `json:"duration"`
+	ClientType               string  `json:"client_type"`
+	ClientVersion            string  `json:"client_version"`
 }
 
 // LogListResult 日志列表响应结构
@@ -123,6 +129,7 @@ type StatsResponse struct {
 type LogFilters struct {
 	UserID    *uint      `json:"user_id"`    // 用户ID筛选
 	AccountID *uint      `json:"account_id"` // 账号ID筛选
+	GroupID   *int       `json:"group_id"`   // 实际处理请求的分组ID筛选
 	ApiKeyID  *uint      `json:"api_key_id"` // API Key ID筛选
 	ModelName *string    `json:"model_name"` // 模型名称筛选
 	IsStream  *bool      `json:"is_stream"`  // 是否流式请求筛选
@@ -160,6 +167,7 @@ func CreateLog(logReq *LogCreateRequest) (*Log, error) {
 		ID:                       generateSnowflakeID(),
 		ModelName:                logReq.ModelName,
 		AccountID:                logReq.AccountID,
+		GroupID:                  logReq.GroupID,
 		UserID:                   logReq.UserID,
 		ApiKeyID:                 logReq.ApiKeyID,
 		InputTokens:              logReq.InputTokens,
@@ -173,6 +181,8 @@ func CreateLog(logReq *LogCreateRequest) (*Log, error) {
 		TotalCost:                logReq.TotalCost,
 		IsStream:                 logReq.IsStream,
 		Duration:                 logReq.Duration,
+		ClientType:               logReq.ClientType,
+		ClientVersion:            logReq.ClientVersion,
 	}
 
 	err := DB.Create(log).Error
@@ -185,13 +195,14 @@ func CreateLog(logReq *LogCreateRequest) (*Log, error) {
 }
 
 // CreateLogFromTokenUsage 根据TokenUsage创建日志记录
-func CreateLogFromTokenUsage(usage *common.TokenUsage, userID, apiKeyID, accountID uint, duration int64, isStream bool) (*Log, error) {
+func CreateLogFromTokenUsage(usage *common.TokenUsage, userID, apiKeyID, accountID uint, groupID int, duration int64, isStream bool, client common.ClientInfo) (*Log, error) {
 	// 使用费用计算器计算详细费用
 	costResult := common.CalculateCost(usage)
 
 	logReq := &LogCreateRequest{
 		ModelName:                usage.Model,
 		AccountID:                accountID,
+		GroupID:                  groupID,
 		UserID:                   userID,
 		ApiKeyID:                 apiKeyID,
 		InputTokens:              usage.InputTokens,
@@ -205,6 +216,8 @@ func CreateLogFromTokenUsage(usage *common.TokenUsage, userID, apiKeyID, account
 		TotalCost:                costResult.Costs.Total,
 		IsStream:                 isStream,
 		Duration:                 duration,
+		ClientType:               client.Type,
+		ClientVersion:            client.Version,
 	}
 
 	return CreateLog(logReq)
@@ -369,6 +382,12 @@ func GetLogsWithFilters(filters *LogFilters, page, limit int) ([]Log, int64, err
 			countQuery = countQuery.Where("account_id = ?", *filters.AccountID)
 		}
 
+		// 分组ID筛选
+		if filters.GroupID != nil {
+			query = query.Where("group_id = ?", *filters.GroupID)
+			countQuery = countQuery.Where("group_id = ?", *filters.GroupID)
+		}
+
 		// API Key ID筛选
 		if filters.ApiKeyID != nil {
 			query = query.Where("api_key_id = ?", *filters.ApiKeyID)
@@ -711,6 +730,37 @@ type DashboardStats struct {
 	// 今日vs昨日数据对比
 	TodayStats     *DayStatsItem `json:"today_stats"`     // 今日统计
 	YesterdayStats *DayStatsItem `json:"yesterday_stats"` // 昨日统计
+
+	// 会话粘性统计
+	SessionAffinity *SessionAffinityStats `json:"session_affinity"` // 今日会话粘性命中情况
+
+	// 账号预算
+	AccountBudgets []AccountBudgetItem `json:"account_budgets"` // 设置了预算的账号及剩余额度
+}
+
+// AccountBudgetItem 账号预算使用情况
+type AccountBudgetItem struct {
+	AccountID         uint           `json:"account_id"`          // 账号ID
+	AccountName       string         `json:"account_name"`        // 账号名称
+	PlatformType      string         `json:"platform_type"`       // 平台类型
+	GroupID           int            `json:"group_id"`            // 分组ID
+	TodayCost         float64        `json:"today_cost"`          // 今日费用
+	MonthCost         float64        `json:"month_cost"`          // 本月费用
+	TodayTokens       int64          `json:"today_tokens"`        // 今日tokens
+	MonthTokens       int64          `json:"month_tokens"`        // 本月tokens
+	DailyCostLimit    float64        `json:"daily_cost_limit"`    // 每日费用预算
+	MonthlyCostLimit  float64        `json:"monthly_cost_limit"`  // 每月费用预算
+	DailyTokenLimit   int64          `json:"daily_token_limit"`   // 每日tokens预算
+	MonthlyTokenLimit int64          `json:"monthly_token_limit"` // 每月tokens预算
+	Budget            *AccountBudget `json:"budget"`              // 剩余额度
+}
+
+// SessionAffinityStats 会话粘性统计（来自Redis，按天统计）
+type SessionAffinityStats struct {
+	Hits        int64   `json:"hits"`         // 命中已绑定账号的请求数
+	Fallbacks   int64   `json:"fallbacks"`    // 绑定账号不可用而回退的请求数
+	NewSessions int64   `json:"new_sessions"` // 新会话请求数
+	HitRate     float64 `json:"hit_rate"`     // 命中率(%)
 }
 
 // ModelUsageItem 模型使用统计项
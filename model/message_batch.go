@@ -0,0 +1,281 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 批处理及批处理请求的状态
+const (
+	BatchStatusInProgress = "in_progress"
+	BatchStatusCanceling  = "canceling"
+	BatchStatusEnded      = "ended"
+
+	BatchItemPending   = "pending"
+	BatchItemRunning   = "running"
+	BatchItemSucceeded = "succeeded"
+	BatchItemErrored   = "errored"
+	BatchItemCanceled  = "canceled"
+	BatchItemExpired   = "expired"
+)
+
+// MessageBatch 消息批处理表 - 记录通过 /v1/messages/batches 提交的批处理任务
+type MessageBatch struct {
+	ID                uint   `json:"id" gorm:"primaryKey"`
+	BatchID           string `json:"batch_id" gorm:"type:varchar(64);uniqueIndex;comment:对外的批处理ID"`
+	ApiKeyID          uint   `json:"api_key_id" gorm:"index;comment:API Key ID"`
+	UserID            uint   `json:"user_id" gorm:"index;comment:用户ID"`
+	ProcessingStatus  string `json:"processing_status" gorm:"type:varchar(20);default:in_progress;index;comment:处理状态(in_progress/canceling/ended)"`
+	ClientType        string `json:"client_type" gorm:"type:varchar(50);comment:创建批处理的客户端类型，记录到执行请求的日志"`
+	ClientVersion     string `json:"client_version" gorm:"type:varchar(50);comment:创建批处理的客户端版本"`
+	ExpiresAt         Time   `json:"expires_at" gorm:"type:datetime;comment:过期时间，未处理完的请求到期后标记为expired"`
+	CancelInitiatedAt *Time  `json:"cancel_initiated_at" gorm:"type:datetime;comment:发起取消的时间"`
+	EndedAt           *Time  `json:"ended_at" gorm:"type:datetime;comment:处理结束时间"`
+	CreatedAt         Time   `json:"created_at" gorm:"type:datetime;default:CURRENT_TIMESTAMP"`
+	UpdatedAt         Time   `json:"updated_at" gorm:"type:datetime;default:CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP"`
+}
+
+func (b *MessageBatch) TableName() string {
+	return "message_batches"
+}
+
+// MessageBatchItem 批处理请求表 - 批处理中的每条消息请求及其结果
+type MessageBatchItem struct {
+	ID        uint   `json:"id" gorm:"primaryKey"`
+	BatchID   uint   `json:"batch_id" gorm:"index:idx_batch_item_status,priority:1;comment:所属批处理ID"`
+	CustomID  string `json:"custom_id" gorm:"type:varchar(64);comment:客户端指定的请求ID"`
+	Params    string `json:"params" gorm:"type:longtext;comment:消息请求参数(JSON)"`
+	Status    string `json:"status" gorm:"type:varchar(20);default:pending;index:idx_batch_item_status,priority:2;comment:状态(pending/running/succeeded/errored/canceled/expired)"`
+	Attempts  int    `json:"attempts" gorm:"default:0;comment:已执行次数"`
+	Result    string `json:"result" gorm:"type:longtext;comment:执行结果(JSON)"`
+	ClaimedAt *Time  `json:"claimed_at" gorm:"type:datetime;comment:开始执行的时间"`
+	CreatedAt Time   `json:"created_at" gorm:"type:datetime;default:CURRENT_TIMESTAMP"`
+	UpdatedAt Time   `json:"updated_at" gorm:"type:datetime;default:CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP"`
+}
+
+func (i *MessageBatchItem) TableName() string {
+	return "message_batch_items"
+}
+
+// CreateMessageBatch 在同一事务中创建批处理及其全部请求
+func CreateMessageBatch(batch *MessageBatch, items []MessageBatchItem) error {
+	batch.ID = 0
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(batch).Error; err != nil {
+			return err
+		}
+		for i := range items {
+			items[i].ID = 0
+			items[i].BatchID = batch.ID
+		}
+		return tx.CreateInBatches(items, 500).Error
+	})
+}
+
+// GetMessageBatch 根据对外ID获取API Key名下的批处理
+func GetMessageBatch(batchID string, apiKeyID uint) (*MessageBatch, error) {
+	var batch MessageBatch
+	err := DB.Where("batch_id = ? AND api_key_id = ?", batchID, apiKeyID).First(&batch).Error
+	if err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
+
+// GetMessageBatches 按创建时间倒序分页获取API Key名下的批处理
+// beforeID 返回比该批处理更新的记录，afterID 返回比该批处理更早的记录，与官方接口的游标语义一致
+func GetMessageBatches(apiKeyID uint, beforeID, afterID uint, limit int) ([]MessageBatch, error) {
+	var batches []MessageBatch
+	query := DB.Where("api_key_id = ?", apiKeyID)
+	if beforeID > 0 {
+		// 取紧邻游标之后（更新）的一页，查询时升序再反转
+		err := query.Where("id > ?", beforeID).Order("id ASC").Limit(limit).Find(&batches).Error
+		if err != nil {
+			return nil, err
+		}
+		for i, j := 0, len(batches)-1; i < j; i, j = i+1, j-1 {
+			batches[i], batches[j] = batches[j], batches[i]
+		}
+		return batches, nil
+	}
+	if afterID > 0 {
+		query = query.Where("id < ?", afterID)
+	}
+	err := query.Order("id DESC").Limit(limit).Find(&batches).Error
+	return batches, err
+}
+
+// CountMessageBatchItems 统计批处理中各状态的请求数
+func CountMessageBatchItems(batchIDs []uint) (map[uint]map[string]int, error) {
+	counts := make(map[uint]map[string]int, len(batchIDs))
+	if len(batchIDs) == 0 {
+		return counts, nil
+	}
+
+	var rows []struct {
+		BatchID uint
+		Status  string
+		Total   int
+	}
+	err := DB.Model(&MessageBatchItem{}).
+		Select("batch_id, status, COUNT(*) AS total").
+		Where("batch_id IN ?", batchIDs).
+		Group("batch_id, status").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		if counts[row.BatchID] == nil {
+			counts[row.BatchID] = make(map[string]int)
+		}
+		counts[row.BatchID][row.Status] = row.Total
+	}
+	return counts, nil
+}
+
+// GetMessageBatchItems 按提交顺序获取批处理中ID大于 afterID 的请求，用于分批导出结果
+func GetMessageBatchItems(batchID uint, afterID uint, limit int) ([]MessageBatchItem, error) {
+	var items []MessageBatchItem
+	err := DB.Where("batch_id = ? AND id > ?", batchID, afterID).
+		Order("id ASC").
+		Limit(limit).
+		Find(&items).Error
+	return items, err
+}
+
+// CancelMessageBatch 发起取消：尚未执行的请求直接标记为已取消，执行中的请求完成后批处理结束
+func CancelMessageBatch(batch *MessageBatch) error {
+	now := Time(time.Now())
+	return DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&MessageBatch{}).
+			Where("id = ? AND processing_status = ?", batch.ID, BatchStatusInProgress).
+			Updates(map[string]any{
+				"processing_status":   BatchStatusCanceling,
+				"cancel_initiated_at": &now,
+			})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		batch.ProcessingStatus = BatchStatusCanceling
+		batch.CancelInitiatedAt = &now
+		return tx.Model(&MessageBatchItem{}).
+			Where("batch_id = ? AND status = ?", batch.ID, BatchItemPending).
+			Update("status", BatchItemCanceled).Error
+	})
+}
+
+// GetPendingMessageBatchItems 按提交顺序获取处理中批处理里待执行的请求，跳过 excludeBatchIDs 中的批处理
+func GetPendingMessageBatchItems(excludeBatchIDs []uint, limit int) ([]MessageBatchItem, error) {
+	var items []MessageBatchItem
+	query := DB.Joins("JOIN message_batches ON message_batches.id = message_batch_items.batch_id").
+		Where("message_batches.processing_status = ? AND message_batch_items.status = ?", BatchStatusInProgress, BatchItemPending)
+	if len(excludeBatchIDs) > 0 {
+		query = query.Where("message_batch_items.batch_id NOT IN ?", excludeBatchIDs)
+	}
+	err := query.Order("message_batch_items.id ASC").
+		Limit(limit).
+		Find(&items).Error
+	return items, err
+}
+
+// ClaimMessageBatchItem 将待执行的请求标记为执行中，多实例部署时只有一个实例能够成功
+func ClaimMessageBatchItem(item *MessageBatchItem) (bool, error) {
+	now := Time(time.Now())
+	result := DB.Model(&MessageBatchItem{}).
+		Where("id = ? AND status = ?", item.ID, BatchItemPending).
+		Updates(map[string]any{
+			"status":     BatchItemRunning,
+			"attempts":   gorm.Expr("attempts + 1"),
+			"claimed_at": &now,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	item.Status = BatchItemRunning
+	item.Attempts++
+	item.ClaimedAt = &now
+	return true, nil
+}
+
+// FinishMessageBatchItem 保存执行中请求的最终状态和结果
+// 只有仍持有本次领取（执行次数未变）时才保存，请求超时被放回队列并由其他执行重新领取后，旧的执行结果直接丢弃
+func FinishMessageBatchItem(item *MessageBatchItem, status, result string) error {
+	return DB.Model(&MessageBatchItem{}).
+		Where("id = ? AND status = ? AND attempts = ?", item.ID, BatchItemRunning, item.Attempts).
+		Updates(map[string]any{"status": status, "result": result}).Error
+}
+
+// ReleaseMessageBatchItem 执行中的请求放回待执行队列，稍后重试；与 FinishMessageBatchItem 一样只处理本次领取
+func ReleaseMessageBatchItem(item *MessageBatchItem, countAttempt bool) error {
+	updates := map[string]any{"status": BatchItemPending}
+	if !countAttempt {
+		updates["attempts"] = gorm.Expr("attempts - 1")
+	}
+	return DB.Model(&MessageBatchItem{}).
+		Where("id = ? AND status = ? AND attempts = ?", item.ID, BatchItemRunning, item.Attempts).
+		Updates(updates).Error
+}
+
+// RequeueStaleMessageBatchItems 执行时间超过 staleBefore 仍未结束的请求（如实例重启）放回待执行队列
+func RequeueStaleMessageBatchItems(staleBefore time.Time) (int64, error) {
+	result := DB.Model(&MessageBatchItem{}).
+		Where("status = ? AND claimed_at < ?", BatchItemRunning, staleBefore).
+		Update("status", BatchItemPending)
+	return result.RowsAffected, result.Error
+}
+
+// ExpireMessageBatches 过期批处理中尚未执行的请求标记为已过期
+func ExpireMessageBatches(now time.Time) error {
+	var batchIDs []uint
+	err := DB.Model(&MessageBatch{}).
+		Where("processing_status <> ? AND expires_at < ?", BatchStatusEnded, now).
+		Pluck("id", &batchIDs).Error
+	if err != nil || len(batchIDs) == 0 {
+		return err
+	}
+	return DB.Model(&MessageBatchItem{}).
+		Where("batch_id IN ? AND status = ?", batchIDs, BatchItemPending).
+		Update("status", BatchItemExpired).Error
+}
+
+// CancelPendingMessageBatchItems 取消中的批处理里重新放回队列的请求同样标记为已取消
+func CancelPendingMessageBatchItems() error {
+	var batchIDs []uint
+	err := DB.Model(&MessageBatch{}).
+		Where("processing_status = ?", BatchStatusCanceling).
+		Pluck("id", &batchIDs).Error
+	if err != nil || len(batchIDs) == 0 {
+		return err
+	}
+	return DB.Model(&MessageBatchItem{}).
+		Where("batch_id IN ? AND status = ?", batchIDs, BatchItemPending).
+		Update("status", BatchItemCanceled).Error
+}
+
+// EndFinishedMessageBatches 没有待执行和执行中请求的批处理标记为已结束
+func EndFinishedMessageBatches(now time.Time) error {
+	unfinished := DB.Model(&MessageBatchItem{}).
+		Select("1").
+		Where("message_batch_items.batch_id = message_batches.id AND message_batch_items.status IN ?", []string{BatchItemPending, BatchItemRunning})
+	endedAt := Time(now)
+	return DB.Model(&MessageBatch{}).
+		Where("processing_status <> ? AND NOT EXISTS (?)", BatchStatusEnded, unfinished).
+		Updates(map[string]any{
+			"processing_status": BatchStatusEnded,
+			"ended_at":          &endedAt,
+		}).Error
+}
+
+// GetMessageBatchByID 根据主键获取批处理
+func GetMessageBatchByID(id uint) (*MessageBatch, error) {
+	var batch MessageBatch
+	if err := DB.First(&batch, id).Error; err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
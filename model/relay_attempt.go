@@ -0,0 +1,44 @@
+package model
+
+// RelayAttempt 中转尝试记录表 - 记录一次请求在各账号上的每次尝试，用于排查故障转移
+type RelayAttempt struct {
+	ID         uint   `json:"id" gorm:"primaryKey"`
+	RequestID  string `json:"request_id" gorm:"type:varchar(50);index;comment:请求ID"`
+	Attempt    int    `json:"attempt" gorm:"default:1;comment:第几次尝试"`
+	AccountID  uint   `json:"account_id" gorm:"index;comment:账号ID"`
+	ApiKeyID   uint   `json:"api_key_id" gorm:"comment:API Key ID"`
+	StatusCode int    `json:"status_code" gorm:"default:0;comment:上游状态码(0表示网络错误)"`
+	Success    bool   `json:"success" gorm:"default:false;comment:是否成功"`
+	Reason     string `json:"reason" gorm:"type:varchar(500);comment:失败原因"`
+	Duration   int64  `json:"duration" gorm:"comment:耗时(毫秒)"`
+	CreatedAt  Time   `json:"created_at" gorm:"type:datetime;default:CURRENT_TIMESTAMP;index"`
+
+	// 关联查询
+	Account *Account `json:"account,omitempty" gorm:"foreignKey:AccountID"`
+}
+
+func (r *RelayAttempt) TableName() string {
+	return "relay_attempts"
+}
+
+// CreateRelayAttempt 创建中转尝试记录
+func CreateRelayAttempt(attempt *RelayAttempt) error {
+	attempt.ID = 0
+	if reason := []rune(attempt.Reason); len(reason) > 500 {
+		attempt.Reason = string(reason[:500])
+	}
+	return DB.Create(attempt).Error
+}
+
+// GetRelayAttemptsByRequestID 根据请求ID获取全部尝试记录
+func GetRelayAttemptsByRequestID(requestID string) ([]RelayAttempt, error) {
+	var attempts []RelayAttempt
+	err := DB.Preload("Account").
+		Where("request_id = ?", requestID).
+		Order("attempt ASC").
+		Find(&attempts).Error
+	if err != nil {
+		return nil, err
+	}
+	return attempts, nil
+}
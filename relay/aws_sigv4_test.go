@@ -0,0 +1,99 @@
+package relay
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// AWS SigV4 测试套件中的示例凭证
+var awsTestCredentials = AWSCredentials{
+	AccessKeyID:     "AKIDEXAMPLE",
+	SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+}
+
+func TestSignAWSRequestTestSuite(t *testing.T) {
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	tests := []struct {
+		name          string
+		method        string
+		url           string
+		wantSignature string
+	}{
+		{
+			name:          "get-vanilla",
+			method:        http.MethodGet,
+			url:           "https://example.amazonaws.com/",
+			wantSignature: "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:          "post-vanilla",
+			method:        http.MethodPost,
+			url:           "https://example.amazonaws.com/",
+			wantSignature: "5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		},
+		{
+			name:          "get-vanilla-query-order-key-case",
+			method:        http.MethodGet,
+			url:           "https://example.amazonaws.com/?Param2=value2&Param1=value1",
+			wantSignature: "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, tt.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			SignAWSRequest(req, nil, awsTestCredentials, "us-east-1", "service", now)
+
+			want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+				"SignedHeaders=host;x-amz-date, Signature=" + tt.wantSignature
+			if got := req.Header.Get("Authorization"); got != want {
+				t.Errorf("Authorization = %q, want %q", got, want)
+			}
+			if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+				t.Errorf("X-Amz-Date = %q, want 20150830T123600Z", got)
+			}
+		})
+	}
+}
+
+func TestSignAWSRequestSessionToken(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPost, "https://example.amazonaws.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	creds := awsTestCredentials
+	creds.SessionToken = "session-token"
+	SignAWSRequest(req, []byte("{}"), creds, "us-east-1", "service", time.Now())
+
+	if got := req.Header.Get("X-Amz-Security-Token"); got != "session-token" {
+		t.Errorf("X-Amz-Security-Token = %q, want session-token", got)
+	}
+	// Accept 不参与签名，content-type 和临时凭证的 token 需要签名
+	if got := req.Header.Get("Authorization"); !strings.Contains(got, "SignedHeaders=content-type;host;x-amz-date;x-amz-security-token,") {
+		t.Errorf("Authorization = %q, want content-type, host, date and token signed", got)
+	}
+}
+
+func TestAWSCanonicalURI(t *testing.T) {
+	tests := map[string]string{
+		"https://example.amazonaws.com":                                         "/",
+		"https://example.amazonaws.com/model/anthropic.claude-v2%3A1/invoke":    "/model/anthropic.claude-v2%253A1/invoke",
+		"https://example.amazonaws.com/model/a%20b/invoke-with-response-stream": "/model/a%2520b/invoke-with-response-stream",
+	}
+	for rawURL, want := range tests {
+		req, err := http.NewRequest(http.MethodPost, rawURL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := awsCanonicalURI(req); got != want {
+			t.Errorf("awsCanonicalURI(%s) = %q, want %q", rawURL, got, want)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package relay
+
+import (
+	"claude-code-relay/constant"
+	"claude-code-relay/model"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// Azure OpenAI 默认的 API 版本，账号未配置时使用；Responses 接口仅在预览版本中提供
+	azureDefaultAPIVersion          = "2024-10-21"
+	azureDefaultResponsesAPIVersion = "2025-04-01-preview"
+
+	// 上游内容过滤拦截输出时返回给客户端的错误信息
+	contentFilterOutputMessage = "Output blocked by upstream content filtering policy"
+)
+
+// azureOpenAIURL 构造 Azure OpenAI 请求地址
+// Chat Completions 按部署调用：{RequestURL}/openai/deployments/{部署名}/chat/completions?api-version=...
+// Responses 接口不区分部署，部署名作为请求体中的 model：{RequestURL}/openai/responses?api-version=...
+func azureOpenAIURL(account *model.Account, requestPath, deployment string) string {
+	baseURL := strings.TrimSuffix(strings.TrimRight(account.RequestURL, "/"), "/openai")
+
+	apiVersion := account.ApiVersion
+	if requestPath == "/responses" {
+		if apiVersion == "" {
+			apiVersion = azureDefaultResponsesAPIVersion
+		}
+		return baseURL + "/openai/responses?api-version=" + url.QueryEscape(apiVersion)
+	}
+	if apiVersion == "" {
+		apiVersion = azureDefaultAPIVersion
+	}
+	return baseURL + "/openai/deployments/" + url.PathEscape(deployment) + requestPath + "?api-version=" + url.QueryEscape(apiVersion)
+}
+
+// setOpenAIAuthHeader 设置上游认证头：OpenAI使用Bearer，Azure OpenAI使用 api-key
+func setOpenAIAuthHeader(req *http.Request, account *model.Account) {
+	if account.PlatformType == constant.PlatformAzureOpenAI {
+		req.Header.Set("api-key", account.SecretKey)
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+account.SecretKey)
+}
+
+// azureErrorResponse 将 Azure OpenAI 错误响应转换为Claude错误格式，内容过滤拦截的请求转换为 invalid_request_error
+func azureErrorResponse(statusCode int, body []byte) gin.H {
+	errorInfo := gjson.GetBytes(body, "error")
+
+	errorType := "api_error"
+	switch statusCode {
+	case http.StatusBadRequest:
+		errorType = "invalid_request_error"
+	case http.StatusUnauthorized:
+		errorType = "authentication_error"
+	case http.StatusForbidden:
+		errorType = "permission_error"
+	case http.StatusNotFound:
+		errorType = "not_found_error"
+	case http.StatusTooManyRequests:
+		errorType = "rate_limit_error"
+	}
+
+	message := errorInfo.Get("message").String()
+	if errorInfo.Get("code").String() == "content_filter" || errorInfo.Get("innererror.code").String() == "ResponsibleAIPolicyViolation" {
+		errorType = "invalid_request_error"
+		message = "Prompt blocked by Azure OpenAI content filter"
+		if categories := filteredCategories(errorInfo.Get("innererror.content_filter_result")); len(categories) > 0 {
+			message += " (" + strings.Join(categories, ", ") + ")"
+		}
+	}
+	if message == "" {
+		message = "Request failed with status " + strconv.Itoa(statusCode)
+	}
+
+	return gin.H{
+		"type": "error",
+		"error": map[string]any{
+			"type":    errorType,
+			"message": message,
+		},
+	}
+}
+
+// filteredCategories 提取内容过滤结果中被拦截的类别（如 hate、violence）
+func filteredCategories(result gjson.Result) []string {
+	var categories []string
+	result.ForEach(func(key, value gjson.Result) bool {
+		if value.Get("filtered").Bool() {
+			categories = append(categories, key.String())
+		}
+		return true
+	})
+	sort.Strings(categories)
+	return categories
+}
+
+// contentFilterError 上游内容过滤中途拦截输出时返回给客户端的Claude错误
+func contentFilterError() map[string]any {
+	return map[string]any{
+		"type": "error",
+		"error": map[string]any{
+			"type":    "invalid_request_error",
+			"message": contentFilterOutputMessage,
+		},
+	}
+}
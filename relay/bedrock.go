@@ -0,0 +1,293 @@
+package relay
+
+import (
+	"bytes"
+	"claude-code-relay/common"
+	"claude-code-relay/model"
+	"claude-code-relay/service"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+const (
+	// Bedrock 签名使用的服务名
+	bedrockSigningService = "bedrock"
+	// Bedrock 要求请求体中携带的 anthropic_version
+	bedrockAnthropicVersion = "bedrock-2023-05-31"
+)
+
+// HandleBedrockRequest 处理 AWS Bedrock 平台的请求
+// 请求体去掉 Bedrock 不接受的字段后以 SigV4 签名调用 InvokeModelWithResponseStream，响应的事件流还原为Claude SSE
+func HandleBedrockRequest(c *gin.Context, account *model.Account) *RelayResult {
+	startTime := time.Now()
+
+	var apiKey *model.ApiKey
+	if keyInfo, exists := c.Get("api_key"); exists {
+		apiKey = keyInfo.(*model.ApiKey)
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": map[string]any{
+				"type":    "request_body_error",
+				"message": "Failed to read request body: " + err.Error(),
+			},
+		})
+		return failedResult(http.StatusBadRequest, err.Error())
+	}
+
+	modelName := gjson.GetBytes(body, "model").String()
+	bedrockModel := applyModelMapping(modelName, account.ModelMapping, modelName)
+	betas := resolveBetas(c, account, nil, nil)
+	bedrockBody, err := buildBedrockRequestBody(body, betas)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": map[string]any{
+				"type":    "invalid_request_error",
+				"message": "Failed to build Bedrock request: " + err.Error(),
+			},
+		})
+		return failedResult(http.StatusBadRequest, err.Error())
+	}
+
+	req, err := createBedrockRequest(c.Request.Context(), account, bedrockModel, true, bedrockBody)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": map[string]any{
+				"type":    "internal_server_error",
+				"message": "Failed to create request: " + err.Error(),
+			},
+		})
+		return failedResult(http.StatusInternalServerError, err.Error())
+	}
+
+	client, err := createBedrockHTTPClient(account, parseHTTPTimeout())
+	if err != nil {
+		if CanFailover(c) {
+			return retryableResult(0, "代理配置错误")
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": map[string]any{
+				"type":    "proxy_configuration_error",
+				"message": "Invalid proxy URI: " + err.Error(),
+			},
+		})
+		return failedResult(http.StatusInternalServerError, err.Error())
+	}
+
+	resp, err := doWithBetaRetry(client, req, betas, nil, func(betas []string) (*http.Request, error) {
+		retryBody, err := buildBedrockRequestBody(body, betas)
+		if err != nil {
+			return nil, err
+		}
+		return createBedrockRequest(c.Request.Context(), account, bedrockModel, true, retryBody)
+	})
+	if err != nil {
+		log.Printf("Bedrock API request failed: %v", err)
+		if !errors.Is(err, context.Canceled) && CanFailover(c) {
+			return retryableResult(0, "请求失败: "+err.Error())
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": map[string]any{
+				"type":    "network_error",
+				"message": "Failed to execute request: " + err.Error(),
+			},
+		})
+		return failedResult(0, err.Error())
+	}
+	defer common.CloseIO(resp.Body)
+
+	accountService := service.NewAccountService()
+	if resp.StatusCode >= 400 {
+		accountService.UpdateAccountStatus(account, resp.StatusCode, nil)
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		log.Printf("❌ Bedrock 错误响应内容: %s", string(bodyBytes))
+		reason := "上游返回状态码 " + strconv.Itoa(resp.StatusCode)
+		if isRetryableStatus(resp.StatusCode) && CanFailover(c) {
+			return retryableResult(resp.StatusCode, reason)
+		}
+		c.JSON(resp.StatusCode, bedrockErrorResponse(resp, bodyBytes))
+		return failedResult(resp.StatusCode, reason)
+	}
+
+	c.Status(resp.StatusCode)
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Writer.Flush()
+
+	usageTokens, err := common.ParseStreamResponse(c.Writer, NewBedrockStreamReader(resp.Body))
+	if err != nil {
+		log.Println("Bedrock stream copy and parse failed:", err.Error())
+	}
+	if usageTokens != nil && usageTokens.Model == "" {
+		usageTokens.Model = modelName
+	}
+
+	if shouldUpdateAccountStats(c, resp.StatusCode) {
+		go accountService.UpdateAccountStatus(account, resp.StatusCode, usageTokens)
+	}
+
+	if apiKey != nil && claimBilling(c, resp.StatusCode) {
+		go service.UpdateApiKeyStatus(apiKey, resp.StatusCode, usageTokens)
+		saveRequestLog(startTime, apiKey, account, resp.StatusCode, usageTokens, true, extractClientInfo(c))
+	}
+	return &RelayResult{StatusCode: resp.StatusCode}
+}
+
+// buildBedrockRequestBody 将Claude请求体转换为 Bedrock 格式：模型在URL中指定，流式由接口决定，不支持 metadata，beta 通过 anthropic_beta 字段传递
+func buildBedrockRequestBody(body []byte, betas []string) ([]byte, error) {
+	var err error
+	for _, field := range []string{"model", "stream", "metadata"} {
+		if body, err = sjson.DeleteBytes(body, field); err != nil {
+			return nil, err
+		}
+	}
+	if !gjson.GetBytes(body, "anthropic_version").Exists() {
+		if body, err = sjson.SetBytes(body, "anthropic_version", bedrockAnthropicVersion); err != nil {
+			return nil, err
+		}
+	}
+	if len(betas) > 0 {
+		if body, err = sjson.SetBytes(body, "anthropic_beta", betas); err != nil {
+			return nil, err
+		}
+	}
+	return body, nil
+}
+
+// bedrockBaseURL 获取 Bedrock Runtime 地址，账号配置了请求地址时使用账号的地址（便于接入代理或本地桩服务）
+func bedrockBaseURL(account *model.Account) string {
+	if baseURL := strings.TrimRight(account.RequestURL, "/"); baseURL != "" {
+		return baseURL
+	}
+	return "https://bedrock-runtime." + account.Region + ".amazonaws.com"
+}
+
+// createBedrockRequest 创建已签名的 Bedrock 请求，stream 为true时调用 InvokeModelWithResponseStream
+func createBedrockRequest(ctx context.Context, account *model.Account, bedrockModel string, stream bool, body []byte) (*http.Request, error) {
+	if account.Region == "" || account.AccessKeyID == "" || account.SecretKey == "" {
+		return nil, errors.New("bedrock account requires region, access key id and secret access key")
+	}
+
+	// 模型ID（如 anthropic.claude-3-5-sonnet-20241022-v2:0）作为路径段需要编码
+	requestURL := bedrockBaseURL(account) + "/model/" + awsURIEncode(bedrockModel) + "/invoke"
+	accept := "application/json"
+	if stream {
+		requestURL += "-with-response-stream"
+		accept = "application/vnd.amazon.eventstream"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, requestURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", accept)
+
+	SignAWSRequest(req, body, AWSCredentials{
+		AccessKeyID:     account.AccessKeyID,
+		SecretAccessKey: account.SecretKey,
+		SessionToken:    account.SessionToken,
+	}, account.Region, bedrockSigningService, time.Now())
+	return req, nil
+}
+
+// createBedrockHTTPClient 创建Bedrock HTTP客户端
+func createBedrockHTTPClient(account *model.Account, timeout time.Duration) (*http.Client, error) {
+	transport := &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+
+	if account.ProxyURI != "" {
+		proxyURL, err := url.Parse(account.ProxyURI)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+	}, nil
+}
+
+// bedrockErrorResponse 将 Bedrock 错误响应（{"message": ...} 及 x-amzn-ErrorType 响应头）转换为Claude错误格式
+func bedrockErrorResponse(resp *http.Response, body []byte) gin.H {
+	errorType := bedrockErrorType(resp.Header.Get("X-Amzn-Errortype"))
+	if errorType == "api_error" {
+		switch resp.StatusCode {
+		case http.StatusBadRequest:
+			errorType = "invalid_request_error"
+		case http.StatusUnauthorized:
+			errorType = "authentication_error"
+		case http.StatusForbidden:
+			errorType = "permission_error"
+		case http.StatusTooManyRequests:
+			errorType = "rate_limit_error"
+		}
+	}
+
+	message := gjson.GetBytes(body, "message").String()
+	if message == "" {
+		message = gjson.GetBytes(body, "Message").String()
+	}
+	if message == "" {
+		message = "Request failed with status " + strconv.Itoa(resp.StatusCode)
+	}
+
+	return gin.H{
+		"type": "error",
+		"error": map[string]any{
+			"type":    errorType,
+			"message": message,
+		},
+	}
+}
+
+// TestHandleBedrockRequest 测试Bedrock账号连通性
+func TestHandleBedrockRequest(account *model.Account) (int, string) {
+	testBody := GetTestRequestBody(1024)
+	body, err := buildBedrockRequestBody([]byte(testBody), nil)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to build request: " + err.Error()
+	}
+	modelName := gjson.Get(testBody, "model").String()
+	bedrockModel := applyModelMapping(modelName, account.ModelMapping, modelName)
+
+	req, err := createBedrockRequest(context.Background(), account, bedrockModel, false, body)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to create request: " + err.Error()
+	}
+
+	client, err := createBedrockHTTPClient(account, parseHTTPTimeout())
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to create HTTP client: " + err.Error()
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return http.StatusInternalServerError, "Request failed: " + err.Error()
+	}
+	defer common.CloseIO(resp.Body)
+
+	if resp.StatusCode >= 400 {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, gjson.GetBytes(bodyBytes, "message").String()
+	}
+	return resp.StatusCode, ""
+}
@@ -0,0 +1,220 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// 事件流消息的前导部分：总长度、头部长度、前导CRC，各4字节
+	eventStreamPreludeLength = 12
+	// 消息末尾的CRC长度
+	eventStreamCRCLength = 4
+	// 单条消息的最大长度，超过视为数据损坏
+	eventStreamMaxMessageLength = 16 * 1024 * 1024
+)
+
+// eventStreamMessage 一条 AWS 事件流（application/vnd.amazon.eventstream）消息
+type eventStreamMessage struct {
+	Headers map[string]string
+	Payload []byte
+}
+
+// readEventStreamMessage 从 reader 中读取并校验一条事件流消息，流正常结束时返回 io.EOF
+func readEventStreamMessage(reader io.Reader) (*eventStreamMessage, error) {
+	prelude := make([]byte, eventStreamPreludeLength)
+	if _, err := io.ReadFull(reader, prelude); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, errors.New("truncated event stream prelude")
+		}
+		return nil, err
+	}
+
+	totalLength := binary.BigEndian.Uint32(prelude[0:4])
+	headersLength := binary.BigEndian.Uint32(prelude[4:8])
+	if crc32.ChecksumIEEE(prelude[0:8]) != binary.BigEndian.Uint32(prelude[8:12]) {
+		return nil, errors.New("event stream prelude checksum mismatch")
+	}
+	minLength := uint32(eventStreamPreludeLength + eventStreamCRCLength)
+	if totalLength < minLength || totalLength > eventStreamMaxMessageLength || headersLength > totalLength-minLength {
+		return nil, fmt.Errorf("invalid event stream message length %d", totalLength)
+	}
+
+	message := make([]byte, totalLength)
+	copy(message, prelude)
+	if _, err := io.ReadFull(reader, message[eventStreamPreludeLength:]); err != nil {
+		return nil, errors.New("truncated event stream message")
+	}
+	crcOffset := totalLength - eventStreamCRCLength
+	if crc32.ChecksumIEEE(message[:crcOffset]) != binary.BigEndian.Uint32(message[crcOffset:]) {
+		return nil, errors.New("event stream message checksum mismatch")
+	}
+
+	headersEnd := eventStreamPreludeLength + headersLength
+	headers, err := parseEventStreamHeaders(message[eventStreamPreludeLength:headersEnd])
+	if err != nil {
+		return nil, err
+	}
+	return &eventStreamMessage{Headers: headers, Payload: message[headersEnd:crcOffset]}, nil
+}
+
+// parseEventStreamHeaders 解析消息头，只保留字符串类型的值，其余类型跳过
+func parseEventStreamHeaders(data []byte) (map[string]string, error) {
+	headers := make(map[string]string)
+	errMalformed := errors.New("malformed event stream headers")
+
+	for len(data) > 0 {
+		nameLength := int(data[0])
+		if len(data) < 1+nameLength+1 {
+			return nil, errMalformed
+		}
+		name := string(data[1 : 1+nameLength])
+		valueType := data[1+nameLength]
+		data = data[2+nameLength:]
+
+		// 值类型：0/1 布尔，2 byte，3 short，4 int，5 long，6 字节数组，7 字符串，8 时间戳，9 UUID
+		var valueLength int
+		switch valueType {
+		case 0, 1:
+			valueLength = 0
+		case 2:
+			valueLength = 1
+		case 3:
+			valueLength = 2
+		case 4:
+			valueLength = 4
+		case 5, 8:
+			valueLength = 8
+		case 9:
+			valueLength = 16
+		case 6, 7:
+			if len(data) < 2 {
+				return nil, errMalformed
+			}
+			valueLength = int(binary.BigEndian.Uint16(data[:2]))
+			data = data[2:]
+		default:
+			return nil, fmt.Errorf("unknown event stream header type %d", valueType)
+		}
+
+		if len(data) < valueLength {
+			return nil, errMalformed
+		}
+		if valueType == 7 {
+			headers[name] = string(data[:valueLength])
+		}
+		data = data[valueLength:]
+	}
+	return headers, nil
+}
+
+// BedrockStreamReader 将 Bedrock InvokeModelWithResponseStream 的事件流转换为 Anthropic SSE
+// chunk 事件中 base64 编码的内容即为 Anthropic 原生的流式事件，异常消息转换为 error 事件
+type BedrockStreamReader struct {
+	src io.Reader
+	buf bytes.Buffer
+	err error
+}
+
+// NewBedrockStreamReader 创建事件流到SSE的转换读取器
+func NewBedrockStreamReader(src io.Reader) *BedrockStreamReader {
+	return &BedrockStreamReader{src: src}
+}
+
+func (r *BedrockStreamReader) Read(p []byte) (int, error) {
+	for r.buf.Len() == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.err = r.next()
+	}
+	return r.buf.Read(p)
+}
+
+// next 读取下一条事件流消息并写入SSE缓冲区
+func (r *BedrockStreamReader) next() error {
+	message, err := readEventStreamMessage(r.src)
+	if err != nil {
+		if !errors.Is(err, io.EOF) {
+			r.writeError("api_error", "Bedrock stream error: "+err.Error())
+		}
+		return err
+	}
+
+	switch message.Headers[":message-type"] {
+	case "event":
+		if message.Headers[":event-type"] != "chunk" {
+			return nil
+		}
+		encoded := gjson.GetBytes(message.Payload, "bytes").String()
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			r.writeError("api_error", "Bedrock stream chunk is not valid base64")
+			return err
+		}
+		eventType := gjson.GetBytes(data, "type").String()
+		r.buf.WriteString("event: " + eventType + "\ndata: ")
+		r.buf.Write(data)
+		r.buf.WriteString("\n\n")
+		return nil
+	case "exception":
+		exceptionType := message.Headers[":exception-type"]
+		errorMessage := gjson.GetBytes(message.Payload, "message").String()
+		if errorMessage == "" {
+			errorMessage = exceptionType
+		}
+		r.writeError(bedrockErrorType(exceptionType), errorMessage)
+		return io.EOF
+	case "error":
+		r.writeError("api_error", message.Headers[":error-code"]+": "+message.Headers[":error-message"])
+		return io.EOF
+	default:
+		return nil
+	}
+}
+
+// writeError 写入 Anthropic 格式的 error 事件
+func (r *BedrockStreamReader) writeError(errorType, message string) {
+	data, _ := json.Marshal(map[string]any{
+		"type": "error",
+		"error": map[string]any{
+			"type":    errorType,
+			"message": message,
+		},
+	})
+	r.buf.WriteString("event: error\ndata: ")
+	r.buf.Write(data)
+	r.buf.WriteString("\n\n")
+}
+
+// bedrockErrorType 将 Bedrock 异常类型映射为 Anthropic 错误类型
+// 流中的异常为 throttlingException 形式，HTTP 响应头 x-amzn-ErrorType 为 ThrottlingException:http://... 形式
+func bedrockErrorType(exceptionType string) string {
+	exceptionType = strings.ToLower(exceptionType)
+	if idx := strings.Index(exceptionType, ":"); idx >= 0 {
+		exceptionType = exceptionType[:idx]
+	}
+	switch exceptionType {
+	case "throttlingexception", "servicequotaexceededexception":
+		return "rate_limit_error"
+	case "validationexception", "resourcenotfoundexception":
+		return "invalid_request_error"
+	case "accessdeniedexception":
+		return "permission_error"
+	case "unrecognizedclientexception", "expiredtokenexception", "invalidsignatureexception":
+		return "authentication_error"
+	case "serviceunavailableexception", "modelnotreadyexception":
+		return "overloaded_error"
+	default:
+		return "api_error"
+	}
+}
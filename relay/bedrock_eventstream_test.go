@@ -0,0 +1,157 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"testing"
+)
+
+// encodeEventStreamMessage 按 AWS 事件流格式编码一条只含字符串头部的消息
+func encodeEventStreamMessage(headers [][2]string, payload []byte) []byte {
+	var headerBytes bytes.Buffer
+	for _, header := range headers {
+		headerBytes.WriteByte(byte(len(header[0])))
+		headerBytes.WriteString(header[0])
+		headerBytes.WriteByte(7)
+		_ = binary.Write(&headerBytes, binary.BigEndian, uint16(len(header[1])))
+		headerBytes.WriteString(header[1])
+	}
+
+	totalLength := eventStreamPreludeLength + headerBytes.Len() + len(payload) + eventStreamCRCLength
+	message := make([]byte, 0, totalLength)
+	message = binary.BigEndian.AppendUint32(message, uint32(totalLength))
+	message = binary.BigEndian.AppendUint32(message, uint32(headerBytes.Len()))
+	message = binary.BigEndian.AppendUint32(message, crc32.ChecksumIEEE(message))
+	message = append(message, headerBytes.Bytes()...)
+	message = append(message, payload...)
+	return binary.BigEndian.AppendUint32(message, crc32.ChecksumIEEE(message))
+}
+
+// encodeBedrockChunk 编码一条携带 Anthropic 流式事件的 chunk 消息
+func encodeBedrockChunk(event string) []byte {
+	payload := `{"bytes":"` + base64.StdEncoding.EncodeToString([]byte(event)) + `"}`
+	return encodeEventStreamMessage([][2]string{
+		{":message-type", "event"},
+		{":event-type", "chunk"},
+		{":content-type", "application/json"},
+	}, []byte(payload))
+}
+
+func TestReadEventStreamMessage(t *testing.T) {
+	frame := encodeBedrockChunk(`{"type":"ping"}`)
+	reader := bytes.NewReader(append(frame, frame...))
+
+	for i := 0; i < 2; i++ {
+		message, err := readEventStreamMessage(reader)
+		if err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+		if message.Headers[":event-type"] != "chunk" || message.Headers[":message-type"] != "event" {
+			t.Errorf("message %d headers = %v", i, message.Headers)
+		}
+		if !bytes.HasPrefix(message.Payload, []byte(`{"bytes":`)) {
+			t.Errorf("message %d payload = %s", i, message.Payload)
+		}
+	}
+	if _, err := readEventStreamMessage(reader); !errors.Is(err, io.EOF) {
+		t.Errorf("end of stream error = %v, want io.EOF", err)
+	}
+}
+
+func TestReadEventStreamMessageErrors(t *testing.T) {
+	frame := encodeBedrockChunk(`{"type":"ping"}`)
+	corrupt := func(offset int) []byte {
+		data := bytes.Clone(frame)
+		data[offset] ^= 0xff
+		return data
+	}
+
+	// 头部长度超过消息长度时，即使前导CRC有效也应拒绝
+	invalidLength := binary.BigEndian.AppendUint32(nil, 16)
+	invalidLength = binary.BigEndian.AppendUint32(invalidLength, 8)
+	invalidLength = binary.BigEndian.AppendUint32(invalidLength, crc32.ChecksumIEEE(invalidLength))
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr string
+	}{
+		{name: "message crc mismatch", data: corrupt(len(frame) - eventStreamCRCLength - 1), wantErr: "event stream message checksum mismatch"},
+		{name: "prelude crc mismatch", data: corrupt(1), wantErr: "event stream prelude checksum mismatch"},
+		{name: "truncated frame", data: frame[:len(frame)-2], wantErr: "truncated event stream message"},
+		{name: "truncated prelude", data: frame[:eventStreamPreludeLength-4], wantErr: "truncated event stream prelude"},
+		{name: "invalid header length", data: append(invalidLength, 0, 0, 0, 0), wantErr: "invalid event stream message length 16"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readEventStreamMessage(bytes.NewReader(tt.data))
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBedrockStreamReader(t *testing.T) {
+	var stream bytes.Buffer
+	stream.Write(encodeBedrockChunk(`{"type":"message_start","message":{"id":"msg_1"}}`))
+	stream.Write(encodeEventStreamMessage([][2]string{{":message-type", "event"}, {":event-type", "metadata"}}, []byte(`{}`)))
+	stream.Write(encodeBedrockChunk(`{"type":"message_stop"}`))
+
+	output, err := io.ReadAll(NewBedrockStreamReader(&stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"msg_1\"}}\n\n" +
+		"event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n"
+	if string(output) != want {
+		t.Errorf("output = %q, want %q", output, want)
+	}
+}
+
+func TestBedrockStreamReaderErrors(t *testing.T) {
+	frame := encodeBedrockChunk(`{"type":"message_stop"}`)
+	tests := []struct {
+		name     string
+		data     []byte
+		wantType string
+		wantMsg  string
+	}{
+		{
+			name: "exception message",
+			data: encodeEventStreamMessage([][2]string{
+				{":message-type", "exception"},
+				{":exception-type", "throttlingException"},
+			}, []byte(`{"message":"Too many requests"}`)),
+			wantType: "rate_limit_error",
+			wantMsg:  "Too many requests",
+		},
+		{
+			name:     "truncated frame",
+			data:     frame[:len(frame)-3],
+			wantType: "api_error",
+			wantMsg:  "Bedrock stream error: truncated event stream message",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, _ := io.ReadAll(NewBedrockStreamReader(bytes.NewReader(tt.data)))
+			events := parseSSEEvents(string(output))
+			if len(events) != 1 || events[0].name != "error" {
+				t.Fatalf("events = %q, want a single error event", output)
+			}
+			if got := events[0].data.Get("error.type").String(); got != tt.wantType {
+				t.Errorf("error.type = %q, want %q", got, tt.wantType)
+			}
+			if got := events[0].data.Get("error.message").String(); got != tt.wantMsg {
+				t.Errorf("error.message = %q, want %q", got, tt.wantMsg)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package relay
+
+import (
+	"claude-code-relay/model"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestBedrockRequestSignature(t *testing.T) {
+	server, captured := newCaptureServer(t, "application/json", `{"type":"message","content":[]}`)
+	account := &model.Account{
+		RequestURL:   server.URL + "/",
+		Region:       "us-west-2",
+		AccessKeyID:  awsTestCredentials.AccessKeyID,
+		SecretKey:    awsTestCredentials.SecretAccessKey,
+		SessionToken: "session-token",
+		ModelMapping: "claude-sonnet:anthropic.claude-sonnet-4-20250514-v1:0",
+	}
+
+	statusCode, message := TestHandleBedrockRequest(account)
+	if statusCode != http.StatusOK {
+		t.Fatalf("TestHandleBedrockRequest = %d %s, want 200", statusCode, message)
+	}
+
+	// 模型ID中的冒号在路径中编码，签名时再编码一次
+	if captured.method != http.MethodPost || captured.escapedPath != "/model/anthropic.claude-sonnet-4-20250514-v1%3A0/invoke" {
+		t.Fatalf("request = %s %s, want POST to the encoded invoke path", captured.method, captured.escapedPath)
+	}
+
+	// 按桩服务实际收到的请求重新计算签名，与请求携带的签名比对
+	amzDate := captured.header.Get("X-Amz-Date")
+	signedAt, err := time.Parse(awsAmzDateFormat, amzDate)
+	if err != nil {
+		t.Fatalf("X-Amz-Date = %q: %v", amzDate, err)
+	}
+	verify, err := http.NewRequest(captured.method, server.URL+captured.escapedPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify.Header.Set("Content-Type", captured.header.Get("Content-Type"))
+	creds := awsTestCredentials
+	creds.SessionToken = "session-token"
+	SignAWSRequest(verify, captured.body, creds, "us-west-2", bedrockSigningService, signedAt)
+
+	authorization := captured.header.Get("Authorization")
+	if want := verify.Header.Get("Authorization"); authorization != want {
+		t.Errorf("Authorization = %q, want %q", authorization, want)
+	}
+	wantPrefix := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/" + signedAt.Format(awsDateStampFormat) + "/us-west-2/bedrock/aws4_request, " +
+		"SignedHeaders=content-type;host;x-amz-date;x-amz-security-token, Signature="
+	if !strings.HasPrefix(authorization, wantPrefix) {
+		t.Errorf("Authorization = %q, want prefix %q", authorization, wantPrefix)
+	}
+	if got := captured.header.Get("X-Amz-Security-Token"); got != "session-token" {
+		t.Errorf("X-Amz-Security-Token = %q, want session-token", got)
+	}
+
+	// Bedrock 请求体不含 model 和 stream，由URL决定
+	body := gjson.ParseBytes(captured.body)
+	if body.Get("model").Exists() || body.Get("stream").Exists() {
+		t.Errorf("request body should not carry model or stream: %s", captured.body)
+	}
+	if body.Get("anthropic_version").String() != bedrockAnthropicVersion {
+		t.Errorf("anthropic_version = %q, want %q", body.Get("anthropic_version").String(), bedrockAnthropicVersion)
+	}
+}
@@ -0,0 +1,178 @@
+package relay
+
+import (
+	"bytes"
+	"claude-code-relay/common"
+	"claude-code-relay/model"
+	"claude-code-relay/service"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// ctxKeyGroupBetaPolicy 上下文中保存当前调度分组的 anthropic-beta 策略
+const ctxKeyGroupBetaPolicy = "relay_group_beta_policy"
+
+var (
+	// claudeDefaultBetas Claude官方、Claude Console账号默认携带的 beta
+	claudeDefaultBetas = []string{"claude-code-20250219", "oauth-2025-04-20", "interleaved-thinking-2025-05-14", "fine-grained-tool-streaming-2025-05-14"}
+	// claudeOAuthBetas Claude官方账号使用OAuth令牌时必需的 beta，不受策略影响
+	claudeOAuthBetas = []string{"oauth-2025-04-20"}
+
+	// emulatedBetaPatterns 转换协议的账号（OpenAI、Gemini）可以兼容的 beta：只影响客户端身份、缓存或流式粒度，或已有等价转换
+	emulatedBetaPatterns = []string{
+		"claude-code-*",
+		"oauth-*",
+		"interleaved-thinking-*",
+		"fine-grained-tool-streaming-*",
+		"token-efficient-tools-*",
+		"prompt-caching-*",
+	}
+)
+
+// SetGroupBetaPolicy 设置当前调度分组的 anthropic-beta 策略，分组为nil时清除
+func SetGroupBetaPolicy(c *gin.Context, group *model.Group) {
+	policy := ""
+	if group != nil {
+		policy = group.BetaPolicy
+	}
+	c.Set(ctxKeyGroupBetaPolicy, policy)
+}
+
+// betaPolicies 获取当前请求生效的策略：先分组后账号
+func betaPolicies(c *gin.Context, account *model.Account) []*service.BetaPolicy {
+	return service.LoadBetaPolicies(c.GetString(ctxKeyGroupBetaPolicy), account.BetaPolicy)
+}
+
+// resolveBetas 计算发往上游的 beta 列表：默认 beta 与客户端请求的 beta 合并后应用分组和账号的策略，required 始终保留
+func resolveBetas(c *gin.Context, account *model.Account, defaults, required []string) []string {
+	flags := append(append([]string{}, defaults...), c.Request.Header.Values("anthropic-beta")...)
+	betas := service.ApplyBetaPolicies(service.ParseBetaFlags(flags), betaPolicies(c, account))
+	return service.ParseBetaFlags(append(append([]string{}, required...), betas...))
+}
+
+// setBetaHeader 设置上游请求的 anthropic-beta 请求头，列表为空时去掉
+func setBetaHeader(req *http.Request, betas []string) {
+	if len(betas) == 0 {
+		req.Header.Del("anthropic-beta")
+		return
+	}
+	req.Header.Set("anthropic-beta", strings.Join(betas, ","))
+}
+
+// doWithBetaRetry 发送请求，上游以400拒绝所携带的 beta 时去掉被拒绝的 beta 重试一次
+// rebuild 使用新的 beta 列表重新创建请求；required 中的 beta 不会被去掉
+func doWithBetaRetry(client *http.Client, req *http.Request, betas, required []string, rebuild func(betas []string) (*http.Request, error)) (*http.Response, error) {
+	resp, err := client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusBadRequest || len(betas) == 0 {
+		return resp, err
+	}
+
+	raw, err := io.ReadAll(resp.Body)
+	common.CloseIO(resp.Body)
+	// 原样放回响应体（可能是压缩的），不重试时由调用方按正常流程处理
+	resp.Body = io.NopCloser(bytes.NewReader(raw))
+	if err != nil {
+		return resp, nil
+	}
+
+	rejected := rejectedBetas(decodeErrorBody(resp, raw), betas, required)
+	if len(rejected) == 0 {
+		return resp, nil
+	}
+
+	retryBetas := make([]string, 0, len(betas))
+	for _, flag := range betas {
+		if !slices.Contains(rejected, flag) {
+			retryBetas = append(retryBetas, flag)
+		}
+	}
+	retryReq, err := rebuild(retryBetas)
+	if err != nil {
+		return resp, nil
+	}
+	log.Printf("上游不支持 beta %s，去掉后重试", strings.Join(rejected, ","))
+	return client.Do(retryReq)
+}
+
+// decodeErrorBody 按 Content-Encoding 解压错误响应体，失败时返回原始内容
+func decodeErrorBody(resp *http.Response, raw []byte) []byte {
+	reader, err := createResponseReader(&http.Response{Header: resp.Header, Body: io.NopCloser(bytes.NewReader(raw))})
+	if err != nil {
+		return raw
+	}
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		return raw
+	}
+	return decoded
+}
+
+// rejectedBetas 从400错误中识别上游不支持的 beta
+// 错误信息中点名的 beta 视为被拒绝；提到 beta 却没有点名时（如 Bedrock 的 invalid beta flag）视为全部可选 beta 被拒绝
+func rejectedBetas(body []byte, betas, required []string) []string {
+	message := gjson.GetBytes(body, "error.message").String()
+	if message == "" {
+		message = gjson.GetBytes(body, "message").String()
+	}
+	lowerMessage := strings.ToLower(message)
+	if !strings.Contains(lowerMessage, "beta") {
+		return nil
+	}
+
+	var named, optional []string
+	for _, flag := range betas {
+		if slices.Contains(required, flag) {
+			continue
+		}
+		optional = append(optional, flag)
+		if strings.Contains(lowerMessage, flag) || (strings.Contains(lowerMessage, "long context") && strings.HasPrefix(flag, "context-1m-")) {
+			named = append(named, flag)
+		}
+	}
+	if len(named) > 0 {
+		return named
+	}
+	return optional
+}
+
+// rejectUnsupportedBetas 转换协议的账号无法提供的 beta 直接拒绝，而不是静默忽略
+// 返回nil表示所有 beta 都可以兼容；可以切换账号时交由其他账号（如Claude官方账号）处理，不计入该账号的故障
+func rejectUnsupportedBetas(c *gin.Context, account *model.Account) *RelayResult {
+	flags := service.FilterBetas(service.ParseBetaFlags(c.Request.Header.Values("anthropic-beta")), betaPolicies(c, account))
+
+	var unsupported []string
+	for _, flag := range flags {
+		supported := false
+		for _, pattern := range emulatedBetaPatterns {
+			if service.MatchBeta(pattern, flag) {
+				supported = true
+				break
+			}
+		}
+		if !supported {
+			unsupported = append(unsupported, flag)
+		}
+	}
+	if len(unsupported) == 0 {
+		return nil
+	}
+
+	reason := "账号不支持 beta: " + strings.Join(unsupported, ",")
+	if CanFailover(c) {
+		return clientErrorResult(http.StatusBadRequest, reason)
+	}
+	c.JSON(http.StatusBadRequest, gin.H{
+		"type": "error",
+		"error": map[string]any{
+			"type":    "invalid_request_error",
+			"message": "Unsupported anthropic-beta value(s) for this upstream: " + strings.Join(unsupported, ", "),
+		},
+	})
+	return failedResult(http.StatusBadRequest, reason)
+}
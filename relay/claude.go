@@ -3,6 +3,7 @@ package relay
 import (
 	"bytes"
 	"claude-code-relay/common"
+	"claude-code-relay/constant"
 	"claude-code-relay/model"
 	"claude-code-relay/service"
 	"compress/flate"
@@ -71,68 +72,85 @@ type OAuthTokenResponse struct {
 }
 
 // HandleClaudeRequest 处理Claude官方API平台的请求
-func HandleClaudeRequest(c *gin.Context, account *model.Account) {
+func HandleClaudeRequest(c *gin.Context, account *model.Account) *RelayResult {
 	startTime := time.Now()
 
 	apiKey := extractAPIKey(c)
 
 	requestData, err := parseAndValidateRequest(c)
 	if err != nil {
-		return
-	}
-
-	if apiKey != nil {
-		if err := validateModelRestriction(c, apiKey, requestData.ModelName); err != nil {
-			return
-		}
+		return failedResult(http.StatusBadRequest, err.Error())
 	}
 
 	accessToken, err := GetValidAccessToken(account)
 	if err != nil {
 		log.Printf("获取有效访问token失败: %v", err)
+		if CanFailover(c) {
+			return retryableResult(0, "获取访问token失败: "+err.Error())
+		}
 		c.JSON(http.StatusInternalServerError, appendErrorMessage(errAuthFailed, err.Error()))
-		return
+		return failedResult(http.StatusInternalServerError, err.Error())
 	}
 
 	client := createHTTPClient(account)
 	if client == nil {
+		if CanFailover(c) {
+			return retryableResult(0, "代理配置错误")
+		}
 		c.JSON(http.StatusInternalServerError, errProxyConfig)
-		return
+		return failedResult(http.StatusInternalServerError, "invalid proxy URI")
 	}
 
-	req, err := createClaudeRequest(c, requestData.Body, accessToken)
+	betas := resolveBetas(c, account, claudeDefaultBetas, claudeOAuthBetas)
+	req, err := createClaudeRequest(c, requestData.Body, accessToken, betas)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, appendErrorMessage(errCreateRequest, err.Error()))
-		return
+		return failedResult(http.StatusInternalServerError, err.Error())
 	}
 
-	resp, err := client.Do(req)
+	resp, err := doWithBetaRetry(client, req, betas, claudeOAuthBetas, func(betas []string) (*http.Request, error) {
+		return createClaudeRequest(c, requestData.Body, accessToken, betas)
+	})
 	if err != nil {
+		if !errors.Is(err, context.Canceled) && CanFailover(c) {
+			return retryableResult(0, "请求失败: "+err.Error())
+		}
 		handleRequestError(c, err)
-		return
+		return failedResult(0, err.Error())
 	}
 	defer common.CloseIO(resp.Body)
 
 	responseReader, err := createResponseReader(resp)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, appendErrorMessage(errDecompression, err.Error()))
-		return
+		return failedResult(http.StatusInternalServerError, err.Error())
 	}
 
 	var usageTokens *common.TokenUsage
+	result := &RelayResult{StatusCode: resp.StatusCode}
 	if resp.StatusCode < statusBadRequest {
 		usageTokens = handleSuccessResponse(c, resp, responseReader)
 	} else {
-		handleErrorResponse(c, resp, responseReader, account)
+		result = handleErrorResponse(c, resp, responseReader, account)
 	}
 
-	updateAccountAndStats(account, resp.StatusCode, usageTokens)
+	if shouldUpdateAccountStats(c, resp.StatusCode) {
+		updateAccountAndStats(account, resp.StatusCode, usageTokens)
+	}
 
-	if apiKey != nil {
+	if apiKey != nil && claimBilling(c, resp.StatusCode) {
 		go service.UpdateApiKeyStatus(apiKey, resp.StatusCode, usageTokens)
+		saveRequestLog(startTime, apiKey, account, resp.StatusCode, usageTokens, true, extractClientInfo(c))
 	}
+	return result
+}
 
-	saveRequestLog(startTime, apiKey, account, resp.StatusCode, usageTokens, true)
+// extractClientInfo 从上下文中获取鉴权时识别出的客户端
+func extractClientInfo(c *gin.Context) common.ClientInfo {
+	if clientInfo, exists := c.Get("client_info"); exists {
+		return clientInfo.(common.ClientInfo)
+	}
+	return common.ClientInfo{}
 }
 
 // requestData 封装请求数据
@@ -159,6 +177,7 @@ func parseAndValidateRequest(c *gin.Context) (*requestData, error) {
 
 	body, _ = sjson.SetBytes(body, "stream", true)
 	body, _ = sjson.SetBytes(body, "metadata.user_id", common.GetInstanceID())
+	body = ensureClaudeCodeSystemPrompt(body)
 
 	modelName := gjson.GetBytes(body, "model").String()
 	if modelName == "" {
@@ -169,17 +188,41 @@ func parseAndValidateRequest(c *gin.Context) (*requestData, error) {
 	return &requestData{Body: body, ModelName: modelName}, nil
 }
 
-// validateModelRestriction 验证模型限制
-func validateModelRestriction(c *gin.Context, apiKey *model.ApiKey, modelName string) error {
-	if apiKey.ModelRestriction == "" {
-		return nil
+// ensureClaudeCodeSystemPrompt Claude官方账号的OAuth令牌要求system的第一段为 Claude Code 系统提示词，缺少时补上
+// 其他客户端（如 OpenAI 兼容接口转换来的请求）原有的system内容保留在其后
+func ensureClaudeCodeSystemPrompt(body []byte) []byte {
+	promptBlock, _ := json.Marshal(map[string]string{"type": "text", "text": constant.ClaudeCodeSystemPrompt})
+	system := gjson.GetBytes(body, "system")
+
+	blocks := [][]byte{promptBlock}
+	switch {
+	case system.IsArray():
+		existing := system.Array()
+		if len(existing) > 0 && existing[0].Get("text").String() == constant.ClaudeCodeSystemPrompt {
+			return body
+		}
+		// 原有内容块保持原样（包括 cache_control 等字段）
+		for _, block := range existing {
+			blocks = append(blocks, []byte(block.Raw))
+		}
+	case system.String() != "":
+		textBlock, _ := json.Marshal(map[string]string{"type": "text", "text": system.String()})
+		blocks = append(blocks, textBlock)
 	}
 
-	allowedModels := strings.Split(apiKey.ModelRestriction, ",")
-	for _, allowedModel := range allowedModels {
-		if strings.EqualFold(strings.TrimSpace(allowedModel), modelName) {
-			return nil
-		}
+	raw := append(append([]byte("["), bytes.Join(blocks, []byte(","))...), ']')
+	updated, err := sjson.SetRawBytes(body, "system", raw)
+	if err != nil {
+		return body
+	}
+	return updated
+}
+
+// ValidateModelRestriction 验证API Key的模型限制，不允许时写回403
+// 由控制器在调度前按客户端请求的原始模型校验一次，备用分组映射后的模型不再校验
+func ValidateModelRestriction(c *gin.Context, apiKey *model.ApiKey, modelName string) error {
+	if service.IsModelAllowedForKey(apiKey, modelName) {
+		return nil
 	}
 
 	c.JSON(http.StatusForbidden, errModelNotAllowed)
@@ -219,8 +262,8 @@ func parseHTTPTimeout() time.Duration {
 	return defaultHTTPTimeout
 }
 
-// createClaudeRequest 创建Claude请求
-func createClaudeRequest(c *gin.Context, body []byte, accessToken string) (*http.Request, error) {
+// createClaudeRequest 创建Claude请求，betas 为应用策略后的 anthropic-beta 列表
+func createClaudeRequest(c *gin.Context, body []byte, accessToken string, betas []string) (*http.Request, error) {
 	req, err := http.NewRequestWithContext(
 		c.Request.Context(),
 		c.Request.Method,
@@ -233,6 +276,7 @@ func createClaudeRequest(c *gin.Context, body []byte, accessToken string) (*http
 
 	copyRequestHeaders(c, req)
 	setClaudeAPIHeaders(req, accessToken)
+	setBetaHeader(req, betas)
 	setStreamHeaders(c, req)
 
 	return req, nil
@@ -312,27 +356,37 @@ func handleSuccessResponse(c *gin.Context, resp *http.Response, responseReader i
 }
 
 // handleErrorResponse 处理错误响应
-func handleErrorResponse(c *gin.Context, resp *http.Response, responseReader io.Reader, account *model.Account) {
+func handleErrorResponse(c *gin.Context, resp *http.Response, responseReader io.Reader, account *model.Account) *RelayResult {
+	reason := "上游返回状态码 " + strconv.Itoa(resp.StatusCode)
+
 	responseBody, err := io.ReadAll(responseReader)
 	if err != nil {
 		log.Printf("❌ 读取错误响应失败: %v", err)
+		if isRetryableStatus(resp.StatusCode) && CanFailover(c) {
+			return retryableResult(resp.StatusCode, reason)
+		}
 		c.JSON(http.StatusInternalServerError, appendErrorMessage(errResponseRead, err.Error()))
-		return
+		return failedResult(resp.StatusCode, reason)
 	}
 
 	log.Printf("❌ 错误响应内容: %s", string(responseBody))
 
+	handleRateLimit(resp, responseBody, account)
+
+	if isRetryableStatus(resp.StatusCode) && CanFailover(c) {
+		return retryableResult(resp.StatusCode, reason)
+	}
+
 	c.Status(resp.StatusCode)
 	copyResponseHeaders(c, resp)
 
-	handleRateLimit(resp, responseBody, account)
-
 	c.JSON(http.StatusServiceUnavailable, gin.H{
 		"error": map[string]any{
 			"type":    "response_error",
 			"message": "Request failed with status " + strconv.Itoa(resp.StatusCode),
 		},
 	})
+	return failedResult(resp.StatusCode, reason)
 }
 
 // copyResponseHeaders 复制响应头
@@ -439,12 +493,12 @@ func clearRateLimitIfExpired(account *model.Account) {
 }
 
 // saveRequestLog 保存请求日志
-func saveRequestLog(startTime time.Time, apiKey *model.ApiKey, account *model.Account, statusCode int, usageTokens *common.TokenUsage, isStream bool) {
+func saveRequestLog(startTime time.Time, apiKey *model.ApiKey, account *model.Account, statusCode int, usageTokens *common.TokenUsage, isStream bool, client common.ClientInfo) {
 	if statusCode >= statusOK && statusCode < 300 && usageTokens != nil && apiKey != nil {
 		duration := time.Since(startTime).Milliseconds()
 		logService := service.NewLogService()
 		go func() {
-			_, err := logService.CreateLogFromTokenUsage(usageTokens, apiKey.UserID, apiKey.ID, account.ID, duration, isStream)
+			_, err := logService.CreateLogFromTokenUsage(usageTokens, apiKey.UserID, apiKey.ID, account.ID, account.GroupID, duration, isStream, client)
 			if err != nil {
 				log.Printf("保存日志失败: %v", err)
 			}
@@ -530,7 +584,7 @@ func buildClaudeAPIHeaders(accessToken string) map[string]string {
 		"x-stainless-helper-method":                 "stream",
 		"x-app":                                     "cli",
 		"User-Agent":                                "claude-cli/1.0.44 (external, cli)",
-		"anthropic-beta":                            "claude-code-20250219,oauth-2025-04-20,interleaved-thinking-2025-05-14,fine-grained-tool-streaming-2025-05-14",
+		"anthropic-beta":                            strings.Join(claudeDefaultBetas, ","),
 		"X-Stainless-Runtime-Version":               "v20.18.1",
 		"anthropic-dangerous-direct-browser-access": "true",
 	}
@@ -45,32 +45,41 @@ var (
 )
 
 // HandleClaudeConsoleRequest 处理Claude Console平台的请求
-func HandleClaudeConsoleRequest(c *gin.Context, account *model.Account) {
+func HandleClaudeConsoleRequest(c *gin.Context, account *model.Account) *RelayResult {
 	startTime := time.Now()
 
 	apiKey := extractConsoleAPIKey(c)
 
 	body, err := parseConsoleRequest(c)
 	if err != nil {
-		return
+		return failedResult(http.StatusBadRequest, err.Error())
 	}
 
 	client := createConsoleHTTPClient(account)
 	if client == nil {
+		if CanFailover(c) {
+			return retryableResult(0, "代理配置错误")
+		}
 		c.JSON(http.StatusInternalServerError, consoleErrProxyConfig)
-		return
+		return failedResult(http.StatusInternalServerError, "invalid proxy URI")
 	}
 
-	req, err := createConsoleRequest(c, body, account)
+	betas := resolveBetas(c, account, claudeDefaultBetas, nil)
+	req, err := createConsoleRequest(c, body, account, betas)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, appendConsoleErrorMessage(consoleErrCreateRequest, err.Error()))
-		return
+		return failedResult(http.StatusInternalServerError, err.Error())
 	}
 
-	resp, err := client.Do(req)
+	resp, err := doWithBetaRetry(client, req, betas, nil, func(betas []string) (*http.Request, error) {
+		return createConsoleRequest(c, body, account, betas)
+	})
 	if err != nil {
+		if !errors.Is(err, context.Canceled) && CanFailover(c) {
+			return retryableResult(0, "请求失败: "+err.Error())
+		}
 		handleConsoleRequestError(c, err)
-		return
+		return failedResult(0, err.Error())
 	}
 	defer common.CloseIO(resp.Body)
 
@@ -78,30 +87,36 @@ func HandleClaudeConsoleRequest(c *gin.Context, account *model.Account) {
 
 	if resp.StatusCode >= consoleStatusBadRequest {
 		accountService.UpdateAccountStatus(account, resp.StatusCode, nil)
+		reason := "上游返回状态码 " + strconv.Itoa(resp.StatusCode)
+		if isRetryableStatus(resp.StatusCode) && CanFailover(c) {
+			return retryableResult(resp.StatusCode, reason)
+		}
 		c.JSON(http.StatusServiceUnavailable, gin.H{
 			"error": map[string]any{
 				"type":    "response_error",
 				"message": "Request failed with status " + strconv.Itoa(resp.StatusCode),
 			},
 		})
-		return
+		return failedResult(resp.StatusCode, reason)
 	}
 
 	responseReader, err := createConsoleResponseReader(resp)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, appendConsoleErrorMessage(consoleErrDecompression, err.Error()))
-		return
+		return failedResult(http.StatusInternalServerError, err.Error())
 	}
 
 	usageTokens := handleConsoleSuccessResponse(c, resp, responseReader)
 
-	go accountService.UpdateAccountStatus(account, resp.StatusCode, usageTokens)
+	if shouldUpdateAccountStats(c, resp.StatusCode) {
+		go accountService.UpdateAccountStatus(account, resp.StatusCode, usageTokens)
+	}
 
-	if apiKey != nil {
+	if apiKey != nil && claimBilling(c, resp.StatusCode) {
 		go service.UpdateApiKeyStatus(apiKey, resp.StatusCode, usageTokens)
+		saveConsoleRequestLog(startTime, apiKey, account, resp.StatusCode, usageTokens, extractClientInfo(c))
 	}
-
-	saveConsoleRequestLog(startTime, apiKey, account, resp.StatusCode, usageTokens)
+	return &RelayResult{StatusCode: resp.StatusCode}
 }
 
 // extractConsoleAPIKey 从上下文中提取API Key
@@ -158,8 +173,8 @@ func parseConsoleHTTPTimeout() time.Duration {
 	return consoleDefaultTimeout
 }
 
-// createConsoleRequest 创建Console请求
-func createConsoleRequest(c *gin.Context, body []byte, account *model.Account) (*http.Request, error) {
+// createConsoleRequest 创建Console请求，betas 为应用策略后的 anthropic-beta 列表
+func createConsoleRequest(c *gin.Context, body []byte, account *model.Account, betas []string) (*http.Request, error) {
 	requestURL := account.RequestURL + "/v1/messages"
 
 	req, err := http.NewRequestWithContext(
@@ -174,6 +189,7 @@ func createConsoleRequest(c *gin.Context, body []byte, account *model.Account) (
 
 	copyConsoleRequestHeaders(c, req)
 	setConsoleAPIHeaders(req, account.SecretKey)
+	setBetaHeader(req, betas)
 	setConsoleStreamHeaders(c, req)
 
 	return req, nil
@@ -212,7 +228,7 @@ func buildConsoleAPIHeaders(secretKey string) map[string]string {
 		"x-stainless-helper-method":                 "stream",
 		"x-app":                                     "cli",
 		"User-Agent":                                "claude-cli/1.0.44 (external, cli)",
-		"anthropic-beta":                            "claude-code-20250219,oauth-2025-04-20,interleaved-thinking-2025-05-14,fine-grained-tool-streaming-2025-05-14",
+		"anthropic-beta":                            strings.Join(claudeDefaultBetas, ","),
 		"X-Stainless-Runtime-Version":               "v20.18.1",
 		"anthropic-dangerous-direct-browser-access": "true",
 	}
@@ -292,12 +308,12 @@ func setConsoleStreamResponseHeaders(c *gin.Context) {
 }
 
 // saveConsoleRequestLog 保存Console请求日志
-func saveConsoleRequestLog(startTime time.Time, apiKey *model.ApiKey, account *model.Account, statusCode int, usageTokens *common.TokenUsage) {
+func saveConsoleRequestLog(startTime time.Time, apiKey *model.ApiKey, account *model.Account, statusCode int, usageTokens *common.TokenUsage, client common.ClientInfo) {
 	if statusCode >= consoleStatusOK && statusCode < 300 && usageTokens != nil && apiKey != nil {
 		duration := time.Since(startTime).Milliseconds()
 		logService := service.NewLogService()
 		go func() {
-			_, err := logService.CreateLogFromTokenUsage(usageTokens, apiKey.UserID, apiKey.ID, account.ID, duration, true)
+			_, err := logService.CreateLogFromTokenUsage(usageTokens, apiKey.UserID, apiKey.ID, account.ID, account.GroupID, duration, true, client)
 			if err != nil {
 				log.Printf("保存日志失败: %v", err)
 			}
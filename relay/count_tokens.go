@@ -0,0 +1,280 @@
+package relay
+
+import (
+	"bytes"
+	"claude-code-relay/common"
+	"claude-code-relay/model"
+	"claude-code-relay/service"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// ClaudeCountTokensURL Claude官方计算token数接口
+	ClaudeCountTokensURL = ClaudeAPIURL + "/count_tokens"
+
+	// 本地估算时每条消息的固定开销和每张图片的估算token数
+	estimateMessageOverhead = 4
+	estimateImageTokens     = 1600
+)
+
+// HandleClaudeCountTokens 将 count_tokens 请求转发到Claude官方接口
+func HandleClaudeCountTokens(c *gin.Context, account *model.Account) *RelayResult {
+	body, ok := readCountTokensRequest(c)
+	if !ok {
+		return failedResult(http.StatusBadRequest, "invalid request body")
+	}
+
+	accessToken, err := GetValidAccessToken(account)
+	if err != nil {
+		log.Printf("获取有效访问token失败: %v", err)
+		if CanFailover(c) {
+			return retryableResult(0, "获取访问token失败: "+err.Error())
+		}
+		c.JSON(http.StatusInternalServerError, appendErrorMessage(errAuthFailed, err.Error()))
+		return failedResult(http.StatusInternalServerError, err.Error())
+	}
+
+	client := createHTTPClient(account)
+	if client == nil {
+		if CanFailover(c) {
+			return retryableResult(0, "代理配置错误")
+		}
+		c.JSON(http.StatusInternalServerError, errProxyConfig)
+		return failedResult(http.StatusInternalServerError, "invalid proxy URI")
+	}
+
+	req, err := newCountTokensRequest(c, ClaudeCountTokensURL, body)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, appendErrorMessage(errCreateRequest, err.Error()))
+		return failedResult(http.StatusInternalServerError, err.Error())
+	}
+	copyRequestHeaders(c, req)
+	setClaudeAPIHeaders(req, accessToken)
+	setBetaHeader(req, resolveBetas(c, account, claudeDefaultBetas, claudeOAuthBetas))
+	setCountTokensHeaders(req)
+
+	return forwardCountTokens(c, client, req, account, func(resp *http.Response, responseBody []byte) {
+		handleRateLimit(resp, responseBody, account)
+	})
+}
+
+// HandleClaudeConsoleCountTokens 将 count_tokens 请求转发到Claude Console账号的上游接口
+func HandleClaudeConsoleCountTokens(c *gin.Context, account *model.Account) *RelayResult {
+	body, ok := readCountTokensRequest(c)
+	if !ok {
+		return failedResult(http.StatusBadRequest, "invalid request body")
+	}
+
+	client := createConsoleHTTPClient(account)
+	if client == nil {
+		if CanFailover(c) {
+			return retryableResult(0, "代理配置错误")
+		}
+		c.JSON(http.StatusInternalServerError, consoleErrProxyConfig)
+		return failedResult(http.StatusInternalServerError, "invalid proxy URI")
+	}
+
+	req, err := newCountTokensRequest(c, account.RequestURL+"/v1/messages/count_tokens", body)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, appendConsoleErrorMessage(consoleErrCreateRequest, err.Error()))
+		return failedResult(http.StatusInternalServerError, err.Error())
+	}
+	copyConsoleRequestHeaders(c, req)
+	setConsoleAPIHeaders(req, account.SecretKey)
+	setBetaHeader(req, resolveBetas(c, account, claudeDefaultBetas, nil))
+	setCountTokensHeaders(req)
+
+	return forwardCountTokens(c, client, req, account, func(resp *http.Response, responseBody []byte) {
+		service.NewAccountService().UpdateAccountStatus(account, resp.StatusCode, nil)
+	})
+}
+
+// HandleEstimatedCountTokens OpenAI兼容、Gemini等没有 count_tokens 接口的账号，在本地按字符数估算输入token数
+func HandleEstimatedCountTokens(c *gin.Context, account *model.Account) *RelayResult {
+	body, ok := readCountTokensRequest(c)
+	if !ok {
+		return failedResult(http.StatusBadRequest, "invalid request body")
+	}
+
+	c.JSON(http.StatusOK, gin.H{"input_tokens": EstimateInputTokens(body)})
+	recordCountTokensStats(c, account)
+	return &RelayResult{StatusCode: http.StatusOK}
+}
+
+// recordCountTokensStats count_tokens 不计费也不计入账号和API Key的使用次数（避免影响最少使用调度），
+// 只单独累加count_tokens请求次数
+func recordCountTokensStats(c *gin.Context, account *model.Account) {
+	apiKey := extractAPIKey(c)
+	go service.RecordCountTokens(account, apiKey)
+}
+
+// readCountTokensRequest 读取请求体并校验模型名称，校验失败时已写回错误（模型限制由控制器在调度前校验）
+func readCountTokensRequest(c *gin.Context) ([]byte, bool) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errRequestBody)
+		return nil, false
+	}
+
+	if gjson.GetBytes(body, "model").String() == "" {
+		c.JSON(http.StatusBadRequest, errMissingModel)
+		return nil, false
+	}
+	return body, true
+}
+
+// newCountTokensRequest 创建 count_tokens 上游请求
+func newCountTokensRequest(c *gin.Context, requestURL string, body []byte) (*http.Request, error) {
+	return http.NewRequestWithContext(c.Request.Context(), http.MethodPost, requestURL, bytes.NewReader(body))
+}
+
+// setCountTokensHeaders count_tokens 为普通JSON接口，去掉流式相关的请求头
+func setCountTokensHeaders(req *http.Request) {
+	req.Header.Del("x-stainless-helper-method")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+}
+
+// forwardCountTokens 发送 count_tokens 请求并将上游响应原样返回给客户端
+// onError 在上游返回错误状态码时调用，用于各平台处理限流
+func forwardCountTokens(c *gin.Context, client *http.Client, req *http.Request, account *model.Account, onError func(resp *http.Response, responseBody []byte)) *RelayResult {
+	resp, err := client.Do(req)
+	if err != nil {
+		if !errors.Is(err, context.Canceled) && CanFailover(c) {
+			return retryableResult(0, "请求失败: "+err.Error())
+		}
+		handleRequestError(c, err)
+		return failedResult(0, err.Error())
+	}
+	defer common.CloseIO(resp.Body)
+
+	responseReader, err := createResponseReader(resp)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, appendErrorMessage(errDecompression, err.Error()))
+		return failedResult(http.StatusInternalServerError, err.Error())
+	}
+
+	responseBody, err := io.ReadAll(responseReader)
+	if err != nil {
+		if isRetryableStatus(resp.StatusCode) && CanFailover(c) {
+			return retryableResult(resp.StatusCode, "读取响应失败: "+err.Error())
+		}
+		c.JSON(http.StatusInternalServerError, appendErrorMessage(errResponseRead, err.Error()))
+		return failedResult(http.StatusInternalServerError, err.Error())
+	}
+
+	if resp.StatusCode >= statusBadRequest {
+		log.Printf("❌ count_tokens 错误响应内容: %s", string(responseBody))
+		onError(resp, responseBody)
+		reason := "上游返回状态码 " + strconv.Itoa(resp.StatusCode)
+		if isRetryableStatus(resp.StatusCode) && CanFailover(c) {
+			return retryableResult(resp.StatusCode, reason)
+		}
+		writeCountTokensResponse(c, resp, responseBody)
+		return failedResult(resp.StatusCode, reason)
+	}
+
+	writeCountTokensResponse(c, resp, responseBody)
+	recordCountTokensStats(c, account)
+	return &RelayResult{StatusCode: resp.StatusCode}
+}
+
+// writeCountTokensResponse 写回上游响应，响应体已解压，因此不复制长度和编码头
+func writeCountTokensResponse(c *gin.Context, resp *http.Response, responseBody []byte) {
+	for name, values := range resp.Header {
+		switch strings.ToLower(name) {
+		case "content-length", "content-encoding":
+			continue
+		}
+		for _, value := range values {
+			c.Header(name, value)
+		}
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	c.Data(resp.StatusCode, contentType, responseBody)
+}
+
+// EstimateInputTokens 在本地粗略估算Claude消息请求的输入token数
+// 按ASCII字符每4个约1个token、其他字符每个约1个token计算，图片按固定值计
+func EstimateInputTokens(body []byte) int {
+	var counter tokenEstimator
+
+	counter.addContent(gjson.GetBytes(body, "system"))
+	messages := gjson.GetBytes(body, "messages").Array()
+	for _, message := range messages {
+		counter.overhead += estimateMessageOverhead
+		counter.addContent(message.Get("content"))
+	}
+	for _, tool := range gjson.GetBytes(body, "tools").Array() {
+		counter.addText(tool.Get("name").String())
+		counter.addText(tool.Get("description").String())
+		counter.addText(tool.Get("input_schema").Raw)
+	}
+
+	return counter.total()
+}
+
+// tokenEstimator 累计估算的字符数和固定开销
+type tokenEstimator struct {
+	asciiChars int
+	otherChars int
+	overhead   int
+}
+
+// addContent 累计字符串或内容块数组形式的消息内容
+func (e *tokenEstimator) addContent(content gjson.Result) {
+	if content.Type == gjson.String {
+		e.addText(content.String())
+		return
+	}
+
+	for _, block := range content.Array() {
+		switch block.Get("type").String() {
+		case "text":
+			e.addText(block.Get("text").String())
+		case "thinking":
+			e.addText(block.Get("thinking").String())
+		case "tool_use":
+			e.addText(block.Get("name").String())
+			e.addText(block.Get("input").Raw)
+		case "tool_result":
+			e.addContent(block.Get("content"))
+		case "image", "document":
+			e.overhead += estimateImageTokens
+		}
+	}
+}
+
+// addText 累计一段文本的字符数
+func (e *tokenEstimator) addText(text string) {
+	for _, r := range text {
+		if r < utf8.RuneSelf {
+			e.asciiChars++
+		} else {
+			e.otherChars++
+		}
+	}
+}
+
+// total 估算的token总数，至少为1
+func (e *tokenEstimator) total() int {
+	tokens := int(math.Ceil(float64(e.asciiChars)/4)) + e.otherChars + e.overhead
+	if tokens < 1 {
+		tokens = 1
+	}
+	return tokens
+}
@@ -0,0 +1,61 @@
+package relay
+
+import "testing"
+
+func TestEstimateInputTokens(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{name: "empty body", body: `{}`, want: 1},
+		{name: "invalid json", body: `not json`, want: 1},
+		{
+			name: "string system and message",
+			body: `{"system":"abcd","messages":[{"role":"user","content":"hello world!"}]}`,
+			want: 4 + estimateMessageOverhead,
+		},
+		{
+			name: "system text blocks",
+			body: `{"system":[{"type":"text","text":"abcdefgh"}]}`,
+			want: 2,
+		},
+		{
+			name: "non ascii characters count one each",
+			body: `{"messages":[{"role":"user","content":"你好"}]}`,
+			want: 2 + estimateMessageOverhead,
+		},
+		{
+			name: "image and document blocks",
+			body: `{"messages":[{"role":"user","content":[{"type":"image","source":{}},{"type":"document","source":{}},{"type":"text","text":"abcd"}]}]}`,
+			want: 2*estimateImageTokens + 1 + estimateMessageOverhead,
+		},
+		{
+			name: "tool use and nested tool result",
+			body: `{"messages":[
+				{"role":"assistant","content":[{"type":"tool_use","id":"t1","name":"ab","input":{"x":1}}]},
+				{"role":"user","content":[{"type":"tool_result","tool_use_id":"t1","content":[{"type":"text","text":"abcdefgh"}]}]}
+			]}`,
+			// "ab" + {"x":1} 共9个字符，tool_result 8个字符，两条消息
+			want: 3 + 2 + 2*estimateMessageOverhead,
+		},
+		{
+			name: "thinking block",
+			body: `{"messages":[{"role":"assistant","content":[{"type":"thinking","thinking":"abcdefgh","signature":"ignored"}]}]}`,
+			want: 2 + estimateMessageOverhead,
+		},
+		{
+			name: "tools definitions",
+			body: `{"tools":[{"name":"get","description":"d","input_schema":{}}]}`,
+			want: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EstimateInputTokens([]byte(tt.body)); got != tt.want {
+				t.Errorf("EstimateInputTokens() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package relay
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// ctxKeyFailover 上下文中标记当前尝试失败后是否允许切换账号
+	ctxKeyFailover = "relay_failover"
+
+	// 默认最多尝试的账号数
+	defaultMaxAttempts = 3
+)
+
+// RelayResult 单次中转尝试的结果
+type RelayResult struct {
+	StatusCode  int    // 上游响应状态码，网络错误时为0
+	Retryable   bool   // 是否可以切换到下一个账号重试（此时尚未向客户端写入任何数据）
+	Reason      string // 失败原因，成功时为空
+	ClientError bool   // 客户端请求本身不被该账号支持（如无法提供的 beta），可以切换账号，但不计入账号故障
+}
+
+// Success 判断本次尝试是否成功
+func (r *RelayResult) Success() bool {
+	return r != nil && r.StatusCode >= http.StatusOK && r.StatusCode < http.StatusMultipleChoices && r.Reason == ""
+}
+
+// SetFailover 设置当前尝试失败时是否交由调用方切换账号重试
+// 开启后，可重试的失败不会写回客户端，而是通过 RelayResult.Retryable 返回
+func SetFailover(c *gin.Context, enabled bool) {
+	c.Set(ctxKeyFailover, enabled)
+}
+
+// CanFailover 判断当前失败是否可以交由调用方切换账号，只有在尚未写入任何响应时才允许
+func CanFailover(c *gin.Context) bool {
+	return c.GetBool(ctxKeyFailover) && !c.Writer.Written()
+}
+
+// isRetryableStatus 判断上游状态码是否值得切换账号重试（限流、5xx、529过载）
+func isRetryableStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError
+}
+
+// retryableResult 构造可重试的失败结果
+func retryableResult(statusCode int, reason string) *RelayResult {
+	return &RelayResult{StatusCode: statusCode, Retryable: true, Reason: reason}
+}
+
+// clientErrorResult 构造由客户端请求导致、可以切换到其他账号重试的失败结果
+func clientErrorResult(statusCode int, reason string) *RelayResult {
+	return &RelayResult{StatusCode: statusCode, Retryable: true, Reason: reason, ClientError: true}
+}
+
+// failedResult 构造不可重试的失败结果（错误已写回客户端）
+func failedResult(statusCode int, reason string) *RelayResult {
+	return &RelayResult{StatusCode: statusCode, Reason: reason}
+}
+
+// GetMaxAttempts 获取单个请求最多尝试的账号数（环境变量 RELAY_MAX_ATTEMPTS，默认3）
+func GetMaxAttempts() int {
+	if val := os.Getenv("RELAY_MAX_ATTEMPTS"); val != "" {
+		if num, err := strconv.Atoi(val); err == nil && num > 0 {
+			return num
+		}
+	}
+	return defaultMaxAttempts
+}
@@ -0,0 +1,668 @@
+package relay
+
+import (
+	"bufio"
+	"bytes"
+	"claude-code-relay/common"
+	"claude-code-relay/model"
+	"claude-code-relay/service"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// GeminiDefaultBaseURL Gemini API默认地址，账号配置了请求地址时使用账号的地址（便于接入代理或本地桩服务）
+	GeminiDefaultBaseURL = "https://generativelanguage.googleapis.com/v1beta"
+	// 默认模型，会被模型映射覆盖
+	geminiDefaultModel = "gemini-2.5-flash"
+)
+
+// Gemini API 类型定义
+type GeminiPart struct {
+	Text             string                  `json:"text,omitempty"`
+	Thought          bool                    `json:"thought,omitempty"`
+	InlineData       *GeminiInlineData       `json:"inlineData,omitempty"`
+	FunctionCall     *GeminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *GeminiFunctionResponse `json:"functionResponse,omitempty"`
+}
+
+type GeminiInlineData struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+type GeminiFunctionCall struct {
+	Name string         `json:"name"`
+	Args map[string]any `json:"args"`
+}
+
+type GeminiFunctionResponse struct {
+	Name     string         `json:"name"`
+	Response map[string]any `json:"response"`
+}
+
+type GeminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []GeminiPart `json:"parts"`
+}
+
+type GeminiFunctionDeclaration struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Parameters  any    `json:"parameters,omitempty"`
+}
+
+type GeminiTool struct {
+	FunctionDeclarations []GeminiFunctionDeclaration `json:"functionDeclarations"`
+}
+
+type GeminiFunctionCallingConfig struct {
+	Mode                 string   `json:"mode"`
+	AllowedFunctionNames []string `json:"allowedFunctionNames,omitempty"`
+}
+
+type GeminiToolConfig struct {
+	FunctionCallingConfig GeminiFunctionCallingConfig `json:"functionCallingConfig"`
+}
+
+type GeminiGenerationConfig struct {
+	MaxOutputTokens int      `json:"maxOutputTokens,omitempty"`
+	Temperature     *float64 `json:"temperature,omitempty"`
+	TopP            *float64 `json:"topP,omitempty"`
+	TopK            *int     `json:"topK,omitempty"`
+	StopSequences   []string `json:"stopSequences,omitempty"`
+}
+
+type GeminiRequest struct {
+	Contents          []GeminiContent         `json:"contents"`
+	SystemInstruction *GeminiContent          `json:"systemInstruction,omitempty"`
+	Tools             []GeminiTool            `json:"tools,omitempty"`
+	ToolConfig        *GeminiToolConfig       `json:"toolConfig,omitempty"`
+	GenerationConfig  *GeminiGenerationConfig `json:"generationConfig,omitempty"`
+}
+
+// HandleGeminiRequest 处理 Gemini 平台的请求：Claude Messages 请求转换为 streamGenerateContent，响应再转换回Claude格式
+func HandleGeminiRequest(c *gin.Context, account *model.Account) *RelayResult {
+	startTime := time.Now()
+
+	var apiKey *model.ApiKey
+	if keyInfo, exists := c.Get("api_key"); exists {
+		apiKey = keyInfo.(*model.ApiKey)
+	}
+
+	if result := rejectUnsupportedBetas(c, account); result != nil {
+		return result
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": map[string]any{
+				"type":    "request_body_error",
+				"message": "Failed to read request body: " + err.Error(),
+			},
+		})
+		return failedResult(http.StatusBadRequest, err.Error())
+	}
+
+	var claudeReq ClaudeRequest
+	if err := json.Unmarshal(body, &claudeReq); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": map[string]any{
+				"type":    "json_parse_error",
+				"message": "Failed to parse request JSON: " + err.Error(),
+			},
+		})
+		return failedResult(http.StatusBadRequest, err.Error())
+	}
+
+	geminiModel := applyModelMapping(claudeReq.Model, account.ModelMapping, geminiDefaultModel)
+	geminiBody, err := json.Marshal(convertClaudeToGemini(claudeReq))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": map[string]any{
+				"type":    "json_marshal_error",
+				"message": "Failed to marshal Gemini request: " + err.Error(),
+			},
+		})
+		return failedResult(http.StatusInternalServerError, err.Error())
+	}
+
+	req, err := createGeminiRequest(c.Request.Context(), account, geminiModel, true, geminiBody)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": map[string]any{
+				"type":    "internal_server_error",
+				"message": "Failed to create request: " + err.Error(),
+			},
+		})
+		return failedResult(http.StatusInternalServerError, err.Error())
+	}
+
+	client, err := createGeminiHTTPClient(account, parseHTTPTimeout())
+	if err != nil {
+		if CanFailover(c) {
+			return retryableResult(0, "代理配置错误")
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": map[string]any{
+				"type":    "proxy_configuration_error",
+				"message": "Invalid proxy URI: " + err.Error(),
+			},
+		})
+		return failedResult(http.StatusInternalServerError, err.Error())
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Printf("Gemini API request failed: %v", err)
+		if !errors.Is(err, context.Canceled) && CanFailover(c) {
+			return retryableResult(0, "请求失败: "+err.Error())
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": map[string]any{
+				"type":    "network_error",
+				"message": "Failed to execute request: " + err.Error(),
+			},
+		})
+		return failedResult(0, err.Error())
+	}
+	defer common.CloseIO(resp.Body)
+
+	accountService := service.NewAccountService()
+	if resp.StatusCode >= 400 {
+		accountService.UpdateAccountStatus(account, resp.StatusCode, nil)
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		log.Printf("❌ Gemini 错误响应内容: %s", string(bodyBytes))
+		reason := "上游返回状态码 " + strconv.Itoa(resp.StatusCode)
+		if isRetryableStatus(resp.StatusCode) && CanFailover(c) {
+			return retryableResult(resp.StatusCode, reason)
+		}
+		c.Data(resp.StatusCode, "application/json", bodyBytes)
+		return failedResult(resp.StatusCode, reason)
+	}
+
+	// 传递原始Claude模型名称，用于日志记录
+	transformer := newGeminiStreamTransformer(claudeReq.Model, claudeReq.Stream)
+	usageTokens := transformer.process(c, resp.Body)
+
+	if shouldUpdateAccountStats(c, resp.StatusCode) {
+		go accountService.UpdateAccountStatus(account, resp.StatusCode, usageTokens)
+	}
+
+	if apiKey != nil && claimBilling(c, resp.StatusCode) {
+		go service.UpdateApiKeyStatus(apiKey, resp.StatusCode, usageTokens)
+		saveRequestLog(startTime, apiKey, account, resp.StatusCode, usageTokens, claudeReq.Stream, extractClientInfo(c))
+	}
+	return &RelayResult{StatusCode: resp.StatusCode}
+}
+
+// createGeminiRequest 创建Gemini API请求，stream 为true时调用 streamGenerateContent 并使用SSE格式
+func createGeminiRequest(ctx context.Context, account *model.Account, geminiModel string, stream bool, body []byte) (*http.Request, error) {
+	baseURL := strings.TrimRight(account.RequestURL, "/")
+	if baseURL == "" {
+		baseURL = GeminiDefaultBaseURL
+	}
+
+	requestURL := baseURL + "/models/" + url.PathEscape(geminiModel) + ":generateContent"
+	if stream {
+		requestURL = baseURL + "/models/" + url.PathEscape(geminiModel) + ":streamGenerateContent?alt=sse"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, requestURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-goog-api-key", account.SecretKey)
+	return req, nil
+}
+
+// createGeminiHTTPClient 创建Gemini HTTP客户端
+func createGeminiHTTPClient(account *model.Account, timeout time.Duration) (*http.Client, error) {
+	transport := &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+
+	if account.ProxyURI != "" {
+		proxyURL, err := url.Parse(account.ProxyURI)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+	}, nil
+}
+
+// convertClaudeToGemini 将Claude请求转换为Gemini格式
+// 支持系统提示词、文本、内联图片、工具声明、工具调用及工具结果
+func convertClaudeToGemini(claudeReq ClaudeRequest) GeminiRequest {
+	geminiReq := GeminiRequest{
+		GenerationConfig: &GeminiGenerationConfig{
+			MaxOutputTokens: claudeReq.MaxTokens,
+			Temperature:     claudeReq.Temperature,
+			TopP:            claudeReq.TopP,
+			TopK:            claudeReq.TopK,
+			StopSequences:   claudeReq.StopSequences,
+		},
+	}
+
+	if systemMessage := extractSystemMessage(claudeReq.System); systemMessage != "" {
+		geminiReq.SystemInstruction = &GeminiContent{Parts: []GeminiPart{{Text: systemMessage}}}
+	}
+
+	// Gemini的函数响应需要函数名，按工具调用ID记录
+	toolNames := make(map[string]string)
+	for _, message := range claudeReq.Messages {
+		role := "user"
+		if message.Role == "assistant" {
+			role = "model"
+		}
+
+		parts := convertClaudeContentToGemini(message.Content, toolNames)
+		if len(parts) == 0 {
+			continue
+		}
+
+		// 相同角色的连续消息合并为一条
+		if last := len(geminiReq.Contents) - 1; last >= 0 && geminiReq.Contents[last].Role == role {
+			geminiReq.Contents[last].Parts = append(geminiReq.Contents[last].Parts, parts...)
+			continue
+		}
+		geminiReq.Contents = append(geminiReq.Contents, GeminiContent{Role: role, Parts: parts})
+	}
+
+	if len(claudeReq.Tools) > 0 {
+		declarations := make([]GeminiFunctionDeclaration, 0, len(claudeReq.Tools))
+		for _, tool := range claudeReq.Tools {
+			declarations = append(declarations, GeminiFunctionDeclaration{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  recursivelyCleanSchema(tool.InputSchema),
+			})
+		}
+		geminiReq.Tools = []GeminiTool{{FunctionDeclarations: declarations}}
+	}
+
+	if claudeReq.ToolChoice != nil {
+		config := GeminiFunctionCallingConfig{Mode: "AUTO"}
+		switch claudeReq.ToolChoice.Type {
+		case "any":
+			config.Mode = "ANY"
+		case "none":
+			config.Mode = "NONE"
+		case "tool":
+			config.Mode = "ANY"
+			config.AllowedFunctionNames = []string{claudeReq.ToolChoice.Name}
+		}
+		geminiReq.ToolConfig = &GeminiToolConfig{FunctionCallingConfig: config}
+	}
+
+	return geminiReq
+}
+
+// convertClaudeContentToGemini 将Claude消息内容转换为Gemini的parts
+func convertClaudeContentToGemini(content any, toolNames map[string]string) []GeminiPart {
+	if text, ok := content.(string); ok {
+		if text == "" {
+			return nil
+		}
+		return []GeminiPart{{Text: text}}
+	}
+
+	blocks, ok := content.([]any)
+	if !ok {
+		return nil
+	}
+
+	var parts []GeminiPart
+	for _, block := range blocks {
+		blockMap, ok := block.(map[string]any)
+		if !ok {
+			continue
+		}
+
+		switch blockMap["type"] {
+		case "text":
+			if text, _ := blockMap["text"].(string); text != "" {
+				parts = append(parts, GeminiPart{Text: text})
+			}
+		case "image":
+			source, _ := blockMap["source"].(map[string]any)
+			if source == nil || source["type"] != "base64" {
+				continue
+			}
+			mediaType, _ := source["media_type"].(string)
+			data, _ := source["data"].(string)
+			parts = append(parts, GeminiPart{InlineData: &GeminiInlineData{MimeType: mediaType, Data: data}})
+		case "tool_use":
+			id, _ := blockMap["id"].(string)
+			name, _ := blockMap["name"].(string)
+			args, _ := blockMap["input"].(map[string]any)
+			if args == nil {
+				args = map[string]any{}
+			}
+			toolNames[id] = name
+			parts = append(parts, GeminiPart{FunctionCall: &GeminiFunctionCall{Name: name, Args: args}})
+		case "tool_result":
+			toolUseID, _ := blockMap["tool_use_id"].(string)
+			parts = append(parts, GeminiPart{FunctionResponse: &GeminiFunctionResponse{
+				Name:     toolNames[toolUseID],
+				Response: map[string]any{"content": toolResultText(blockMap["content"])},
+			}})
+		}
+	}
+	return parts
+}
+
+// toolResultText 提取工具结果中的文本内容
+func toolResultText(content any) string {
+	switch value := content.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case []any:
+		var texts []string
+		for _, item := range value {
+			if itemMap, ok := item.(map[string]any); ok && itemMap["type"] == "text" {
+				if text, ok := itemMap["text"].(string); ok {
+					texts = append(texts, text)
+				}
+			}
+		}
+		return strings.Join(texts, "\n")
+	default:
+		contentBytes, _ := json.Marshal(value)
+		return string(contentBytes)
+	}
+}
+
+// GeminiStreamTransformer 将Gemini流式响应转换为Claude格式
+// 客户端为流式请求时实时发送Claude SSE事件，否则累积后输出完整的Claude响应
+type GeminiStreamTransformer struct {
+	messageID      string
+	model          string
+	isClientStream bool
+	started        bool
+	blockIndex     int
+	textBlockOpen  bool
+	content        []ClaudeContentBlock
+	stopReason     string
+	usage          *common.TokenUsage
+}
+
+// newGeminiStreamTransformer 创建Gemini流式转换器
+func newGeminiStreamTransformer(model string, isClientStream bool) *GeminiStreamTransformer {
+	return &GeminiStreamTransformer{
+		messageID:      fmt.Sprintf("msg_%s", generateRandomID()),
+		model:          model,
+		isClientStream: isClientStream,
+	}
+}
+
+// process 读取Gemini SSE响应并写回客户端，返回token使用统计
+func (t *GeminiStreamTransformer) process(c *gin.Context, reader io.Reader) *common.TokenUsage {
+	if t.isClientStream {
+		c.Status(http.StatusOK)
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+	}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		chunk := gjson.Parse(strings.TrimSpace(strings.TrimPrefix(line, "data:")))
+		t.processChunk(c.Writer, chunk)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("读取Gemini流式响应失败: %v", err)
+	}
+
+	t.finish(c.Writer)
+	return t.usage
+}
+
+// processChunk 处理单个Gemini响应块
+func (t *GeminiStreamTransformer) processChunk(writer gin.ResponseWriter, chunk gjson.Result) {
+	t.start(writer)
+
+	if usage := chunk.Get("usageMetadata"); usage.Exists() {
+		cached := int(usage.Get("cachedContentTokenCount").Int())
+		t.usage = &common.TokenUsage{
+			InputTokens:          int(usage.Get("promptTokenCount").Int()) - cached,
+			OutputTokens:         int(usage.Get("candidatesTokenCount").Int() + usage.Get("thoughtsTokenCount").Int()),
+			CacheReadInputTokens: cached,
+			Model:                t.model,
+		}
+	}
+
+	candidate := chunk.Get("candidates.0")
+	for _, part := range candidate.Get("content.parts").Array() {
+		if functionCall := part.Get("functionCall"); functionCall.Exists() {
+			t.addToolUse(writer, functionCall)
+			continue
+		}
+		if text := part.Get("text").String(); text != "" && !part.Get("thought").Bool() {
+			t.addText(writer, text)
+		}
+	}
+
+	switch candidate.Get("finishReason").String() {
+	case "":
+	case "MAX_TOKENS":
+		t.stopReason = "max_tokens"
+	default:
+		if t.stopReason == "" {
+			t.stopReason = "end_turn"
+		}
+	}
+}
+
+// start 发送 message_start 事件
+func (t *GeminiStreamTransformer) start(writer gin.ResponseWriter) {
+	if t.started {
+		return
+	}
+	t.started = true
+	t.sendEvent(writer, "message_start", map[string]any{
+		"type": "message_start",
+		"message": map[string]any{
+			"id":          t.messageID,
+			"type":        "message",
+			"role":        "assistant",
+			"model":       t.model,
+			"content":     []any{},
+			"stop_reason": nil,
+			"usage": map[string]int{
+				"input_tokens":  0,
+				"output_tokens": 0,
+			},
+		},
+	})
+}
+
+// addText 追加文本内容，必要时开启新的文本块
+func (t *GeminiStreamTransformer) addText(writer gin.ResponseWriter, text string) {
+	if !t.textBlockOpen {
+		t.textBlockOpen = true
+		t.content = append(t.content, ClaudeContentBlock{Type: "text"})
+		t.sendEvent(writer, "content_block_start", map[string]any{
+			"type":          "content_block_start",
+			"index":         t.blockIndex,
+			"content_block": map[string]any{"type": "text", "text": ""},
+		})
+	}
+
+	t.content[len(t.content)-1].Text += text
+	t.sendEvent(writer, "content_block_delta", map[string]any{
+		"type":  "content_block_delta",
+		"index": t.blockIndex,
+		"delta": map[string]any{"type": "text_delta", "text": text},
+	})
+}
+
+// addToolUse 将Gemini的函数调用转换为完整的 tool_use 内容块（Gemini一次性返回完整参数）
+func (t *GeminiStreamTransformer) addToolUse(writer gin.ResponseWriter, functionCall gjson.Result) {
+	t.closeTextBlock(writer)
+
+	args, _ := functionCall.Get("args").Value().(map[string]any)
+	if args == nil {
+		args = map[string]any{}
+	}
+	block := ClaudeContentBlock{
+		Type:  "tool_use",
+		ID:    "toolu_" + generateRandomID(),
+		Name:  functionCall.Get("name").String(),
+		Input: args,
+	}
+	t.content = append(t.content, block)
+	t.stopReason = "tool_use"
+
+	argsJSON, _ := json.Marshal(args)
+	t.sendEvent(writer, "content_block_start", map[string]any{
+		"type":  "content_block_start",
+		"index": t.blockIndex,
+		"content_block": map[string]any{
+			"type":  "tool_use",
+			"id":    block.ID,
+			"name":  block.Name,
+			"input": map[string]any{},
+		},
+	})
+	t.sendEvent(writer, "content_block_delta", map[string]any{
+		"type":  "content_block_delta",
+		"index": t.blockIndex,
+		"delta": map[string]any{"type": "input_json_delta", "partial_json": string(argsJSON)},
+	})
+	t.sendEvent(writer, "content_block_stop", map[string]any{
+		"type":  "content_block_stop",
+		"index": t.blockIndex,
+	})
+	t.blockIndex++
+}
+
+// closeTextBlock 结束当前文本块
+func (t *GeminiStreamTransformer) closeTextBlock(writer gin.ResponseWriter) {
+	if !t.textBlockOpen {
+		return
+	}
+	t.textBlockOpen = false
+	t.sendEvent(writer, "content_block_stop", map[string]any{
+		"type":  "content_block_stop",
+		"index": t.blockIndex,
+	})
+	t.blockIndex++
+}
+
+// finish 发送结束事件，非流式请求输出完整的Claude响应
+func (t *GeminiStreamTransformer) finish(writer gin.ResponseWriter) {
+	t.start(writer)
+	t.closeTextBlock(writer)
+
+	stopReason := t.stopReason
+	if stopReason == "" {
+		stopReason = "end_turn"
+	}
+	usage := ClaudeUsage{}
+	if t.usage != nil {
+		usage.InputTokens = t.usage.InputTokens + t.usage.CacheReadInputTokens
+		usage.OutputTokens = t.usage.OutputTokens
+	}
+
+	if t.isClientStream {
+		t.sendEvent(writer, "message_delta", map[string]any{
+			"type":  "message_delta",
+			"delta": map[string]any{"stop_reason": stopReason, "stop_sequence": nil},
+			"usage": map[string]int{"output_tokens": usage.OutputTokens},
+		})
+		t.sendEvent(writer, "message_stop", map[string]any{"type": "message_stop"})
+		return
+	}
+
+	content := t.content
+	if content == nil {
+		content = []ClaudeContentBlock{}
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	jsonBytes, _ := json.Marshal(ClaudeResponse{
+		ID:         t.messageID,
+		Type:       "message",
+		Role:       "assistant",
+		Model:      t.model,
+		Content:    content,
+		StopReason: stopReason,
+		Usage:      usage,
+	})
+	_, _ = writer.Write(jsonBytes)
+}
+
+// sendEvent 客户端为流式请求时发送SSE事件
+func (t *GeminiStreamTransformer) sendEvent(writer gin.ResponseWriter, eventType string, data any) {
+	if !t.isClientStream {
+		return
+	}
+	jsonData, _ := json.Marshal(data)
+	fmt.Fprintf(writer, "event: %s\ndata: %s\n\n", eventType, jsonData)
+	writer.Flush()
+}
+
+// TestHandleGeminiRequest 测试Gemini账号是否可用，返回状态码和错误内容
+func TestHandleGeminiRequest(account *model.Account) (int, string) {
+	var claudeReq ClaudeRequest
+	if err := json.Unmarshal([]byte(GetTestRequestBody(100)), &claudeReq); err != nil {
+		return http.StatusBadRequest, "Failed to parse request JSON: " + err.Error()
+	}
+
+	geminiModel := applyModelMapping(claudeReq.Model, account.ModelMapping, geminiDefaultModel)
+	geminiBody, err := json.Marshal(convertClaudeToGemini(claudeReq))
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to marshal Gemini request: " + err.Error()
+	}
+
+	req, err := createGeminiRequest(context.Background(), account, geminiModel, false, geminiBody)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to create request: " + err.Error()
+	}
+
+	client, err := createGeminiHTTPClient(account, 30*time.Second)
+	if err != nil {
+		return http.StatusInternalServerError, "Invalid proxy URI: " + err.Error()
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return http.StatusInternalServerError, "Request failed: " + err.Error()
+	}
+	defer common.CloseIO(resp.Body)
+
+	if resp.StatusCode >= 400 {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(bodyBytes)
+	}
+
+	return resp.StatusCode, ""
+}
@@ -0,0 +1,272 @@
+package relay
+
+import (
+	"claude-code-relay/model"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// capturedRequest 桩服务收到的请求
+type capturedRequest struct {
+	method      string
+	path        string
+	escapedPath string
+	query       string
+	header      http.Header
+	body        []byte
+}
+
+// newCaptureServer 创建记录请求并返回固定响应的桩服务
+func newCaptureServer(t *testing.T, contentType, response string) (*httptest.Server, *capturedRequest) {
+	t.Helper()
+	captured := &capturedRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		captured.method = r.Method
+		captured.path = r.URL.Path
+		captured.escapedPath = r.URL.EscapedPath()
+		captured.query = r.URL.RawQuery
+		captured.header = r.Header.Clone()
+		captured.body, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", contentType)
+		_, _ = io.WriteString(w, response)
+	}))
+	t.Cleanup(server.Close)
+	return server, captured
+}
+
+// sseEvent 解析出的一个SSE事件
+type sseEvent struct {
+	name string
+	data gjson.Result
+}
+
+// parseSSEEvents 解析Claude格式的SSE响应
+func parseSSEEvents(body string) []sseEvent {
+	var events []sseEvent
+	for _, frame := range strings.Split(body, "\n\n") {
+		var event sseEvent
+		for _, line := range strings.Split(frame, "\n") {
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				event.name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				event.data = gjson.Parse(strings.TrimPrefix(line, "data: "))
+			}
+		}
+		if event.name != "" {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+const geminiToolConversation = `{
+	"model": "claude-sonnet-4-20250514",
+	"max_tokens": 1024,
+	"stream": true,
+	"system": [{"type": "text", "text": "You are a weather bot."}, {"type": "text", "text": "Be brief."}],
+	"tools": [{
+		"name": "get_weather",
+		"description": "Get the weather",
+		"input_schema": {"type": "object", "$schema": "http://json-schema.org/draft-07/schema#", "additionalProperties": false, "properties": {"city": {"type": "string"}}, "required": ["city"]}
+	}],
+	"tool_choice": {"type": "tool", "name": "get_weather"},
+	"messages": [
+		{"role": "user", "content": "Weather in Paris?"},
+		{"role": "assistant", "content": [{"type": "text", "text": "Checking."}, {"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"city": "Paris"}}]},
+		{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "toolu_1", "content": [{"type": "text", "text": "Sunny, 21C"}]}]}
+	]
+}`
+
+func TestGeminiStreamRequestTranslation(t *testing.T) {
+	server, captured := newCaptureServer(t, "text/event-stream", "")
+	account := &model.Account{RequestURL: server.URL, SecretKey: "test-key", ModelMapping: "claude-sonnet:gemini-2.5-pro"}
+
+	var claudeReq ClaudeRequest
+	if err := json.Unmarshal([]byte(geminiToolConversation), &claudeReq); err != nil {
+		t.Fatal(err)
+	}
+	geminiModel := applyModelMapping(claudeReq.Model, account.ModelMapping, geminiDefaultModel)
+	geminiBody, err := json.Marshal(convertClaudeToGemini(claudeReq))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := createGeminiRequest(context.Background(), account, geminiModel, true, geminiBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if captured.method != http.MethodPost || captured.path != "/models/gemini-2.5-pro:streamGenerateContent" || captured.query != "alt=sse" {
+		t.Fatalf("request = %s %s?%s, want POST /models/gemini-2.5-pro:streamGenerateContent?alt=sse", captured.method, captured.path, captured.query)
+	}
+	if got := captured.header.Get("x-goog-api-key"); got != "test-key" {
+		t.Errorf("x-goog-api-key = %q, want test-key", got)
+	}
+
+	body := gjson.ParseBytes(captured.body)
+	checks := map[string]string{
+		"systemInstruction.parts.0.text":                                 "You are a weather bot.\nBe brief.",
+		"generationConfig.maxOutputTokens":                               "1024",
+		"tools.0.functionDeclarations.0.name":                            "get_weather",
+		"tools.0.functionDeclarations.0.parameters.properties.city.type": "string",
+		"toolConfig.functionCallingConfig.mode":                          "ANY",
+		"toolConfig.functionCallingConfig.allowedFunctionNames.0":        "get_weather",
+		"contents.#":                                           "3",
+		"contents.0.role":                                      "user",
+		"contents.0.parts.0.text":                              "Weather in Paris?",
+		"contents.1.role":                                      "model",
+		"contents.1.parts.0.text":                              "Checking.",
+		"contents.1.parts.1.functionCall.name":                 "get_weather",
+		"contents.1.parts.1.functionCall.args.city":            "Paris",
+		"contents.2.role":                                      "user",
+		"contents.2.parts.0.functionResponse.name":             "get_weather",
+		"contents.2.parts.0.functionResponse.response.content": "Sunny, 21C",
+	}
+	for path, want := range checks {
+		if got := body.Get(path).String(); got != want {
+			t.Errorf("%s = %q, want %q", path, got, want)
+		}
+	}
+	// Gemini 不接受的 JSON Schema 字段需要去掉
+	for _, path := range []string{"tools.0.functionDeclarations.0.parameters.$schema", "tools.0.functionDeclarations.0.parameters.additionalProperties", "system", "messages"} {
+		if body.Get(path).Exists() {
+			t.Errorf("%s should not be sent to Gemini", path)
+		}
+	}
+}
+
+func TestGeminiGenerateContentRequest(t *testing.T) {
+	server, captured := newCaptureServer(t, "application/json", `{"candidates":[{"content":{"parts":[{"text":"hi"}]}}]}`)
+	account := &model.Account{RequestURL: server.URL + "/", SecretKey: "test-key"}
+
+	statusCode, message := TestHandleGeminiRequest(account)
+	if statusCode != http.StatusOK {
+		t.Fatalf("TestHandleGeminiRequest = %d %s, want 200", statusCode, message)
+	}
+	if captured.path != "/models/"+geminiDefaultModel+":generateContent" || captured.query != "" {
+		t.Errorf("request = %s?%s, want non-streaming generateContent on the default model", captured.path, captured.query)
+	}
+	if !gjson.GetBytes(captured.body, "contents.0.parts.0.text").Exists() {
+		t.Errorf("request body has no user content: %s", captured.body)
+	}
+}
+
+const geminiTextAndFunctionCallSSE = `data: {"candidates":[{"content":{"role":"model","parts":[{"text":"Let me ","thought":false}]}}]}
+
+data: {"candidates":[{"content":{"role":"model","parts":[{"text":"thinking...","thought":true},{"text":"check."}]}}]}
+
+data: {"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"get_weather","args":{"city":"Paris"}}}]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":120,"cachedContentTokenCount":20,"candidatesTokenCount":15,"thoughtsTokenCount":5}}
+
+`
+
+// runGeminiTransformer 从桩服务读取Gemini流式响应并转换为Claude格式
+func runGeminiTransformer(t *testing.T, isClientStream bool) *httptest.ResponseRecorder {
+	t.Helper()
+	server, _ := newCaptureServer(t, "text/event-stream", geminiTextAndFunctionCallSSE)
+	resp, err := http.Post(server.URL, "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	transformer := newGeminiStreamTransformer("claude-sonnet-4-20250514", isClientStream)
+	usage := transformer.process(c, resp.Body)
+
+	if usage == nil {
+		t.Fatal("usage should be parsed from usageMetadata")
+	}
+	if usage.InputTokens != 100 || usage.CacheReadInputTokens != 20 || usage.OutputTokens != 20 || usage.Model != "claude-sonnet-4-20250514" {
+		t.Errorf("usage = %+v, want input 100, cache read 20, output 20", usage)
+	}
+	return recorder
+}
+
+func TestGeminiStreamToClaudeEvents(t *testing.T) {
+	recorder := runGeminiTransformer(t, true)
+	if got := recorder.Header().Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("Content-Type = %q, want text/event-stream", got)
+	}
+
+	events := parseSSEEvents(recorder.Body.String())
+	want := []string{
+		"message_start",
+		"content_block_start", "content_block_delta", "content_block_delta", "content_block_stop",
+		"content_block_start", "content_block_delta", "content_block_stop",
+		"message_delta", "message_stop",
+	}
+	names := make([]string, len(events))
+	for i, event := range events {
+		names[i] = event.name
+	}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Fatalf("events = %v, want %v", names, want)
+	}
+
+	checks := []struct {
+		event int
+		path  string
+		want  string
+	}{
+		{0, "message.model", "claude-sonnet-4-20250514"},
+		{1, "index", "0"},
+		{1, "content_block.type", "text"},
+		{2, "delta.text", "Let me "},
+		{3, "delta.text", "check."},
+		{4, "index", "0"},
+		{5, "index", "1"},
+		{5, "content_block.type", "tool_use"},
+		{5, "content_block.name", "get_weather"},
+		{6, "delta.type", "input_json_delta"},
+		{6, "delta.partial_json", `{"city":"Paris"}`},
+		{7, "index", "1"},
+		{8, "delta.stop_reason", "tool_use"},
+		{8, "usage.output_tokens", "20"},
+	}
+	for _, check := range checks {
+		if got := events[check.event].data.Get(check.path).String(); got != check.want {
+			t.Errorf("event %d (%s) %s = %q, want %q", check.event, events[check.event].name, check.path, got, check.want)
+		}
+	}
+	if !strings.HasPrefix(events[5].data.Get("content_block.id").String(), "toolu_") {
+		t.Errorf("tool_use id = %q, want toolu_ prefix", events[5].data.Get("content_block.id").String())
+	}
+}
+
+func TestGeminiStreamToClaudeMessage(t *testing.T) {
+	recorder := runGeminiTransformer(t, false)
+
+	body := gjson.Parse(recorder.Body.String())
+	checks := map[string]string{
+		"type":                 "message",
+		"content.#":            "2",
+		"content.0.type":       "text",
+		"content.0.text":       "Let me check.",
+		"content.1.type":       "tool_use",
+		"content.1.name":       "get_weather",
+		"content.1.input.city": "Paris",
+		"stop_reason":          "tool_use",
+		"usage.input_tokens":   "120",
+		"usage.output_tokens":  "20",
+	}
+	for path, want := range checks {
+		if got := body.Get(path).String(); got != want {
+			t.Errorf("%s = %q, want %q", path, got, want)
+		}
+	}
+}
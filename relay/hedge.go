@@ -0,0 +1,38 @@
+package relay
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ctxKeyHedgeClaim 上下文中保存对冲请求的计费认领函数
+const ctxKeyHedgeClaim = "relay_hedge_claim"
+
+// SetHedgeClaim 标记当前尝试属于一组对冲请求，claim 返回true表示本次尝试赢得计费权（需可重复调用）
+func SetHedgeClaim(c *gin.Context, claim func() bool) {
+	c.Set(ctxKeyHedgeClaim, claim)
+}
+
+// claimBilling 判断本次成功的请求是否应当记录日志和计费
+// 普通请求总是计费；对冲请求只有最先完成的尝试计费，另一个尝试会被取消且不计费
+func claimBilling(c *gin.Context, statusCode int) bool {
+	value, exists := c.Get(ctxKeyHedgeClaim)
+	if !exists {
+		return true
+	}
+	if statusCode < http.StatusOK || statusCode >= http.StatusMultipleChoices {
+		return false
+	}
+	claim, ok := value.(func() bool)
+	return !ok || claim()
+}
+
+// shouldUpdateAccountStats 判断是否将本次响应计入账号的使用统计
+// 错误状态始终更新账号状态；对冲请求中落败的成功尝试与计费一样不计入，避免重复统计使用次数和费用
+func shouldUpdateAccountStats(c *gin.Context, statusCode int) bool {
+	if statusCode >= http.StatusBadRequest {
+		return true
+	}
+	return claimBilling(c, statusCode)
+}
@@ -0,0 +1,117 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// AssembleClaudeMessage 将Claude流式响应（SSE）还原为完整的 Message 对象，响应本身是JSON时原样返回
+// Claude账号转发时总是使用流式请求，离线场景（如批处理）需要非流式的结果
+func AssembleClaudeMessage(body []byte) (json.RawMessage, error) {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		return trimmed, nil
+	}
+
+	var message map[string]any
+	var blocks []map[string]any
+	partialJSON := make(map[int]*strings.Builder)
+
+	for _, line := range strings.Split(string(body), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		event := gjson.Parse(strings.TrimSpace(strings.TrimPrefix(line, "data:")))
+		index := int(event.Get("index").Int())
+
+		switch event.Get("type").String() {
+		case "message_start":
+			if err := json.Unmarshal([]byte(event.Get("message").Raw), &message); err != nil {
+				return nil, err
+			}
+		case "content_block_start":
+			var block map[string]any
+			if err := json.Unmarshal([]byte(event.Get("content_block").Raw), &block); err != nil {
+				return nil, err
+			}
+			for len(blocks) <= index {
+				blocks = append(blocks, nil)
+			}
+			blocks[index] = block
+		case "content_block_delta":
+			if index >= len(blocks) || blocks[index] == nil {
+				continue
+			}
+			block := blocks[index]
+			delta := event.Get("delta")
+			switch delta.Get("type").String() {
+			case "text_delta":
+				text, _ := block["text"].(string)
+				block["text"] = text + delta.Get("text").String()
+			case "thinking_delta":
+				thinking, _ := block["thinking"].(string)
+				block["thinking"] = thinking + delta.Get("thinking").String()
+			case "signature_delta":
+				block["signature"] = delta.Get("signature").String()
+			case "input_json_delta":
+				if partialJSON[index] == nil {
+					partialJSON[index] = &strings.Builder{}
+				}
+				partialJSON[index].WriteString(delta.Get("partial_json").String())
+			case "citations_delta":
+				citations, _ := block["citations"].([]any)
+				block["citations"] = append(citations, delta.Get("citation").Value())
+			}
+		case "content_block_stop":
+			if index >= len(blocks) || blocks[index] == nil || partialJSON[index] == nil {
+				continue
+			}
+			var input any = map[string]any{}
+			if partial := partialJSON[index].String(); partial != "" {
+				if err := json.Unmarshal([]byte(partial), &input); err != nil {
+					return nil, err
+				}
+			}
+			blocks[index]["input"] = input
+		case "message_delta":
+			if message == nil {
+				continue
+			}
+			for key, value := range event.Get("delta").Map() {
+				message[key] = value.Value()
+			}
+			usage, _ := message["usage"].(map[string]any)
+			if usage == nil {
+				usage = make(map[string]any)
+			}
+			for key, value := range event.Get("usage").Map() {
+				usage[key] = value.Value()
+			}
+			message["usage"] = usage
+		case "error":
+			errorMessage := event.Get("error.message").String()
+			if errorMessage == "" {
+				errorMessage = "upstream stream error"
+			}
+			return nil, errors.New(errorMessage)
+		}
+	}
+
+	if message == nil {
+		return nil, errors.New("incomplete stream response")
+	}
+
+	content := make([]map[string]any, 0, len(blocks))
+	for _, block := range blocks {
+		if block != nil {
+			content = append(content, block)
+		}
+	}
+	message["content"] = content
+	return json.Marshal(message)
+}
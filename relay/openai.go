@@ -4,10 +4,13 @@ import (
 	"bufio"
 	"bytes"
 	"claude-code-relay/common"
+	"claude-code-relay/constant"
 	"claude-code-relay/model"
 	"claude-code-relay/service"
+	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -15,6 +18,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -31,6 +35,8 @@ type ClaudeTool struct {
 type ClaudeContentBlock struct {
 	Type      string               `json:"type"`
 	Text      string               `json:"text,omitempty"`
+	Thinking  string               `json:"thinking,omitempty"`
+	Signature *string              `json:"signature,omitempty"`
 	Source    *ClaudeContentSource `json:"source,omitempty"`
 	ID        string               `json:"id,omitempty"`
 	Name      string               `json:"name,omitempty"`
@@ -51,8 +57,14 @@ type ClaudeMessage struct {
 }
 
 type ClaudeToolChoice struct {
-	Type string `json:"type"`
-	Name string `json:"name,omitempty"`
+	Type                   string `json:"type"`
+	Name                   string `json:"name,omitempty"`
+	DisableParallelToolUse bool   `json:"disable_parallel_tool_use,omitempty"`
+}
+
+type ClaudeThinking struct {
+	Type         string `json:"type"`
+	BudgetTokens int    `json:"budget_tokens,omitempty"`
 }
 
 type ClaudeRequest struct {
@@ -68,14 +80,16 @@ type ClaudeRequest struct {
 	Tools         []ClaudeTool      `json:"tools,omitempty"`
 	ToolChoice    *ClaudeToolChoice `json:"tool_choice,omitempty"`
 	Metadata      map[string]any    `json:"metadata,omitempty"`
+	Thinking      *ClaudeThinking   `json:"thinking,omitempty"`
 }
 
 // OpenAI API 类型定义
 type OpenAIMessage struct {
-	Role       string           `json:"role"`
-	Content    any              `json:"content"`
-	ToolCalls  []OpenAIToolCall `json:"tool_calls,omitempty"`
-	ToolCallID string           `json:"tool_call_id,omitempty"`
+	Role             string           `json:"role"`
+	Content          any              `json:"content"`
+	ReasoningContent string           `json:"reasoning_content,omitempty"`
+	ToolCalls        []OpenAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID       string           `json:"tool_call_id,omitempty"`
 }
 
 type OpenAIToolCall struct {
@@ -106,15 +120,23 @@ type OpenAIToolChoice struct {
 }
 
 type OpenAIRequest struct {
-	Model       string          `json:"model"`
-	Messages    []OpenAIMessage `json:"messages"`
-	MaxTokens   *int            `json:"max_tokens,omitempty"`
-	Temperature *float64        `json:"temperature,omitempty"`
-	TopP        *float64        `json:"top_p,omitempty"`
-	Stop        []string        `json:"stop,omitempty"`
-	Stream      bool            `json:"stream,omitempty"`
-	Tools       []OpenAITool    `json:"tools,omitempty"`
-	ToolChoice  any             `json:"tool_choice,omitempty"`
+	Model           string          `json:"model"`
+	Messages        []OpenAIMessage `json:"messages"`
+	MaxTokens       *int            `json:"max_tokens,omitempty"`
+	Temperature     *float64        `json:"temperature,omitempty"`
+	TopP            *float64        `json:"top_p,omitempty"`
+	Stop            []string        `json:"stop,omitempty"`
+	Stream          bool            `json:"stream,omitempty"`
+	Tools           []OpenAITool    `json:"tools,omitempty"`
+	ToolChoice      any             `json:"tool_choice,omitempty"`
+	ReasoningEffort string          `json:"reasoning_effort,omitempty"`
+	EnableThinking  *bool           `json:"enable_thinking,omitempty"`
+	Thinking        *OpenAIThinking `json:"thinking,omitempty"`
+}
+
+// OpenAIThinking GLM 的 thinking 参数
+type OpenAIThinking struct {
+	Type string `json:"type"`
 }
 
 // OpenAI 响应类型定义
@@ -151,8 +173,9 @@ type ClaudeResponse struct {
 }
 
 type ClaudeUsage struct {
-	InputTokens  int `json:"input_tokens"`
-	OutputTokens int `json:"output_tokens"`
+	InputTokens          int `json:"input_tokens"`
+	OutputTokens         int `json:"output_tokens"`
+	CacheReadInputTokens int `json:"cache_read_input_tokens,omitempty"`
 }
 
 type OpenAITargetConfig struct {
@@ -161,7 +184,7 @@ type OpenAITargetConfig struct {
 }
 
 // HandleOpenAIRequest 处理 OpenAI 请求的中转
-func HandleOpenAIRequest(c *gin.Context, account *model.Account) {
+func HandleOpenAIRequest(c *gin.Context, account *model.Account) *RelayResult {
 	// 记录请求开始时间用于计算耗时
 	startTime := time.Now()
 
@@ -172,6 +195,11 @@ func HandleOpenAIRequest(c *gin.Context, account *model.Account) {
 	}
 	ctx := c.Request.Context()
 
+	// 检查客户端请求的 beta 是否可以兼容
+	if result := rejectUnsupportedBetas(c, account); result != nil {
+		return result
+	}
+
 	// 读取请求体
 	body, err := io.ReadAll(c.Request.Body)
 	if err != nil {
@@ -181,7 +209,7 @@ func HandleOpenAIRequest(c *gin.Context, account *model.Account) {
 				"message": "Failed to read request body: " + err.Error(),
 			},
 		})
-		return
+		return failedResult(http.StatusBadRequest, err.Error())
 	}
 
 	// 解析Claude请求
@@ -193,18 +221,21 @@ func HandleOpenAIRequest(c *gin.Context, account *model.Account) {
 				"message": "Failed to parse request JSON: " + err.Error(),
 			},
 		})
-		return
+		return failedResult(http.StatusBadRequest, err.Error())
 	}
 
 	// 直接使用账号配置的请求地址和默认模型
 	if account.RequestURL == "" {
+		if CanFailover(c) {
+			return retryableResult(0, "账号未配置请求地址")
+		}
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": map[string]any{
 				"type":    "configuration_error",
 				"message": "账号未配置请求地址",
 			},
 		})
-		return
+		return failedResult(http.StatusBadRequest, "账号未配置请求地址")
 	}
 
 	targetConfig := &OpenAITargetConfig{
@@ -215,11 +246,8 @@ func HandleOpenAIRequest(c *gin.Context, account *model.Account) {
 	// 应用模型映射
 	mappedModelName := applyModelMapping(claudeReq.Model, account.ModelMapping, targetConfig.ModelName)
 
-	// 转换Claude请求为OpenAI格式
-	openaiReq := convertClaudeToOpenAI(claudeReq, mappedModelName)
-
-	// 序列化OpenAI请求
-	openaiBody, err := json.Marshal(openaiReq)
+	// 按账号的上游协议转换并序列化OpenAI请求
+	requestPath, openaiBody, err := buildOpenAIUpstreamRequest(account, claudeReq, mappedModelName)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": map[string]any{
@@ -227,12 +255,11 @@ func HandleOpenAIRequest(c *gin.Context, account *model.Account) {
 				"message": "Failed to marshal OpenAI request: " + err.Error(),
 			},
 		})
-		return
+		return failedResult(http.StatusInternalServerError, err.Error())
 	}
 
 	// 创建OpenAI API请求
-	openaiURL := targetConfig.BaseURL + "/chat/completions"
-	req, err := http.NewRequestWithContext(ctx, "POST", openaiURL, bytes.NewBuffer(openaiBody))
+	req, err := createOpenAIRequest(ctx, account, requestPath, mappedModelName, openaiBody)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": map[string]any{
@@ -240,13 +267,9 @@ func HandleOpenAIRequest(c *gin.Context, account *model.Account) {
 				"message": "Failed to create request: " + err.Error(),
 			},
 		})
-		return
+		return failedResult(http.StatusInternalServerError, err.Error())
 	}
 
-	// 设置请求头
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+account.SecretKey)
-
 	// 创建HTTP客户端
 	httpClientTimeout, _ := time.ParseDuration(os.Getenv("HTTP_CLIENT_TIMEOUT") + "s")
 	if httpClientTimeout == 0 {
@@ -261,13 +284,16 @@ func HandleOpenAIRequest(c *gin.Context, account *model.Account) {
 	if account.ProxyURI != "" {
 		proxyURL, err := url.Parse(account.ProxyURI)
 		if err != nil {
+			if CanFailover(c) {
+				return retryableResult(0, "代理配置错误")
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error": map[string]any{
 					"type":    "proxy_configuration_error",
 					"message": "Invalid proxy URI: " + err.Error(),
 				},
 			})
-			return
+			return failedResult(http.StatusInternalServerError, err.Error())
 		}
 		transport.Proxy = http.ProxyURL(proxyURL)
 	}
@@ -281,13 +307,16 @@ func HandleOpenAIRequest(c *gin.Context, account *model.Account) {
 	resp, err := client.Do(req)
 	if err != nil {
 		log.Printf("OpenAI API request failed: %v", err)
+		if !errors.Is(err, context.Canceled) && CanFailover(c) {
+			return retryableResult(0, "请求失败: "+err.Error())
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": map[string]any{
 				"type":    "network_error",
 				"message": "Failed to execute request: " + err.Error(),
 			},
 		})
-		return
+		return failedResult(0, err.Error())
 	}
 	defer common.CloseIO(resp.Body)
 
@@ -296,12 +325,102 @@ func HandleOpenAIRequest(c *gin.Context, account *model.Account) {
 	if resp.StatusCode >= 400 {
 		accountService.UpdateAccountStatus(account, resp.StatusCode, nil)
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		c.Data(resp.StatusCode, "application/json", bodyBytes)
-		return
+		reason := "上游返回状态码 " + strconv.Itoa(resp.StatusCode)
+		if isRetryableStatus(resp.StatusCode) && CanFailover(c) {
+			return retryableResult(resp.StatusCode, reason)
+		}
+		if account.PlatformType == constant.PlatformAzureOpenAI {
+			c.JSON(resp.StatusCode, azureErrorResponse(resp.StatusCode, bodyBytes))
+		} else {
+			c.Data(resp.StatusCode, "application/json", bodyBytes)
+		}
+		return failedResult(resp.StatusCode, reason)
 	}
 
 	// 统一使用流式响应处理（传递原始Claude模型名称，用于日志记录）
 	handleStreamingResponse(c, resp, claudeReq.Model, claudeReq.Stream, account, apiKey, startTime)
+	return &RelayResult{StatusCode: resp.StatusCode}
+}
+
+// buildOpenAIUpstreamRequest 按账号配置的上游协议将Claude请求转换为OpenAI请求，返回请求路径和请求体
+func buildOpenAIUpstreamRequest(account *model.Account, claudeReq ClaudeRequest, modelName string) (string, []byte, error) {
+	if account.UpstreamProtocol == constant.UpstreamProtocolResponses {
+		responsesReq := convertClaudeToResponses(claudeReq, modelName)
+		applyResponsesReasoning(&responsesReq, account.ThinkingParam, claudeReq.Thinking)
+		responsesBody, err := json.Marshal(responsesReq)
+		return "/responses", responsesBody, err
+	}
+	openaiReq := convertClaudeToOpenAI(claudeReq, modelName)
+	applyThinkingParam(&openaiReq, account.ThinkingParam, claudeReq.Thinking)
+	openaiBody, err := json.Marshal(openaiReq)
+	return "/chat/completions", openaiBody, err
+}
+
+// createOpenAIRequest 创建上游请求，Azure OpenAI 账号按模型映射得到的部署名称构造地址
+func createOpenAIRequest(ctx context.Context, account *model.Account, requestPath, modelName string, body []byte) (*http.Request, error) {
+	requestURL := account.RequestURL + requestPath
+	if account.PlatformType == constant.PlatformAzureOpenAI {
+		requestURL = azureOpenAIURL(account, requestPath, modelName)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", requestURL, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setOpenAIAuthHeader(req, account)
+	return req, nil
+}
+
+// thinkingEffort 将Claude的 thinking 配置换算为上游的推理强度，未开启 thinking 时返回空字符串
+func thinkingEffort(thinking *ClaudeThinking) string {
+	if thinking == nil || thinking.Type != "enabled" {
+		return ""
+	}
+	switch {
+	case thinking.BudgetTokens <= 0:
+		return "medium"
+	case thinking.BudgetTokens <= 4096:
+		return "low"
+	case thinking.BudgetTokens <= 16384:
+		return "medium"
+	default:
+		return "high"
+	}
+}
+
+// applyThinkingParam 按账号配置的推理参数传递 thinking 开关，账号未配置时不传递任何推理参数
+// 不支持推理的模型会拒绝这些参数，各家上游的参数名也不同：enable_thinking 和 thinking.type 会显式传递关闭，
+// reasoning_effort 没有关闭的取值，只在开启 thinking 时传递
+func applyThinkingParam(openaiReq *OpenAIRequest, thinkingParam string, thinking *ClaudeThinking) {
+	enabled := thinking != nil && thinking.Type == "enabled"
+	switch thinkingParam {
+	case constant.ThinkingParamReasoningEffort:
+		openaiReq.ReasoningEffort = thinkingEffort(thinking)
+	case constant.ThinkingParamEnableThinking:
+		openaiReq.EnableThinking = &enabled
+	case constant.ThinkingParamThinkingType:
+		openaiReq.Thinking = &OpenAIThinking{Type: "disabled"}
+		if enabled {
+			openaiReq.Thinking.Type = "enabled"
+		}
+	}
+}
+
+// thinkingBlock 构造 thinking 内容块，上游没有签名，与流式响应一致返回空签名
+func thinkingBlock(thinking string) ClaudeContentBlock {
+	signature := ""
+	return ClaudeContentBlock{Type: "thinking", Thinking: thinking, Signature: &signature}
+}
+
+// reasoningDelta 提取流式增量中的推理内容
+// DeepSeek、Qwen、GLM 等使用 reasoning_content 字段，部分兼容网关使用 reasoning 字段
+func reasoningDelta(delta map[string]any) string {
+	if reasoning, ok := delta["reasoning_content"].(string); ok && reasoning != "" {
+		return reasoning
+	}
+	reasoning, _ := delta["reasoning"].(string)
+	return reasoning
 }
 
 // extractSystemMessage 从system字段中提取系统消息文本
@@ -353,7 +472,8 @@ func applyModelMapping(claudeModel, modelMapping, defaultTargetModel string) str
 			continue
 		}
 
-		parts := strings.Split(mapping, ":")
+		// 目标模型可能包含冒号（如 Bedrock 的 anthropic.claude-3-5-sonnet-20241022-v2:0），只按第一个冒号分割
+		parts := strings.SplitN(mapping, ":", 2)
 		if len(parts) != 2 {
 			continue
 		}
@@ -504,6 +624,7 @@ func convertClaudeToOpenAI(claudeReq ClaudeRequest, modelName string) OpenAIRequ
 		} else if message.Role == "assistant" {
 			// 处理助手消息
 			var textParts []string
+			var thinkingParts []string
 			var toolCalls []OpenAIToolCall
 
 			if contentBlocks, ok := message.Content.([]any); ok {
@@ -511,6 +632,10 @@ func convertClaudeToOpenAI(claudeReq ClaudeRequest, modelName string) OpenAIRequ
 					if blockMap, ok := block.(map[string]any); ok {
 						if blockMap["type"] == "text" {
 							textParts = append(textParts, blockMap["text"].(string))
+						} else if blockMap["type"] == "thinking" {
+							if thinking, ok := blockMap["thinking"].(string); ok && thinking != "" {
+								thinkingParts = append(thinkingParts, thinking)
+							}
 						} else if blockMap["type"] == "tool_use" {
 							arguments := "{}"
 							if blockMap["input"] != nil {
@@ -537,6 +662,9 @@ func convertClaudeToOpenAI(claudeReq ClaudeRequest, modelName string) OpenAIRequ
 			}
 			if len(toolCalls) > 0 {
 				assistantMessage.ToolCalls = toolCalls
+				// 工具调用轮次中的推理内容需要回传给上游（DeepSeek等要求），其余轮次的推理内容丢弃，
+				// redacted_thinking 为Claude加密内容，其他上游无法使用，同样丢弃
+				assistantMessage.ReasoningContent = strings.Join(thinkingParts, "\n\n")
 			}
 			if assistantMessage.Content == "" {
 				assistantMessage.Content = nil
@@ -595,6 +723,11 @@ func convertOpenAIToClaudeResponse(openaiResp OpenAIResponse, model string) Clau
 	if len(openaiResp.Choices) > 0 {
 		choice := openaiResp.Choices[0]
 
+		// 添加推理内容
+		if choice.Message.ReasoningContent != "" {
+			contentBlocks = append(contentBlocks, thinkingBlock(choice.Message.ReasoningContent))
+		}
+
 		// 添加文本内容
 		if choice.Message.Content != nil {
 			if content, ok := choice.Message.Content.(string); ok && content != "" {
@@ -654,15 +787,22 @@ func convertOpenAIToClaudeResponse(openaiResp OpenAIResponse, model string) Clau
 
 // handleStreamingResponse 处理流式响应
 func handleStreamingResponse(c *gin.Context, resp *http.Response, model string, isClientStream bool, account *model.Account, apiKey *model.ApiKey, startTime time.Time) {
-	// 设置流式响应头
-	c.Header("Content-Type", "text/event-stream")
-	c.Header("Cache-Control", "no-cache")
-	c.Header("Connection", "keep-alive")
-	c.Writer.Flush()
+	var usageTokens *common.TokenUsage
+	if account.UpstreamProtocol == constant.UpstreamProtocolResponses {
+		usageTokens = newResponsesStreamTransformer(model, isClientStream).process(c, resp.Body)
+	} else {
+		// 设置流式响应头（非流式请求在汇总后输出JSON）
+		if isClientStream {
+			c.Header("Content-Type", "text/event-stream")
+			c.Header("Cache-Control", "no-cache")
+			c.Header("Connection", "keep-alive")
+			c.Writer.Flush()
+		}
 
-	// 创建流式转换器并处理OpenAI流式响应
-	transformer := createStreamTransformer(model)
-	usageTokens := processOpenAIStreamResponse(c.Writer, resp.Body, transformer, isClientStream)
+		// 创建流式转换器并处理OpenAI流式响应
+		transformer := createStreamTransformer(model)
+		usageTokens = processOpenAIStreamResponse(c.Writer, resp.Body, transformer, isClientStream)
+	}
 
 	// 如果没有usage信息，创建0值的TokenUsage用于日志记录
 	if usageTokens == nil {
@@ -674,25 +814,21 @@ func handleStreamingResponse(c *gin.Context, resp *http.Response, model string,
 	}
 
 	// 更新账号状态和统计信息
-	accountService := service.NewAccountService()
-	go accountService.UpdateAccountStatus(account, resp.StatusCode, usageTokens)
+	if shouldUpdateAccountStats(c, resp.StatusCode) {
+		accountService := service.NewAccountService()
+		go accountService.UpdateAccountStatus(account, resp.StatusCode, usageTokens)
+	}
 
-	// 更新API Key统计信息
-	if apiKey != nil {
-		go service.UpdateApiKeyStatus(apiKey, resp.StatusCode, usageTokens)
+	// 对冲请求中只有最先完成的尝试计费
+	if apiKey == nil || !claimBilling(c, resp.StatusCode) {
+		return
 	}
 
+	// 更新API Key统计信息
+	go service.UpdateApiKeyStatus(apiKey, resp.StatusCode, usageTokens)
+
 	// 保存日志记录
-	if resp.StatusCode >= 200 && resp.StatusCode < 300 && apiKey != nil {
-		duration := time.Since(startTime).Milliseconds()
-		logService := service.NewLogService()
-		go func() {
-			_, err := logService.CreateLogFromTokenUsage(usageTokens, apiKey.UserID, apiKey.ID, account.ID, duration, isClientStream)
-			if err != nil {
-				log.Printf("保存日志失败: %v", err)
-			}
-		}()
-	}
+	saveRequestLog(startTime, apiKey, account, resp.StatusCode, usageTokens, isClientStream, extractClientInfo(c))
 }
 
 // processOpenAIStreamResponse 处理OpenAI流式响应并转换为Claude格式
@@ -701,6 +837,7 @@ func processOpenAIStreamResponse(writer gin.ResponseWriter, reader io.Reader, tr
 
 	var totalPromptTokens, totalCompletionTokens int
 	var responseContent strings.Builder
+	var reasoningContent strings.Builder
 	var toolCalls []OpenAIToolCall
 	var finishReason string
 
@@ -714,10 +851,15 @@ func processOpenAIStreamResponse(writer gin.ResponseWriter, reader io.Reader, tr
 
 		data := line[6:] // 移除 "data: " 前缀
 
-		// 处理结束标记
+		// 处理结束标记，输出被内容过滤拦截时返回错误
 		if strings.TrimSpace(data) == "[DONE]" {
 			if isClientStream {
-				transformer.sendFinalEvents(writer)
+				if finishReason == "content_filter" {
+					transformer.closeOpenBlock(writer)
+					transformer.sendEvent(writer, "error", contentFilterError())
+				} else {
+					transformer.sendFinalEvents(writer)
+				}
 			}
 			break
 		}
@@ -747,6 +889,9 @@ func processOpenAIStreamResponse(writer gin.ResponseWriter, reader io.Reader, tr
 				}
 
 				if delta, ok := choice["delta"].(map[string]any); ok {
+					// 收集推理内容
+					reasoningContent.WriteString(reasoningDelta(delta))
+
 					// 收集文本内容
 					if content, ok := delta["content"].(string); ok {
 						responseContent.WriteString(content)
@@ -790,11 +935,22 @@ func processOpenAIStreamResponse(writer gin.ResponseWriter, reader io.Reader, tr
 		}
 	}
 
-	// 如果客户端不需要流式响应，发送完整的非流式响应
-	if !isClientStream {
+	// 非流式请求的输出被内容过滤拦截时返回错误
+	if !isClientStream && finishReason == "content_filter" {
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		jsonBytes, _ := json.Marshal(contentFilterError())
+		writer.Write(jsonBytes)
+	} else if !isClientStream {
+		// 如果客户端不需要流式响应，发送完整的非流式响应
 		// 构建Claude格式的内容块
 		var contentBlocks []ClaudeContentBlock
 
+		// 添加推理内容
+		if reasoningContent.Len() > 0 {
+			contentBlocks = append(contentBlocks, thinkingBlock(reasoningContent.String()))
+		}
+
 		// 添加文本内容
 		if responseContent.Len() > 0 {
 			contentBlocks = append(contentBlocks, ClaudeContentBlock{
@@ -871,7 +1027,9 @@ type StreamTransformer struct {
 	messageID         string
 	model             string
 	toolCalls         map[int]*ToolCallState
-	contentBlockIndex int
+	contentBlockIndex int    // 下一个内容块的下标
+	openBlockType     string // 当前打开的 text/thinking 块类型，为空表示没有打开的块
+	openBlockIndex    int
 }
 
 // ToolCallState 工具调用状态
@@ -931,15 +1089,6 @@ func (st *StreamTransformer) processChunk(writer gin.ResponseWriter, openaiChunk
 			},
 		})
 
-		st.sendEvent(writer, "content_block_start", map[string]any{
-			"type":  "content_block_start",
-			"index": 0,
-			"content_block": map[string]any{
-				"type": "text",
-				"text": "",
-			},
-		})
-
 		st.initialized = true
 	}
 
@@ -947,16 +1096,14 @@ func (st *StreamTransformer) processChunk(writer gin.ResponseWriter, openaiChunk
 	if choices, ok := openaiChunk["choices"].([]any); ok && len(choices) > 0 {
 		if choice, ok := choices[0].(map[string]any); ok {
 			if delta, ok := choice["delta"].(map[string]any); ok {
+				// 处理推理内容
+				if reasoning := reasoningDelta(delta); reasoning != "" {
+					st.sendBlockDelta(writer, "thinking", reasoning)
+				}
+
 				// 处理文本内容
-				if content, ok := delta["content"].(string); ok {
-					st.sendEvent(writer, "content_block_delta", map[string]any{
-						"type":  "content_block_delta",
-						"index": 0,
-						"delta": map[string]any{
-							"type": "text_delta",
-							"text": content,
-						},
-					})
+				if content, ok := delta["content"].(string); ok && content != "" {
+					st.sendBlockDelta(writer, "text", content)
 				}
 
 				// 处理工具调用
@@ -972,6 +1119,53 @@ func (st *StreamTransformer) processChunk(writer gin.ResponseWriter, openaiChunk
 	}
 }
 
+// startBlock 开启新的 text 或 thinking 块，已有打开的块时先结束它
+func (st *StreamTransformer) startBlock(writer gin.ResponseWriter, blockType string) {
+	st.closeOpenBlock(writer)
+	st.openBlockType = blockType
+	st.openBlockIndex = st.contentBlockIndex
+	st.contentBlockIndex++
+
+	contentBlock := map[string]any{"type": "text", "text": ""}
+	if blockType == "thinking" {
+		contentBlock = map[string]any{"type": "thinking", "thinking": "", "signature": ""}
+	}
+	st.sendEvent(writer, "content_block_start", map[string]any{
+		"type":          "content_block_start",
+		"index":         st.openBlockIndex,
+		"content_block": contentBlock,
+	})
+}
+
+// sendBlockDelta 发送文本或推理增量，内容类型变化时切换到新的内容块
+func (st *StreamTransformer) sendBlockDelta(writer gin.ResponseWriter, blockType, text string) {
+	if st.openBlockType != blockType {
+		st.startBlock(writer, blockType)
+	}
+
+	delta := map[string]any{"type": "text_delta", "text": text}
+	if blockType == "thinking" {
+		delta = map[string]any{"type": "thinking_delta", "thinking": text}
+	}
+	st.sendEvent(writer, "content_block_delta", map[string]any{
+		"type":  "content_block_delta",
+		"index": st.openBlockIndex,
+		"delta": delta,
+	})
+}
+
+// closeOpenBlock 结束当前打开的 text 或 thinking 块
+func (st *StreamTransformer) closeOpenBlock(writer gin.ResponseWriter) {
+	if st.openBlockType == "" {
+		return
+	}
+	st.sendEvent(writer, "content_block_stop", map[string]any{
+		"type":  "content_block_stop",
+		"index": st.openBlockIndex,
+	})
+	st.openBlockType = ""
+}
+
 // processToolCallDelta 处理工具调用增量
 func (st *StreamTransformer) processToolCallDelta(writer gin.ResponseWriter, tcDelta map[string]any) {
 	index := int(tcDelta["index"].(float64))
@@ -1005,9 +1199,10 @@ func (st *StreamTransformer) processToolCallDelta(writer gin.ResponseWriter, tcD
 
 	// 如果工具调用准备就绪且未开始，发送开始事件
 	if toolCall.ID != "" && toolCall.Name != "" && !toolCall.Started {
-		st.contentBlockIndex++
+		st.closeOpenBlock(writer)
 		toolCall.ClaudeIndex = st.contentBlockIndex
 		toolCall.Started = true
+		st.contentBlockIndex++
 
 		st.sendEvent(writer, "content_block_start", map[string]any{
 			"type":  "content_block_start",
@@ -1040,11 +1235,13 @@ func (st *StreamTransformer) processToolCallDelta(writer gin.ResponseWriter, tcD
 
 // sendFinalEvents 发送最终事件
 func (st *StreamTransformer) sendFinalEvents(writer gin.ResponseWriter) {
+	// 上游没有返回任何内容时补一个空文本块
+	if st.contentBlockIndex == 0 {
+		st.startBlock(writer, "text")
+	}
+
 	// 发送内容块结束事件
-	st.sendEvent(writer, "content_block_stop", map[string]any{
-		"type":  "content_block_stop",
-		"index": 0,
-	})
+	st.closeOpenBlock(writer)
 
 	// 发送所有工具调用的结束事件
 	for _, toolCall := range st.toolCalls {
@@ -1097,26 +1294,18 @@ func TestHandleOpenAIRequest(account *model.Account) (int, string) {
 	// 应用模型映射
 	mappedModelName := applyModelMapping(claudeReq.Model, account.ModelMapping, targetConfig.ModelName)
 
-	// 转换Claude请求为OpenAI格式
-	openaiReq := convertClaudeToOpenAI(claudeReq, mappedModelName)
-
-	// 序列化OpenAI请求
-	openaiBody, err := json.Marshal(openaiReq)
+	// 按账号的上游协议转换并序列化OpenAI请求
+	requestPath, openaiBody, err := buildOpenAIUpstreamRequest(account, claudeReq, mappedModelName)
 	if err != nil {
 		return http.StatusInternalServerError, "Failed to marshal OpenAI request: " + err.Error()
 	}
 
 	// 创建OpenAI API请求
-	openaiURL := targetConfig.BaseURL + "/chat/completions"
-	req, err := http.NewRequest("POST", openaiURL, bytes.NewBuffer(openaiBody))
+	req, err := createOpenAIRequest(context.Background(), account, requestPath, mappedModelName, openaiBody)
 	if err != nil {
 		return http.StatusInternalServerError, "Failed to create request: " + err.Error()
 	}
 
-	// 设置请求头
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+account.SecretKey)
-
 	// 创建HTTP客户端
 	httpClientTimeout := 30 * time.Second
 	transport := &http.Transport{
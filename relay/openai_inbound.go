@@ -0,0 +1,538 @@
+package relay
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// OpenAI请求未指定最大输出token数时使用的默认值（Claude要求必填）
+	openAIInboundDefaultMaxTokens = 8192
+	// Claude的temperature取值范围为0-1
+	claudeMaxTemperature = 1.0
+)
+
+// ConvertOpenAIChatToClaude 将OpenAI Chat Completions请求转换为Claude Messages请求
+// 支持文本、图片、工具调用及流式选项；Claude官方账号需要的 Claude Code 系统提示词由其转发逻辑补充
+func ConvertOpenAIChatToClaude(body []byte) ([]byte, error) {
+	if !gjson.ValidBytes(body) {
+		return nil, errors.New("request body is not valid JSON")
+	}
+	openaiReq := gjson.ParseBytes(body)
+
+	claudeReq := ClaudeRequest{
+		Model:     openaiReq.Get("model").String(),
+		MaxTokens: openAIInboundDefaultMaxTokens,
+		Stream:    openaiReq.Get("stream").Bool(),
+	}
+	if claudeReq.Model == "" {
+		return nil, errors.New("the model field is required")
+	}
+
+	if maxTokens := openaiReq.Get("max_completion_tokens"); maxTokens.Exists() {
+		claudeReq.MaxTokens = int(maxTokens.Int())
+	} else if maxTokens := openaiReq.Get("max_tokens"); maxTokens.Exists() {
+		claudeReq.MaxTokens = int(maxTokens.Int())
+	}
+	if temperature := openaiReq.Get("temperature"); temperature.Exists() {
+		value := temperature.Float()
+		if value > claudeMaxTemperature {
+			value = claudeMaxTemperature
+		}
+		claudeReq.Temperature = &value
+	}
+	if topP := openaiReq.Get("top_p"); topP.Exists() {
+		value := topP.Float()
+		claudeReq.TopP = &value
+	}
+
+	stop := openaiReq.Get("stop")
+	if stop.IsArray() {
+		for _, item := range stop.Array() {
+			claudeReq.StopSequences = append(claudeReq.StopSequences, item.String())
+		}
+	} else if stop.String() != "" {
+		claudeReq.StopSequences = []string{stop.String()}
+	}
+
+	var system []map[string]any
+	for _, message := range openaiReq.Get("messages").Array() {
+		content := message.Get("content")
+		switch message.Get("role").String() {
+		case "system", "developer":
+			if text := openAIContentText(content); text != "" {
+				system = append(system, map[string]any{"type": "text", "text": text})
+			}
+		case "user":
+			claudeReq.Messages = appendClaudeMessage(claudeReq.Messages, "user", convertOpenAIContent(content))
+		case "assistant":
+			var blocks []any
+			if text := openAIContentText(content); text != "" {
+				blocks = append(blocks, map[string]any{"type": "text", "text": text})
+			}
+			for _, toolCall := range message.Get("tool_calls").Array() {
+				blocks = append(blocks, map[string]any{
+					"type":  "tool_use",
+					"id":    toolCall.Get("id").String(),
+					"name":  toolCall.Get("function.name").String(),
+					"input": parseToolArguments(toolCall.Get("function.arguments").String()),
+				})
+			}
+			claudeReq.Messages = appendClaudeMessage(claudeReq.Messages, "assistant", blocks)
+		case "tool":
+			claudeReq.Messages = appendClaudeMessage(claudeReq.Messages, "user", []any{map[string]any{
+				"type":        "tool_result",
+				"tool_use_id": message.Get("tool_call_id").String(),
+				"content":     openAIContentText(content),
+			}})
+		}
+	}
+	if len(system) > 0 {
+		claudeReq.System = system
+	}
+
+	for _, tool := range openaiReq.Get("tools").Array() {
+		if tool.Get("type").String() != "function" {
+			continue
+		}
+		var inputSchema any = map[string]any{"type": "object", "properties": map[string]any{}}
+		if parameters := tool.Get("function.parameters"); parameters.IsObject() {
+			inputSchema = parameters.Value()
+		}
+		claudeReq.Tools = append(claudeReq.Tools, ClaudeTool{
+			Name:        tool.Get("function.name").String(),
+			Description: tool.Get("function.description").String(),
+			InputSchema: inputSchema,
+		})
+	}
+
+	claudeReq.ToolChoice = convertOpenAIToolChoice(openaiReq.Get("tool_choice"))
+	if parallel := openaiReq.Get("parallel_tool_calls"); parallel.Exists() && !parallel.Bool() && len(claudeReq.Tools) > 0 {
+		if claudeReq.ToolChoice == nil {
+			claudeReq.ToolChoice = &ClaudeToolChoice{Type: "auto"}
+		}
+		claudeReq.ToolChoice.DisableParallelToolUse = true
+	}
+
+	return json.Marshal(claudeReq)
+}
+
+// openAIContentText 提取OpenAI消息内容中的文本（字符串或内容分段数组）
+func openAIContentText(content gjson.Result) string {
+	if !content.IsArray() {
+		return content.String()
+	}
+
+	var parts []string
+	for _, part := range content.Array() {
+		if part.Get("type").String() == "text" {
+			parts = append(parts, part.Get("text").String())
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// convertOpenAIContent 将OpenAI用户消息内容转换为Claude内容块，支持文本和图片
+func convertOpenAIContent(content gjson.Result) []any {
+	if !content.IsArray() {
+		if text := content.String(); text != "" {
+			return []any{map[string]any{"type": "text", "text": text}}
+		}
+		return nil
+	}
+
+	var blocks []any
+	for _, part := range content.Array() {
+		switch part.Get("type").String() {
+		case "text":
+			blocks = append(blocks, map[string]any{"type": "text", "text": part.Get("text").String()})
+		case "image_url":
+			imageURL := part.Get("image_url.url").String()
+			if imageURL == "" {
+				imageURL = part.Get("image_url").String()
+			}
+			if imageURL != "" {
+				blocks = append(blocks, convertImageURL(imageURL))
+			}
+		}
+	}
+	return blocks
+}
+
+// convertImageURL 将图片地址转换为Claude图片块，data URL 转为base64来源，其余按URL来源传递
+func convertImageURL(imageURL string) map[string]any {
+	if strings.HasPrefix(imageURL, "data:") {
+		meta, data, found := strings.Cut(strings.TrimPrefix(imageURL, "data:"), ",")
+		if found {
+			return map[string]any{
+				"type": "image",
+				"source": map[string]any{
+					"type":       "base64",
+					"media_type": strings.TrimSuffix(meta, ";base64"),
+					"data":       data,
+				},
+			}
+		}
+	}
+
+	return map[string]any{
+		"type":   "image",
+		"source": map[string]any{"type": "url", "url": imageURL},
+	}
+}
+
+// parseToolArguments 解析工具调用参数，无效时返回空对象（Claude要求 input 为对象）
+func parseToolArguments(arguments string) any {
+	if parsed := gjson.Parse(arguments); parsed.IsObject() {
+		return parsed.Value()
+	}
+	return map[string]any{}
+}
+
+// appendClaudeMessage 追加Claude消息，与上一条消息角色相同时合并（Claude要求用户与助手消息交替出现）
+func appendClaudeMessage(messages []ClaudeMessage, role string, blocks []any) []ClaudeMessage {
+	if len(blocks) == 0 {
+		return messages
+	}
+
+	if last := len(messages) - 1; last >= 0 && messages[last].Role == role {
+		if existing, ok := messages[last].Content.([]any); ok {
+			messages[last].Content = append(existing, blocks...)
+			return messages
+		}
+	}
+	return append(messages, ClaudeMessage{Role: role, Content: blocks})
+}
+
+// convertOpenAIToolChoice 转换工具选择：auto/none/required 或指定函数
+func convertOpenAIToolChoice(toolChoice gjson.Result) *ClaudeToolChoice {
+	if !toolChoice.Exists() {
+		return nil
+	}
+	if toolChoice.IsObject() {
+		if name := toolChoice.Get("function.name").String(); name != "" {
+			return &ClaudeToolChoice{Type: "tool", Name: name}
+		}
+		return nil
+	}
+
+	switch toolChoice.String() {
+	case "none":
+		return &ClaudeToolChoice{Type: "none"}
+	case "required":
+		return &ClaudeToolChoice{Type: "any"}
+	case "auto":
+		return &ClaudeToolChoice{Type: "auto"}
+	default:
+		return nil
+	}
+}
+
+// OpenAIChatWriter 将Claude流式响应转换为OpenAI Chat Completions格式写回客户端
+// 流式请求逐个转换为 chat.completion.chunk；非流式请求先累积结果，由 Finish 一次性写出 chat.completion
+// 非2xx响应（错误信息）原样透传
+type OpenAIChatWriter struct {
+	gin.ResponseWriter
+	stream       bool
+	includeUsage bool
+	model        string
+	id           string
+	created      int64
+	status       int
+	remainder    string
+	headersReady bool
+	done         bool
+
+	content          strings.Builder
+	reasoning        strings.Builder
+	toolCalls        []OpenAIToolCall
+	blockToolIndex   map[int]int // Claude内容块序号 -> OpenAI工具调用序号
+	finishReason     string
+	promptTokens     int
+	completionTokens int
+	errorMessage     string
+}
+
+// NewOpenAIChatWriter 根据原始OpenAI请求创建响应转换器
+func NewOpenAIChatWriter(writer gin.ResponseWriter, openaiBody []byte) *OpenAIChatWriter {
+	return &OpenAIChatWriter{
+		ResponseWriter: writer,
+		stream:         gjson.GetBytes(openaiBody, "stream").Bool(),
+		includeUsage:   gjson.GetBytes(openaiBody, "stream_options.include_usage").Bool(),
+		model:          gjson.GetBytes(openaiBody, "model").String(),
+		id:             "chatcmpl-" + generateRandomID(),
+		created:        time.Now().Unix(),
+		blockToolIndex: make(map[int]int),
+	}
+}
+
+// WriteHeader 记录状态码，是否转换响应体取决于状态码
+func (w *OpenAIChatWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write 成功响应按行解析Claude SSE事件并转换，错误响应原样写出
+func (w *OpenAIChatWriter) Write(data []byte) (int, error) {
+	if !w.converting() {
+		return w.ResponseWriter.Write(data)
+	}
+
+	lines := strings.Split(w.remainder+string(data), "\n")
+	w.remainder = lines[len(lines)-1]
+	for _, line := range lines[:len(lines)-1] {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "data:") {
+			w.handleEvent(gjson.Parse(strings.TrimSpace(strings.TrimPrefix(line, "data:"))))
+		}
+	}
+	return len(data), nil
+}
+
+// WriteString 同 Write
+func (w *OpenAIChatWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush 非流式请求在 Finish 之前不向客户端提交任何内容
+func (w *OpenAIChatWriter) Flush() {
+	if w.converting() && !w.stream {
+		return
+	}
+	w.prepareHeaders()
+	w.ResponseWriter.Flush()
+}
+
+// Finish 请求处理结束后调用：非流式请求写出完整响应，流式请求补齐结束标记
+func (w *OpenAIChatWriter) Finish() {
+	if !w.converting() {
+		return
+	}
+
+	if w.stream {
+		if !w.done {
+			w.writeDone()
+		}
+		return
+	}
+
+	w.prepareHeaders()
+	if w.errorMessage != "" && w.content.Len() == 0 && len(w.toolCalls) == 0 {
+		w.ResponseWriter.WriteHeader(529)
+		w.writeJSON(gin.H{"error": map[string]any{"type": "overloaded_error", "message": w.errorMessage}})
+		return
+	}
+
+	message := gin.H{"role": "assistant", "content": nil}
+	if w.content.Len() > 0 {
+		message["content"] = w.content.String()
+	}
+	if w.reasoning.Len() > 0 {
+		message["reasoning_content"] = w.reasoning.String()
+	}
+	if len(w.toolCalls) > 0 {
+		message["tool_calls"] = w.toolCalls
+	}
+
+	w.writeJSON(gin.H{
+		"id":      w.id,
+		"object":  "chat.completion",
+		"created": w.created,
+		"model":   w.model,
+		"choices": []gin.H{{
+			"index":         0,
+			"message":       message,
+			"finish_reason": w.finishReasonOrStop(),
+		}},
+		"usage": w.usage(),
+	})
+}
+
+// converting 是否需要转换响应（状态码为2xx）
+func (w *OpenAIChatWriter) converting() bool {
+	return w.status >= 200 && w.status < 300
+}
+
+// prepareHeaders 首次提交前修正响应头：响应体已被改写，去掉长度和编码头
+func (w *OpenAIChatWriter) prepareHeaders() {
+	if w.headersReady || !w.converting() {
+		return
+	}
+	w.headersReady = true
+
+	header := w.Header()
+	header.Del("Content-Length")
+	header.Del("Content-Encoding")
+	if w.stream {
+		header.Set("Content-Type", "text/event-stream")
+	} else {
+		header.Set("Content-Type", "application/json")
+	}
+}
+
+// handleEvent 处理单个Claude流式事件
+func (w *OpenAIChatWriter) handleEvent(event gjson.Result) {
+	switch event.Get("type").String() {
+	case "message_start":
+		message := event.Get("message")
+		if id := message.Get("id").String(); id != "" {
+			w.id = "chatcmpl-" + id
+		}
+		w.promptTokens = int(message.Get("usage.input_tokens").Int() +
+			message.Get("usage.cache_read_input_tokens").Int() +
+			message.Get("usage.cache_creation_input_tokens").Int())
+		w.sendChunk(gin.H{"role": "assistant", "content": ""}, nil)
+	case "content_block_start":
+		block := event.Get("content_block")
+		if block.Get("type").String() != "tool_use" {
+			return
+		}
+		index := len(w.toolCalls)
+		w.blockToolIndex[int(event.Get("index").Int())] = index
+		w.toolCalls = append(w.toolCalls, OpenAIToolCall{
+			ID:       block.Get("id").String(),
+			Type:     "function",
+			Function: OpenAIFunctionCall{Name: block.Get("name").String()},
+		})
+		w.sendChunk(gin.H{"tool_calls": []gin.H{{
+			"index":    index,
+			"id":       block.Get("id").String(),
+			"type":     "function",
+			"function": gin.H{"name": block.Get("name").String(), "arguments": ""},
+		}}}, nil)
+	case "content_block_delta":
+		delta := event.Get("delta")
+		switch delta.Get("type").String() {
+		case "text_delta":
+			text := delta.Get("text").String()
+			w.content.WriteString(text)
+			w.sendChunk(gin.H{"content": text}, nil)
+		case "thinking_delta":
+			thinking := delta.Get("thinking").String()
+			w.reasoning.WriteString(thinking)
+			w.sendChunk(gin.H{"reasoning_content": thinking}, nil)
+		case "input_json_delta":
+			index, ok := w.blockToolIndex[int(event.Get("index").Int())]
+			if !ok {
+				return
+			}
+			partial := delta.Get("partial_json").String()
+			w.toolCalls[index].Function.Arguments += partial
+			w.sendChunk(gin.H{"tool_calls": []gin.H{{
+				"index":    index,
+				"function": gin.H{"arguments": partial},
+			}}}, nil)
+		}
+	case "message_delta":
+		if stopReason := event.Get("delta.stop_reason").String(); stopReason != "" {
+			w.finishReason = convertStopReason(stopReason)
+		}
+		if outputTokens := event.Get("usage.output_tokens"); outputTokens.Exists() {
+			w.completionTokens = int(outputTokens.Int())
+		}
+	case "message_stop":
+		if !w.stream {
+			return
+		}
+		finishReason := w.finishReasonOrStop()
+		w.sendChunk(gin.H{}, &finishReason)
+		w.writeDone()
+	case "error":
+		w.errorMessage = event.Get("error.message").String()
+		if w.stream {
+			w.writeSSE(gin.H{"error": map[string]any{
+				"type":    event.Get("error.type").String(),
+				"message": w.errorMessage,
+			}})
+		}
+	}
+}
+
+// sendChunk 流式请求写出一个 chat.completion.chunk
+func (w *OpenAIChatWriter) sendChunk(delta gin.H, finishReason *string) {
+	if !w.stream {
+		return
+	}
+	w.writeSSE(gin.H{
+		"id":      w.id,
+		"object":  "chat.completion.chunk",
+		"created": w.created,
+		"model":   w.model,
+		"choices": []gin.H{{
+			"index":         0,
+			"delta":         delta,
+			"finish_reason": finishReason,
+		}},
+	})
+}
+
+// writeDone 写出用量（客户端要求时）和结束标记
+func (w *OpenAIChatWriter) writeDone() {
+	w.done = true
+	if w.includeUsage {
+		w.writeSSE(gin.H{
+			"id":      w.id,
+			"object":  "chat.completion.chunk",
+			"created": w.created,
+			"model":   w.model,
+			"choices": []gin.H{},
+			"usage":   w.usage(),
+		})
+	}
+	w.writeRaw("data: [DONE]\n\n")
+}
+
+// writeSSE 写出一条SSE数据并立即刷新
+func (w *OpenAIChatWriter) writeSSE(data any) {
+	payload, _ := json.Marshal(data)
+	w.writeRaw("data: " + string(payload) + "\n\n")
+}
+
+// writeRaw 直接写入底层响应
+func (w *OpenAIChatWriter) writeRaw(data string) {
+	w.prepareHeaders()
+	_, _ = w.ResponseWriter.Write([]byte(data))
+	w.ResponseWriter.Flush()
+}
+
+// writeJSON 写出JSON响应体
+func (w *OpenAIChatWriter) writeJSON(data any) {
+	payload, _ := json.Marshal(data)
+	_, _ = w.ResponseWriter.Write(payload)
+}
+
+// usage OpenAI格式的用量统计
+func (w *OpenAIChatWriter) usage() OpenAIUsage {
+	return OpenAIUsage{
+		PromptTokens:     w.promptTokens,
+		CompletionTokens: w.completionTokens,
+		TotalTokens:      w.promptTokens + w.completionTokens,
+	}
+}
+
+// finishReasonOrStop 结束原因，上游未返回时视为正常结束
+func (w *OpenAIChatWriter) finishReasonOrStop() string {
+	if w.finishReason == "" {
+		return "stop"
+	}
+	return w.finishReason
+}
+
+// convertStopReason 将Claude的停止原因转换为OpenAI的 finish_reason
+func convertStopReason(stopReason string) string {
+	switch stopReason {
+	case "max_tokens":
+		return "length"
+	case "tool_use":
+		return "tool_calls"
+	case "refusal":
+		return "content_filter"
+	default:
+		return "stop"
+	}
+}
@@ -0,0 +1,533 @@
+package relay
+
+import (
+	"bufio"
+	"claude-code-relay/common"
+	"claude-code-relay/constant"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// OpenAI Responses API 类型定义
+type ResponsesRequest struct {
+	Model             string               `json:"model"`
+	Instructions      string               `json:"instructions,omitempty"`
+	Input             []ResponsesInputItem `json:"input"`
+	MaxOutputTokens   *int                 `json:"max_output_tokens,omitempty"`
+	Temperature       *float64             `json:"temperature,omitempty"`
+	TopP              *float64             `json:"top_p,omitempty"`
+	Stream            bool                 `json:"stream"`
+	Tools             []ResponsesTool      `json:"tools,omitempty"`
+	ToolChoice        any                  `json:"tool_choice,omitempty"`
+	ParallelToolCalls *bool                `json:"parallel_tool_calls,omitempty"`
+	Reasoning         *ResponsesReasoning  `json:"reasoning,omitempty"`
+}
+
+type ResponsesInputItem struct {
+	Type      string                 `json:"type"`
+	Role      string                 `json:"role,omitempty"`
+	Content   []ResponsesContentPart `json:"content,omitempty"`
+	CallID    string                 `json:"call_id,omitempty"`
+	Name      string                 `json:"name,omitempty"`
+	Arguments string                 `json:"arguments,omitempty"`
+	Output    any                    `json:"output,omitempty"`
+}
+
+type ResponsesContentPart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	ImageURL string `json:"image_url,omitempty"`
+}
+
+type ResponsesTool struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Parameters  any    `json:"parameters"`
+}
+
+type ResponsesReasoning struct {
+	Effort  string `json:"effort,omitempty"`
+	Summary string `json:"summary,omitempty"`
+}
+
+// convertClaudeToResponses 将Claude请求转换为OpenAI Responses格式
+// 系统提示词转换为 instructions，tool_use/tool_result 转换为 function_call/function_call_output
+func convertClaudeToResponses(claudeReq ClaudeRequest, modelName string) ResponsesRequest {
+	responsesReq := ResponsesRequest{
+		Model:        modelName,
+		Instructions: extractSystemMessage(claudeReq.System),
+		Input:        []ResponsesInputItem{},
+		Temperature:  claudeReq.Temperature,
+		TopP:         claudeReq.TopP,
+		Stream:       true, // 强制流式处理
+	}
+	if claudeReq.MaxTokens > 0 {
+		maxTokens := claudeReq.MaxTokens
+		responsesReq.MaxOutputTokens = &maxTokens
+	}
+
+	for _, message := range claudeReq.Messages {
+		responsesReq.Input = append(responsesReq.Input, convertClaudeMessageToResponses(message)...)
+	}
+
+	for _, tool := range claudeReq.Tools {
+		responsesReq.Tools = append(responsesReq.Tools, ResponsesTool{
+			Type:        "function",
+			Name:        tool.Name,
+			Description: tool.Description,
+			Parameters:  recursivelyCleanSchema(tool.InputSchema),
+		})
+	}
+
+	if claudeReq.ToolChoice != nil {
+		switch claudeReq.ToolChoice.Type {
+		case "auto":
+			responsesReq.ToolChoice = "auto"
+		case "any":
+			responsesReq.ToolChoice = "required"
+		case "none":
+			responsesReq.ToolChoice = "none"
+		case "tool":
+			responsesReq.ToolChoice = map[string]any{
+				"type": "function",
+				"name": claudeReq.ToolChoice.Name,
+			}
+		}
+		if claudeReq.ToolChoice.DisableParallelToolUse && len(responsesReq.Tools) > 0 {
+			parallel := false
+			responsesReq.ParallelToolCalls = &parallel
+		}
+	}
+
+	return responsesReq
+}
+
+// applyResponsesReasoning 账号配置为 reasoning_effort 且请求开启 thinking 时，按预算设置推理强度并要求上游返回推理摘要
+// 非推理模型会拒绝 reasoning 参数，账号未配置时不传递
+func applyResponsesReasoning(responsesReq *ResponsesRequest, thinkingParam string, thinking *ClaudeThinking) {
+	if thinkingParam != constant.ThinkingParamReasoningEffort {
+		return
+	}
+	if effort := thinkingEffort(thinking); effort != "" {
+		responsesReq.Reasoning = &ResponsesReasoning{Effort: effort, Summary: "auto"}
+	}
+}
+
+// convertClaudeMessageToResponses 将一条Claude消息转换为Responses的输入项
+// 工具调用和工具结果是独立的输入项，因此一条消息可能拆分为多个输入项，并保持原有顺序
+func convertClaudeMessageToResponses(message ClaudeMessage) []ResponsesInputItem {
+	textType := "input_text"
+	if message.Role == "assistant" {
+		textType = "output_text"
+	}
+
+	if text, ok := message.Content.(string); ok {
+		return []ResponsesInputItem{{
+			Type:    "message",
+			Role:    message.Role,
+			Content: []ResponsesContentPart{{Type: textType, Text: text}},
+		}}
+	}
+
+	var items []ResponsesInputItem
+	var parts []ResponsesContentPart
+	flushParts := func() {
+		if len(parts) == 0 {
+			return
+		}
+		items = append(items, ResponsesInputItem{Type: "message", Role: message.Role, Content: parts})
+		parts = nil
+	}
+
+	contentBlocks, _ := message.Content.([]any)
+	for _, block := range contentBlocks {
+		blockMap, ok := block.(map[string]any)
+		if !ok {
+			continue
+		}
+		switch blockMap["type"] {
+		case "text":
+			if text, _ := blockMap["text"].(string); text != "" {
+				parts = append(parts, ResponsesContentPart{Type: textType, Text: text})
+			}
+		case "image":
+			if imageURL := claudeImageURL(blockMap); imageURL != "" && message.Role == "user" {
+				parts = append(parts, ResponsesContentPart{Type: "input_image", ImageURL: imageURL})
+			}
+		case "tool_use":
+			flushParts()
+			arguments := "{}"
+			if blockMap["input"] != nil {
+				argBytes, _ := json.Marshal(blockMap["input"])
+				arguments = string(argBytes)
+			}
+			callID, _ := blockMap["id"].(string)
+			name, _ := blockMap["name"].(string)
+			items = append(items, ResponsesInputItem{
+				Type:      "function_call",
+				CallID:    callID,
+				Name:      name,
+				Arguments: arguments,
+			})
+		case "tool_result":
+			flushParts()
+			callID, _ := blockMap["tool_use_id"].(string)
+			items = append(items, ResponsesInputItem{
+				Type:   "function_call_output",
+				CallID: callID,
+				Output: toolResultText(blockMap["content"]),
+			})
+		}
+		// thinking 块的签名只有Claude能校验，Responses的推理项也无法由摘要还原，历史中的推理内容直接丢弃
+	}
+	flushParts()
+	return items
+}
+
+// claudeImageURL 将Claude图片块转换为图片地址，base64数据转换为 data URL
+func claudeImageURL(blockMap map[string]any) string {
+	source, ok := blockMap["source"].(map[string]any)
+	if !ok {
+		return ""
+	}
+	if source["type"] == "url" {
+		imageURL, _ := source["url"].(string)
+		return imageURL
+	}
+	return fmt.Sprintf("data:%s;base64,%s", source["media_type"], source["data"])
+}
+
+// ResponsesStreamTransformer 将OpenAI Responses流式事件转换为Claude格式
+// 推理摘要转换为 thinking 块，消息文本转换为 text 块，函数调用转换为 tool_use 块；
+// 客户端为流式请求时实时发送Claude SSE事件，否则累积后输出完整的Claude响应
+type ResponsesStreamTransformer struct {
+	messageID      string
+	model          string
+	isClientStream bool
+	started        bool
+	blockIndex     int
+	blockOpen      bool
+	openOutput     int64 // 当前打开的内容块对应的 output_index
+	openType       string
+	argsStreamed   bool
+	toolArgs       strings.Builder
+	content        []ClaudeContentBlock
+	stopReason     string
+	errorType      string
+	errorMessage   string
+	usage          *common.TokenUsage
+}
+
+// newResponsesStreamTransformer 创建Responses流式转换器
+func newResponsesStreamTransformer(model string, isClientStream bool) *ResponsesStreamTransformer {
+	return &ResponsesStreamTransformer{
+		messageID:      fmt.Sprintf("msg_%s", generateRandomID()),
+		model:          model,
+		isClientStream: isClientStream,
+	}
+}
+
+// process 读取Responses SSE响应并写回客户端，返回token使用统计
+func (t *ResponsesStreamTransformer) process(c *gin.Context, reader io.Reader) *common.TokenUsage {
+	if t.isClientStream {
+		c.Status(http.StatusOK)
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+	}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			break
+		}
+		t.processEvent(c.Writer, gjson.Parse(data))
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("读取Responses流式响应失败: %v", err)
+	}
+
+	t.finish(c.Writer)
+	return t.usage
+}
+
+// processEvent 处理单个Responses流式事件
+func (t *ResponsesStreamTransformer) processEvent(writer gin.ResponseWriter, event gjson.Result) {
+	t.start(writer)
+
+	outputIndex := event.Get("output_index").Int()
+	switch event.Get("type").String() {
+	case "response.output_item.added":
+		item := event.Get("item")
+		if item.Get("type").String() == "function_call" {
+			t.startToolUse(writer, outputIndex, item)
+		}
+	case "response.output_text.delta", "response.refusal.delta":
+		t.appendDelta(writer, outputIndex, "text", event.Get("delta").String())
+	case "response.reasoning_summary_part.added":
+		// 同一推理项的多段摘要合并为一个 thinking 块，段落之间空行分隔
+		if event.Get("summary_index").Int() > 0 {
+			t.appendDelta(writer, outputIndex, "thinking", "\n\n")
+		}
+	case "response.reasoning_summary_text.delta":
+		t.appendDelta(writer, outputIndex, "thinking", event.Get("delta").String())
+	case "response.function_call_arguments.delta":
+		t.appendToolArguments(writer, outputIndex, event.Get("delta").String())
+	case "response.output_item.done":
+		item := event.Get("item")
+		// 部分上游不发送参数增量，只在函数调用结束时给出完整参数
+		if item.Get("type").String() == "function_call" && t.isOpen(outputIndex, "tool_use") && !t.argsStreamed {
+			t.appendToolArguments(writer, outputIndex, item.Get("arguments").String())
+		}
+		if t.blockOpen && t.openOutput == outputIndex {
+			t.closeBlock(writer)
+		}
+	case "response.completed", "response.incomplete":
+		t.complete(event.Get("response"))
+	case "response.failed":
+		t.complete(event.Get("response"))
+		t.errorMessage = event.Get("response.error.message").String()
+		if t.errorMessage == "" {
+			t.errorMessage = "upstream response failed"
+		}
+	case "error":
+		t.errorMessage = event.Get("message").String()
+		if t.errorMessage == "" {
+			t.errorMessage = event.Get("error.message").String()
+		}
+	}
+}
+
+// start 发送 message_start 事件
+func (t *ResponsesStreamTransformer) start(writer gin.ResponseWriter) {
+	if t.started {
+		return
+	}
+	t.started = true
+	t.sendEvent(writer, "message_start", map[string]any{
+		"type": "message_start",
+		"message": map[string]any{
+			"id":          t.messageID,
+			"type":        "message",
+			"role":        "assistant",
+			"model":       t.model,
+			"content":     []any{},
+			"stop_reason": nil,
+			"usage": map[string]int{
+				"input_tokens":  0,
+				"output_tokens": 0,
+			},
+		},
+	})
+}
+
+// isOpen 判断指定输出项的指定类型内容块是否正在输出
+func (t *ResponsesStreamTransformer) isOpen(outputIndex int64, blockType string) bool {
+	return t.blockOpen && t.openOutput == outputIndex && t.openType == blockType
+}
+
+// openBlock 开启新的内容块，已有其他内容块打开时先结束它
+func (t *ResponsesStreamTransformer) openBlock(writer gin.ResponseWriter, outputIndex int64, block ClaudeContentBlock, startBlock map[string]any) {
+	t.closeBlock(writer)
+	t.blockOpen = true
+	t.openOutput = outputIndex
+	t.openType = block.Type
+	t.content = append(t.content, block)
+	t.sendEvent(writer, "content_block_start", map[string]any{
+		"type":          "content_block_start",
+		"index":         t.blockIndex,
+		"content_block": startBlock,
+	})
+}
+
+// appendDelta 追加文本或推理摘要，必要时开启对应类型的内容块
+func (t *ResponsesStreamTransformer) appendDelta(writer gin.ResponseWriter, outputIndex int64, blockType, text string) {
+	if text == "" {
+		return
+	}
+	if !t.isOpen(outputIndex, blockType) {
+		if blockType == "thinking" {
+			t.openBlock(writer, outputIndex, thinkingBlock(""), map[string]any{"type": "thinking", "thinking": "", "signature": ""})
+		} else {
+			t.openBlock(writer, outputIndex, ClaudeContentBlock{Type: "text"}, map[string]any{"type": "text", "text": ""})
+		}
+	}
+
+	block := &t.content[len(t.content)-1]
+	delta := map[string]any{"type": "text_delta", "text": text}
+	if blockType == "thinking" {
+		block.Thinking += text
+		delta = map[string]any{"type": "thinking_delta", "thinking": text}
+	} else {
+		block.Text += text
+	}
+	t.sendEvent(writer, "content_block_delta", map[string]any{
+		"type":  "content_block_delta",
+		"index": t.blockIndex,
+		"delta": delta,
+	})
+}
+
+// startToolUse 开启函数调用对应的 tool_use 块
+func (t *ResponsesStreamTransformer) startToolUse(writer gin.ResponseWriter, outputIndex int64, item gjson.Result) {
+	callID := item.Get("call_id").String()
+	if callID == "" {
+		callID = "toolu_" + generateRandomID()
+	}
+	block := ClaudeContentBlock{Type: "tool_use", ID: callID, Name: item.Get("name").String()}
+	t.openBlock(writer, outputIndex, block, map[string]any{
+		"type":  "tool_use",
+		"id":    block.ID,
+		"name":  block.Name,
+		"input": map[string]any{},
+	})
+	t.toolArgs.Reset()
+	t.argsStreamed = false
+	t.stopReason = "tool_use"
+}
+
+// appendToolArguments 追加函数调用参数，以 input_json_delta 事件发送
+func (t *ResponsesStreamTransformer) appendToolArguments(writer gin.ResponseWriter, outputIndex int64, arguments string) {
+	if arguments == "" || !t.isOpen(outputIndex, "tool_use") {
+		return
+	}
+	t.argsStreamed = true
+	t.toolArgs.WriteString(arguments)
+	t.sendEvent(writer, "content_block_delta", map[string]any{
+		"type":  "content_block_delta",
+		"index": t.blockIndex,
+		"delta": map[string]any{"type": "input_json_delta", "partial_json": arguments},
+	})
+}
+
+// closeBlock 结束当前内容块，tool_use 块在结束时解析完整参数
+func (t *ResponsesStreamTransformer) closeBlock(writer gin.ResponseWriter) {
+	if !t.blockOpen {
+		return
+	}
+	if t.openType == "tool_use" {
+		var input map[string]any
+		if err := json.Unmarshal([]byte(t.toolArgs.String()), &input); err != nil || input == nil {
+			input = map[string]any{}
+		}
+		t.content[len(t.content)-1].Input = input
+	}
+	t.blockOpen = false
+	t.sendEvent(writer, "content_block_stop", map[string]any{
+		"type":  "content_block_stop",
+		"index": t.blockIndex,
+	})
+	t.blockIndex++
+}
+
+// complete 从最终的 response 对象中提取token使用统计和停止原因
+func (t *ResponsesStreamTransformer) complete(response gjson.Result) {
+	if usage := response.Get("usage"); usage.Exists() {
+		cached := int(usage.Get("input_tokens_details.cached_tokens").Int())
+		t.usage = &common.TokenUsage{
+			InputTokens:          int(usage.Get("input_tokens").Int()) - cached,
+			OutputTokens:         int(usage.Get("output_tokens").Int()),
+			CacheReadInputTokens: cached,
+			Model:                t.model,
+		}
+	}
+
+	switch response.Get("incomplete_details.reason").String() {
+	case "max_output_tokens":
+		t.stopReason = "max_tokens"
+	case "content_filter":
+		// 输出被上游内容过滤拦截，按请求错误返回给客户端
+		t.errorType = "invalid_request_error"
+		t.errorMessage = contentFilterOutputMessage
+	}
+}
+
+// finish 发送结束事件，非流式请求输出完整的Claude响应；上游中途失败时返回错误
+func (t *ResponsesStreamTransformer) finish(writer gin.ResponseWriter) {
+	t.start(writer)
+	t.closeBlock(writer)
+
+	if t.errorMessage != "" {
+		log.Printf("❌ Responses上游返回错误: %s", t.errorMessage)
+		errorType, statusCode := "api_error", http.StatusBadGateway
+		if t.errorType == "invalid_request_error" {
+			errorType, statusCode = t.errorType, http.StatusBadRequest
+		}
+		errorBody := map[string]any{
+			"type":  "error",
+			"error": map[string]any{"type": errorType, "message": t.errorMessage},
+		}
+		if t.isClientStream {
+			t.sendEvent(writer, "error", errorBody)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(statusCode)
+		jsonBytes, _ := json.Marshal(errorBody)
+		_, _ = writer.Write(jsonBytes)
+		return
+	}
+
+	stopReason := t.stopReason
+	if stopReason == "" {
+		stopReason = "end_turn"
+	}
+	// 与计费一致，缓存命中的输入token单独作为 cache_read_input_tokens 返回，不计入 input_tokens
+	usage := ClaudeUsage{}
+	if t.usage != nil {
+		usage.InputTokens = t.usage.InputTokens
+		usage.OutputTokens = t.usage.OutputTokens
+		usage.CacheReadInputTokens = t.usage.CacheReadInputTokens
+	}
+
+	if t.isClientStream {
+		t.sendEvent(writer, "message_delta", map[string]any{
+			"type":  "message_delta",
+			"delta": map[string]any{"stop_reason": stopReason, "stop_sequence": nil},
+			"usage": usage,
+		})
+		t.sendEvent(writer, "message_stop", map[string]any{"type": "message_stop"})
+		return
+	}
+
+	content := t.content
+	if content == nil {
+		content = []ClaudeContentBlock{}
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	jsonBytes, _ := json.Marshal(ClaudeResponse{
+		ID:         t.messageID,
+		Type:       "message",
+		Role:       "assistant",
+		Model:      t.model,
+		Content:    content,
+		StopReason: stopReason,
+		Usage:      usage,
+	})
+	_, _ = writer.Write(jsonBytes)
+}
+
+// sendEvent 客户端为流式请求时发送SSE事件
+func (t *ResponsesStreamTransformer) sendEvent(writer gin.ResponseWriter, eventType string, data any) {
+	if !t.isClientStream {
+		return
+	}
+	jsonData, _ := json.Marshal(data)
+	fmt.Fprintf(writer, "event: %s\ndata: %s\n\n", eventType, jsonData)
+	writer.Flush()
+}
@@ -0,0 +1,39 @@
+package relay
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+func TestResponsesStreamTransformerCacheReadUsage(t *testing.T) {
+	upstream := `data: {"type":"response.output_text.delta","output_index":0,"delta":"hi"}
+
+data: {"type":"response.completed","response":{"usage":{"input_tokens":100,"input_tokens_details":{"cached_tokens":40},"output_tokens":10}}}
+
+data: [DONE]
+`
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+
+	usage := newResponsesStreamTransformer("claude-sonnet-4-20250514", false).process(c, strings.NewReader(upstream))
+	if usage == nil || usage.InputTokens != 60 || usage.CacheReadInputTokens != 40 || usage.OutputTokens != 10 {
+		t.Fatalf("billing usage = %+v, want input 60, cache read 40, output 10", usage)
+	}
+
+	// 返回给客户端的用量与计费一致，缓存命中的token不重复计入 input_tokens
+	body := recorder.Body.Bytes()
+	for path, want := range map[string]int64{
+		"usage.input_tokens":            60,
+		"usage.cache_read_input_tokens": 40,
+		"usage.output_tokens":           10,
+	} {
+		if got := gjson.GetBytes(body, path).Int(); got != want {
+			t.Errorf("%s = %d, want %d in %s", path, got, want, body)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package relay
+
+import (
+	"claude-code-relay/constant"
+	"encoding/json"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestApplyThinkingParam(t *testing.T) {
+	enabled := &ClaudeThinking{Type: "enabled", BudgetTokens: 2048}
+	tests := []struct {
+		name          string
+		thinkingParam string
+		thinking      *ClaudeThinking
+		want          map[string]string
+	}{
+		{name: "not configured", thinking: enabled, want: map[string]string{}},
+		{name: "reasoning effort", thinkingParam: constant.ThinkingParamReasoningEffort, thinking: enabled, want: map[string]string{"reasoning_effort": "low"}},
+		{name: "reasoning effort without thinking", thinkingParam: constant.ThinkingParamReasoningEffort, want: map[string]string{}},
+		{name: "qwen enabled", thinkingParam: constant.ThinkingParamEnableThinking, thinking: enabled, want: map[string]string{"enable_thinking": "true"}},
+		{name: "qwen disabled", thinkingParam: constant.ThinkingParamEnableThinking, want: map[string]string{"enable_thinking": "false"}},
+		{name: "glm enabled", thinkingParam: constant.ThinkingParamThinkingType, thinking: enabled, want: map[string]string{"thinking.type": "enabled"}},
+		{name: "glm disabled", thinkingParam: constant.ThinkingParamThinkingType, thinking: &ClaudeThinking{Type: "disabled"}, want: map[string]string{"thinking.type": "disabled"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openaiReq := OpenAIRequest{Model: "m"}
+			applyThinkingParam(&openaiReq, tt.thinkingParam, tt.thinking)
+			body, err := json.Marshal(openaiReq)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, path := range []string{"reasoning_effort", "enable_thinking", "thinking.type"} {
+				got := gjson.GetBytes(body, path)
+				want, ok := tt.want[path]
+				if got.Exists() != ok || got.String() != want {
+					t.Errorf("%s = %q (exists %v), want %q (exists %v)", path, got.String(), got.Exists(), want, ok)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyResponsesReasoning(t *testing.T) {
+	enabled := &ClaudeThinking{Type: "enabled", BudgetTokens: 32000}
+
+	responsesReq := ResponsesRequest{}
+	applyResponsesReasoning(&responsesReq, "", enabled)
+	if responsesReq.Reasoning != nil {
+		t.Errorf("reasoning = %+v, want nil when the account has no thinking param", responsesReq.Reasoning)
+	}
+
+	applyResponsesReasoning(&responsesReq, constant.ThinkingParamReasoningEffort, enabled)
+	if responsesReq.Reasoning == nil || responsesReq.Reasoning.Effort != "high" || responsesReq.Reasoning.Summary != "auto" {
+		t.Errorf("reasoning = %+v, want high effort with auto summary", responsesReq.Reasoning)
+	}
+}
+
+func TestConvertOpenAIToClaudeResponseThinkingSignature(t *testing.T) {
+	var openaiResp OpenAIResponse
+	err := json.Unmarshal([]byte(`{"choices":[{"message":{"role":"assistant","reasoning_content":"let me think","content":"answer"},"finish_reason":"stop"}]}`), &openaiResp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(convertOpenAIToClaudeResponse(openaiResp, "claude-sonnet-4-20250514"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 与流式响应的 content_block_start 一致，thinking 块带空签名，text 块不带签名
+	thinking := gjson.GetBytes(body, "content.0")
+	if thinking.Get("type").String() != "thinking" || thinking.Get("thinking").String() != "let me think" {
+		t.Fatalf("content.0 = %s, want thinking block", thinking.Raw)
+	}
+	if signature := thinking.Get("signature"); !signature.Exists() || signature.String() != "" {
+		t.Errorf("thinking signature = %s, want empty string", signature.Raw)
+	}
+	if gjson.GetBytes(body, "content.1.signature").Exists() {
+		t.Errorf("text block should not carry a signature: %s", gjson.GetBytes(body, "content.1").Raw)
+	}
+}
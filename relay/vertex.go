@@ -0,0 +1,324 @@
+package relay
+
+import (
+	"bytes"
+	"claude-code-relay/common"
+	"claude-code-relay/model"
+	"claude-code-relay/service"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// Vertex 要求请求体中携带的 anthropic_version
+const vertexAnthropicVersion = "vertex-2023-10-16"
+
+// HandleVertexRequest 处理 Google Vertex AI 平台的请求
+// 请求体去掉模型字段并加上 anthropic_version 后调用 streamRawPredict，响应本身就是Claude SSE
+func HandleVertexRequest(c *gin.Context, account *model.Account) *RelayResult {
+	startTime := time.Now()
+
+	var apiKey *model.ApiKey
+	if keyInfo, exists := c.Get("api_key"); exists {
+		apiKey = keyInfo.(*model.ApiKey)
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": map[string]any{
+				"type":    "request_body_error",
+				"message": "Failed to read request body: " + err.Error(),
+			},
+		})
+		return failedResult(http.StatusBadRequest, err.Error())
+	}
+
+	modelName := gjson.GetBytes(body, "model").String()
+	vertexModel := applyModelMapping(modelName, account.ModelMapping, modelName)
+	vertexBody, err := buildVertexRequestBody(body, true)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": map[string]any{
+				"type":    "invalid_request_error",
+				"message": "Failed to build Vertex request: " + err.Error(),
+			},
+		})
+		return failedResult(http.StatusBadRequest, err.Error())
+	}
+
+	accessToken, err := GetVertexAccessToken(account)
+	if err != nil {
+		if CanFailover(c) {
+			return retryableResult(http.StatusUnauthorized, "获取Vertex访问令牌失败: "+err.Error())
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": map[string]any{
+				"type":    "authentication_error",
+				"message": "Failed to get Vertex access token: " + err.Error(),
+			},
+		})
+		return failedResult(http.StatusUnauthorized, err.Error())
+	}
+
+	betas := resolveBetas(c, account, nil, nil)
+	req, err := createVertexRequest(c.Request.Context(), account, vertexModel, true, vertexBody, accessToken, betas)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": map[string]any{
+				"type":    "internal_server_error",
+				"message": "Failed to create request: " + err.Error(),
+			},
+		})
+		return failedResult(http.StatusInternalServerError, err.Error())
+	}
+
+	client, err := createVertexHTTPClient(account, parseHTTPTimeout())
+	if err != nil {
+		if CanFailover(c) {
+			return retryableResult(0, "代理配置错误")
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": map[string]any{
+				"type":    "proxy_configuration_error",
+				"message": "Invalid proxy URI: " + err.Error(),
+			},
+		})
+		return failedResult(http.StatusInternalServerError, err.Error())
+	}
+
+	resp, err := doWithBetaRetry(client, req, betas, nil, func(betas []string) (*http.Request, error) {
+		return createVertexRequest(c.Request.Context(), account, vertexModel, true, vertexBody, accessToken, betas)
+	})
+	if err != nil {
+		log.Printf("Vertex API request failed: %v", err)
+		if !errors.Is(err, context.Canceled) && CanFailover(c) {
+			return retryableResult(0, "请求失败: "+err.Error())
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": map[string]any{
+				"type":    "network_error",
+				"message": "Failed to execute request: " + err.Error(),
+			},
+		})
+		return failedResult(0, err.Error())
+	}
+	defer common.CloseIO(resp.Body)
+
+	accountService := service.NewAccountService()
+	if resp.StatusCode >= 400 {
+		if resp.StatusCode == http.StatusUnauthorized {
+			InvalidateVertexAccessToken(account.ID)
+		}
+		accountService.UpdateAccountStatus(account, resp.StatusCode, nil)
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		log.Printf("❌ Vertex 错误响应内容: %s", string(bodyBytes))
+		reason := "上游返回状态码 " + strconv.Itoa(resp.StatusCode)
+		if isRetryableStatus(resp.StatusCode) && CanFailover(c) {
+			return retryableResult(resp.StatusCode, reason)
+		}
+		c.JSON(resp.StatusCode, vertexErrorResponse(resp.StatusCode, bodyBytes))
+		return failedResult(resp.StatusCode, reason)
+	}
+
+	c.Status(resp.StatusCode)
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Writer.Flush()
+
+	usageTokens, err := common.ParseStreamResponse(c.Writer, resp.Body)
+	if err != nil {
+		log.Println("Vertex stream copy and parse failed:", err.Error())
+	}
+	if usageTokens != nil && usageTokens.Model == "" {
+		usageTokens.Model = modelName
+	}
+
+	if shouldUpdateAccountStats(c, resp.StatusCode) {
+		go accountService.UpdateAccountStatus(account, resp.StatusCode, usageTokens)
+	}
+
+	if apiKey != nil && claimBilling(c, resp.StatusCode) {
+		go service.UpdateApiKeyStatus(apiKey, resp.StatusCode, usageTokens)
+		saveRequestLog(startTime, apiKey, account, resp.StatusCode, usageTokens, true, extractClientInfo(c))
+	}
+	return &RelayResult{StatusCode: resp.StatusCode}
+}
+
+// buildVertexRequestBody 将Claude请求体转换为 Vertex 格式：模型在URL中指定，需携带 anthropic_version
+func buildVertexRequestBody(body []byte, stream bool) ([]byte, error) {
+	body, err := sjson.DeleteBytes(body, "model")
+	if err != nil {
+		return nil, err
+	}
+	if body, err = sjson.SetBytes(body, "stream", stream); err != nil {
+		return nil, err
+	}
+	if !gjson.GetBytes(body, "anthropic_version").Exists() {
+		if body, err = sjson.SetBytes(body, "anthropic_version", vertexAnthropicVersion); err != nil {
+			return nil, err
+		}
+	}
+	return body, nil
+}
+
+// vertexBaseURL 获取 Vertex AI 地址，账号配置了请求地址时使用账号的地址（便于接入代理或本地桩服务）
+func vertexBaseURL(account *model.Account) string {
+	if baseURL := strings.TrimRight(account.RequestURL, "/"); baseURL != "" {
+		return baseURL
+	}
+	if account.Region == "global" {
+		return "https://aiplatform.googleapis.com"
+	}
+	return "https://" + account.Region + "-aiplatform.googleapis.com"
+}
+
+// createVertexRequest 创建Vertex请求，stream 为true时调用 streamRawPredict，beta 通过 anthropic-beta 请求头传递
+func createVertexRequest(ctx context.Context, account *model.Account, vertexModel string, stream bool, body []byte, accessToken string, betas []string) (*http.Request, error) {
+	sa, err := ParseVertexServiceAccount(account.SecretKey)
+	if err != nil {
+		return nil, err
+	}
+	if account.Region == "" {
+		return nil, errors.New("vertex account requires region")
+	}
+
+	method := ":rawPredict"
+	if stream {
+		method = ":streamRawPredict"
+	}
+	requestURL := vertexBaseURL(account) + "/v1/projects/" + url.PathEscape(sa.ProjectID) +
+		"/locations/" + url.PathEscape(account.Region) +
+		"/publishers/anthropic/models/" + url.PathEscape(vertexModel) + method
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, requestURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	setBetaHeader(req, betas)
+	if stream {
+		req.Header.Set("Accept", "text/event-stream")
+	}
+	return req, nil
+}
+
+// createVertexHTTPClient 创建Vertex HTTP客户端
+func createVertexHTTPClient(account *model.Account, timeout time.Duration) (*http.Client, error) {
+	transport := &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+
+	if account.ProxyURI != "" {
+		proxyURL, err := url.Parse(account.ProxyURI)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+	}, nil
+}
+
+// vertexErrorResponse 将 Vertex 错误响应转换为Claude错误格式
+// 模型返回的错误已是Claude格式，原样保留；Google API 网关的错误（{"error": {"code", "message", "status"}}）按状态码转换
+func vertexErrorResponse(statusCode int, body []byte) gin.H {
+	if errorType := gjson.GetBytes(body, "error.type"); errorType.Exists() {
+		return gin.H{
+			"type": "error",
+			"error": map[string]any{
+				"type":    errorType.String(),
+				"message": gjson.GetBytes(body, "error.message").String(),
+			},
+		}
+	}
+
+	errorType := "api_error"
+	switch statusCode {
+	case http.StatusBadRequest:
+		errorType = "invalid_request_error"
+	case http.StatusUnauthorized:
+		errorType = "authentication_error"
+	case http.StatusForbidden:
+		errorType = "permission_error"
+	case http.StatusNotFound:
+		errorType = "not_found_error"
+	case http.StatusTooManyRequests:
+		errorType = "rate_limit_error"
+	}
+
+	message := gjson.GetBytes(body, "error.message").String()
+	if message == "" {
+		message = gjson.GetBytes(body, "0.error.message").String()
+	}
+	if message == "" {
+		message = "Request failed with status " + strconv.Itoa(statusCode)
+	}
+
+	return gin.H{
+		"type": "error",
+		"error": map[string]any{
+			"type":    errorType,
+			"message": message,
+		},
+	}
+}
+
+// TestHandleVertexRequest 测试Vertex账号连通性（包括服务账号换取令牌）
+func TestHandleVertexRequest(account *model.Account) (int, string) {
+	testBody := GetTestRequestBody(1024)
+	body, err := buildVertexRequestBody([]byte(testBody), false)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to build request: " + err.Error()
+	}
+	modelName := gjson.Get(testBody, "model").String()
+	vertexModel := applyModelMapping(modelName, account.ModelMapping, modelName)
+
+	accessToken, err := GetVertexAccessToken(account)
+	if err != nil {
+		return http.StatusUnauthorized, "Failed to get access token: " + err.Error()
+	}
+
+	req, err := createVertexRequest(context.Background(), account, vertexModel, false, body, accessToken, nil)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to create request: " + err.Error()
+	}
+
+	client, err := createVertexHTTPClient(account, parseHTTPTimeout())
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to create HTTP client: " + err.Error()
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return http.StatusInternalServerError, "Request failed: " + err.Error()
+	}
+	defer common.CloseIO(resp.Body)
+
+	if resp.StatusCode >= 400 {
+		if resp.StatusCode == http.StatusUnauthorized {
+			InvalidateVertexAccessToken(account.ID)
+		}
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		errorInfo, _ := vertexErrorResponse(resp.StatusCode, bodyBytes)["error"].(map[string]any)
+		message, _ := errorInfo["message"].(string)
+		return resp.StatusCode, message
+	}
+	return resp.StatusCode, ""
+}
@@ -0,0 +1,205 @@
+package relay
+
+import (
+	"claude-code-relay/common"
+	"claude-code-relay/model"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// VertexDefaultTokenURL 服务账号未指定 token_uri 时使用的令牌地址
+	VertexDefaultTokenURL = "https://oauth2.googleapis.com/token"
+	// Vertex AI 调用所需的 OAuth scope
+	vertexOAuthScope = "https://www.googleapis.com/auth/cloud-platform"
+	// JWT 断言的有效期
+	vertexAssertionLifetime = time.Hour
+	// 访问令牌距离过期不足该时长时重新获取
+	vertexTokenRefreshBuffer = 5 * time.Minute
+)
+
+// VertexServiceAccount Google Cloud 服务账号密钥文件中使用到的字段
+type VertexServiceAccount struct {
+	Type         string `json:"type"`
+	ProjectID    string `json:"project_id"`
+	PrivateKeyID string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	ClientEmail  string `json:"client_email"`
+	TokenURI     string `json:"token_uri"`
+}
+
+// vertexTokenEntry 单个账号缓存的访问令牌，mu 保证同一账号同时只有一个请求在获取令牌
+type vertexTokenEntry struct {
+	mu          sync.Mutex
+	fingerprint string // 服务账号密钥的摘要，密钥变更后缓存失效
+	accessToken string
+	expiresAt   time.Time
+}
+
+var (
+	vertexTokensMu sync.Mutex
+	vertexTokens   = make(map[uint]*vertexTokenEntry)
+)
+
+// ParseVertexServiceAccount 解析服务账号JSON并校验必需字段
+func ParseVertexServiceAccount(data string) (*VertexServiceAccount, error) {
+	var sa VertexServiceAccount
+	if err := json.Unmarshal([]byte(data), &sa); err != nil {
+		return nil, fmt.Errorf("服务账号JSON格式错误: %v", err)
+	}
+	if sa.ClientEmail == "" || sa.PrivateKey == "" || sa.ProjectID == "" {
+		return nil, errors.New("服务账号JSON缺少 client_email、private_key 或 project_id")
+	}
+	if sa.TokenURI == "" {
+		sa.TokenURI = VertexDefaultTokenURL
+	}
+	return &sa, nil
+}
+
+// GetVertexAccessToken 获取账号的 Vertex 访问令牌，缓存的令牌即将过期时使用服务账号重新获取
+// 同一账号的并发请求只会发起一次令牌交换，其余请求等待并复用结果
+func GetVertexAccessToken(account *model.Account) (string, error) {
+	entry := vertexTokenEntryFor(account.ID)
+	fingerprint := fmt.Sprintf("%x", sha256.Sum256([]byte(account.SecretKey)))
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	if entry.fingerprint == fingerprint && time.Until(entry.expiresAt) > vertexTokenRefreshBuffer {
+		return entry.accessToken, nil
+	}
+
+	sa, err := ParseVertexServiceAccount(account.SecretKey)
+	if err != nil {
+		return "", err
+	}
+	accessToken, expiresAt, err := exchangeVertexToken(account, sa)
+	if err != nil {
+		log.Printf("账号 %s 获取Vertex访问令牌失败: %v", account.Name, err)
+		return "", err
+	}
+
+	entry.fingerprint = fingerprint
+	entry.accessToken = accessToken
+	entry.expiresAt = expiresAt
+	log.Printf("账号 %s Vertex访问令牌获取成功，将于 %s 过期", account.Name, expiresAt.Format(time.DateTime))
+	return accessToken, nil
+}
+
+// InvalidateVertexAccessToken 丢弃账号缓存的访问令牌（如上游返回401），下次请求重新获取
+func InvalidateVertexAccessToken(accountID uint) {
+	entry := vertexTokenEntryFor(accountID)
+	entry.mu.Lock()
+	entry.expiresAt = time.Time{}
+	entry.mu.Unlock()
+}
+
+// vertexTokenEntryFor 获取账号的令牌缓存项，不存在时创建
+func vertexTokenEntryFor(accountID uint) *vertexTokenEntry {
+	vertexTokensMu.Lock()
+	defer vertexTokensMu.Unlock()
+	entry, ok := vertexTokens[accountID]
+	if !ok {
+		entry = &vertexTokenEntry{}
+		vertexTokens[accountID] = entry
+	}
+	return entry
+}
+
+// exchangeVertexToken 使用服务账号签发的JWT断言换取访问令牌（RFC 7523 jwt-bearer）
+func exchangeVertexToken(account *model.Account, sa *VertexServiceAccount) (string, time.Time, error) {
+	assertion, err := signVertexAssertion(sa, time.Now())
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+	form.Set("assertion", assertion)
+
+	req, err := http.NewRequest(http.MethodPost, sa.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("创建令牌请求失败: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	client, err := createVertexHTTPClient(account, 30*time.Second)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("令牌请求失败: %v", err)
+	}
+	defer common.CloseIO(resp.Body)
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("读取令牌响应失败: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", time.Time{}, fmt.Errorf("获取令牌失败，状态码: %d, 响应: %s", resp.StatusCode, string(body))
+	}
+
+	var tokenResp struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &tokenResp); err != nil {
+		return "", time.Time{}, fmt.Errorf("解析令牌响应失败: %v", err)
+	}
+	if tokenResp.AccessToken == "" {
+		return "", time.Time{}, errors.New("令牌响应中缺少access_token")
+	}
+	return tokenResp.AccessToken, time.Now().Add(time.Duration(tokenResp.ExpiresIn) * time.Second), nil
+}
+
+// signVertexAssertion 使用服务账号私钥签发 RS256 JWT 断言
+func signVertexAssertion(sa *VertexServiceAccount, now time.Time) (string, error) {
+	block, _ := pem.Decode([]byte(sa.PrivateKey))
+	if block == nil {
+		return "", errors.New("服务账号私钥不是有效的PEM格式")
+	}
+	parsedKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		parsedKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return "", fmt.Errorf("解析服务账号私钥失败: %v", err)
+		}
+	}
+	privateKey, ok := parsedKey.(*rsa.PrivateKey)
+	if !ok {
+		return "", errors.New("服务账号私钥不是RSA密钥")
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": sa.PrivateKeyID})
+	claims, _ := json.Marshal(map[string]any{
+		"iss":   sa.ClientEmail,
+		"scope": vertexOAuthScope,
+		"aud":   sa.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(vertexAssertionLifetime).Unix(),
+	})
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(nil, privateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("签名JWT失败: %v", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
@@ -0,0 +1,183 @@
+package relay
+
+import (
+	"claude-code-relay/model"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+const vertexTestClientEmail = "relay@test-project.iam.gserviceaccount.com"
+
+// vertexTokenServer 校验 jwt-bearer 断言并签发访问令牌的桩服务
+type vertexTokenServer struct {
+	*httptest.Server
+	publicKey *rsa.PublicKey
+	expiresIn int
+	exchanges atomic.Int32
+}
+
+// newVertexTokenServer 创建令牌桩服务，返回桩服务及使用测试密钥的服务账号JSON
+func newVertexTokenServer(t *testing.T, expiresIn int) (*vertexTokenServer, string) {
+	t.Helper()
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := &vertexTokenServer{publicKey: &privateKey.PublicKey, expiresIn: expiresIn}
+	ts.Server = httptest.NewServer(http.HandlerFunc(ts.serveToken(t)))
+	t.Cleanup(ts.Close)
+
+	serviceAccount, _ := json.Marshal(VertexServiceAccount{
+		Type:         "service_account",
+		ProjectID:    "test-project",
+		PrivateKeyID: "test-key-id",
+		PrivateKey:   string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		ClientEmail:  vertexTestClientEmail,
+		TokenURI:     ts.URL + "/token",
+	})
+	return ts, string(serviceAccount)
+}
+
+func (ts *vertexTokenServer) serveToken(t *testing.T) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := ts.verifyAssertion(r); err != nil {
+			t.Errorf("token request rejected: %v", err)
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		n := ts.exchanges.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"token-%d","expires_in":%d,"token_type":"Bearer"}`, n, ts.expiresIn)
+	}
+}
+
+// verifyAssertion 校验令牌请求中的 JWT 断言：签名、iss、aud、scope 和有效期
+func (ts *vertexTokenServer) verifyAssertion(r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	if grantType := r.PostForm.Get("grant_type"); grantType != "urn:ietf:params:oauth:grant-type:jwt-bearer" {
+		return fmt.Errorf("grant_type = %q", grantType)
+	}
+	parts := strings.Split(r.PostForm.Get("assertion"), ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("assertion has %d parts, want 3", len(parts))
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(ts.publicKey, crypto.SHA256, digest[:], signature); err != nil {
+		return fmt.Errorf("signature: %v", err)
+	}
+
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return err
+	}
+	if alg, kid := gjson.GetBytes(header, "alg").String(), gjson.GetBytes(header, "kid").String(); alg != "RS256" || kid != "test-key-id" {
+		return fmt.Errorf("header alg = %q, kid = %q", alg, kid)
+	}
+
+	claims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return err
+	}
+	checks := map[string]string{
+		"iss":   vertexTestClientEmail,
+		"aud":   ts.URL + "/token",
+		"scope": vertexOAuthScope,
+	}
+	for claim, want := range checks {
+		if got := gjson.GetBytes(claims, claim).String(); got != want {
+			return fmt.Errorf("claim %s = %q, want %q", claim, got, want)
+		}
+	}
+	if lifetime := gjson.GetBytes(claims, "exp").Int() - gjson.GetBytes(claims, "iat").Int(); lifetime != int64(vertexAssertionLifetime.Seconds()) {
+		return fmt.Errorf("assertion lifetime = %ds", lifetime)
+	}
+	return nil
+}
+
+func TestGetVertexAccessToken(t *testing.T) {
+	ts, serviceAccount := newVertexTokenServer(t, 3600)
+	account := &model.Account{ID: 9201, Name: "vertex-test", SecretKey: serviceAccount}
+
+	for i := 0; i < 3; i++ {
+		token, err := GetVertexAccessToken(account)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "token-1" {
+			t.Errorf("call %d token = %q, want cached token-1", i, token)
+		}
+	}
+	if n := ts.exchanges.Load(); n != 1 {
+		t.Errorf("token exchanges = %d, want 1", n)
+	}
+
+	// 上游返回401后丢弃缓存，下次请求重新获取
+	InvalidateVertexAccessToken(account.ID)
+	if token, err := GetVertexAccessToken(account); err != nil || token != "token-2" {
+		t.Errorf("token after invalidation = %q, %v, want token-2", token, err)
+	}
+}
+
+func TestGetVertexAccessTokenRefreshesBeforeExpiry(t *testing.T) {
+	// 令牌有效期短于刷新提前量，尚未过期也应在每次请求时重新获取
+	ts, serviceAccount := newVertexTokenServer(t, int((vertexTokenRefreshBuffer - time.Minute).Seconds()))
+	account := &model.Account{ID: 9202, Name: "vertex-test", SecretKey: serviceAccount}
+
+	for i := 1; i <= 2; i++ {
+		token, err := GetVertexAccessToken(account)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("token-%d", i); token != want {
+			t.Errorf("call %d token = %q, want %q", i, token, want)
+		}
+	}
+	if n := ts.exchanges.Load(); n != 2 {
+		t.Errorf("token exchanges = %d, want 2", n)
+	}
+}
+
+func TestGetVertexAccessTokenServiceAccountChange(t *testing.T) {
+	_, firstServiceAccount := newVertexTokenServer(t, 3600)
+	second, secondServiceAccount := newVertexTokenServer(t, 3600)
+	account := &model.Account{ID: 9203, Name: "vertex-test", SecretKey: firstServiceAccount}
+
+	if _, err := GetVertexAccessToken(account); err != nil {
+		t.Fatal(err)
+	}
+	// 更换服务账号后缓存的令牌失效，使用新的服务账号获取
+	account.SecretKey = secondServiceAccount
+	if token, err := GetVertexAccessToken(account); err != nil || token != "token-1" {
+		t.Errorf("token after key change = %q, %v, want token-1 from the new token endpoint", token, err)
+	}
+	if n := second.exchanges.Load(); n != 1 {
+		t.Errorf("new token endpoint exchanges = %d, want 1", n)
+	}
+}
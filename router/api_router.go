@@ -74,6 +74,7 @@ func SetAPIRouter(server *gin.Engine) {
 				account.PUT("/update-active-status/:id", controller.UpdateAccountActiveStatus)   // 更新账号激活状态
 				account.PUT("/update-current-status/:id", controller.UpdateAccountCurrentStatus) // 更新账号当前状态
 				account.POST("/test/:id", controller.TestGetMessages)                            // 测试账号连通性
+				account.GET("/health", controller.GetAccountsHealth)                             // 获取账号健康度
 			}
 
 			// Claude OAuth 相关
@@ -119,12 +120,13 @@ func SetAPIRouter(server *gin.Engine) {
 				// 日志管理（管理员专用）
 				adminLogs := admin.Group("/logs")
 				{
-					adminLogs.GET("/list", controller.GetLogs)                 // 获取所有日志列表（支持筛选）
-					adminLogs.GET("/stats", controller.GetLogStats)            // 获取日志统计（支持指定用户）
-					adminLogs.GET("/usage-stats", controller.GetUsageStats)    // 获取使用统计（管理员可查看所有用户）
-					adminLogs.GET("/detail/:id", controller.GetLogById)        // 获取日志详情
-					adminLogs.DELETE("/delete/:id", controller.DeleteLogById)  // 删除指定日志
-					adminLogs.DELETE("/cleanup", controller.DeleteExpiredLogs) // 删除过期日志
+					adminLogs.GET("/list", controller.GetLogs)                          // 获取所有日志列表（支持筛选）
+					adminLogs.GET("/stats", controller.GetLogStats)                     // 获取日志统计（支持指定用户）
+					adminLogs.GET("/usage-stats", controller.GetUsageStats)             // 获取使用统计（管理员可查看所有用户）
+					adminLogs.GET("/detail/:id", controller.GetLogById)                 // 获取日志详情
+					adminLogs.DELETE("/delete/:id", controller.DeleteLogById)           // 删除指定日志
+					adminLogs.DELETE("/cleanup", controller.DeleteExpiredLogs)          // 删除过期日志
+					adminLogs.GET("/attempts/:request_id", controller.GetRelayAttempts) // 获取请求的故障转移尝试记录
 				}
 
 				// 定时任务测试接口（管理员专用）
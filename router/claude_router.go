@@ -8,10 +8,33 @@ import (
 
 func SetClaudeCodeRouter(server *gin.Engine) {
 	claude := server.Group("/claude-code")
-	// api key 鉴权
-	claude.Use(middleware.ClaudeCodeAuth())
+
+	// 只校验 api key，不校验客户端策略：模型列表不转发请求，只返回 api key 可用的模型
+	apiKeyOnly := claude.Group("", middleware.ApiKeyAuth())
+	{
+		// 模型列表
+		apiKeyOnly.GET("/v1/models", controller.ListModels)
+	}
+
+	// OpenAI 兼容接口和批处理接口的客户端无法满足客户端策略，改为按 api key 的开关控制是否允许使用
+	compat := claude.Group("", middleware.CompatEndpointAuth())
+	{
+		// OpenAI Chat Completions 兼容接口
+		compat.POST("/v1/chat/completions", controller.ChatCompletions)
+		// 消息批处理接口
+		compat.POST("/v1/messages/batches", controller.CreateMessageBatch)
+		compat.GET("/v1/messages/batches", controller.ListMessageBatches)
+		compat.GET("/v1/messages/batches/:id", controller.GetMessageBatch)
+		compat.POST("/v1/messages/batches/:id/cancel", controller.CancelMessageBatch)
+		compat.GET("/v1/messages/batches/:id/results", controller.GetMessageBatchResults)
+	}
+
+	// api key 鉴权，并按 api key 的客户端策略校验请求来源
+	claude.Use(middleware.ClientPolicyAuth())
 	{
 		// 对话接口
 		claude.POST("/v1/messages", controller.GetMessages)
+		// 计算输入token数
+		claude.POST("/v1/messages/count_tokens", controller.CountTokens)
 	}
 }
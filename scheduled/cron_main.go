@@ -35,6 +35,8 @@ func (s *CronService) Start() error {
 		handler func() error
 	}{
 		"reset_daily":    {"0 0 0 * * *", s.resetDailyStats},
+		"reset_monthly":  {"0 0 0 1 * *", s.resetMonthlyStats},
+		"refresh_schedule": {"0 * * * * *", s.refreshAccountSchedules},
 		"clean_logs":     {"0 0 1 * * *", s.cleanExpiredLogs},
 		"recover_abnormal": {"0 */30 * * * *", s.recoverAbnormalAccounts},
 		"check_rate_limit": {"0 */10 * * * *", s.checkRateLimitExpiredAccounts},
@@ -83,6 +85,7 @@ func (s *CronService) resetDailyStats() error {
 	// 统一的重置字段
 	resetFields := map[string]any{
 		"today_usage_count":                 0,
+		"today_count_tokens_count":          0,
 		"today_input_tokens":                0,
 		"today_output_tokens":               0,
 		"today_cache_read_input_tokens":     0,
@@ -105,6 +108,51 @@ func (s *CronService) resetDailyStats() error {
 	return nil
 }
 
+// resetMonthlyStats 重置账号每月统计（月度预算随之恢复）
+func (s *CronService) resetMonthlyStats() error {
+	result := model.DB.Model(&model.Account{}).Where("1 = 1").Updates(map[string]any{
+		"month_tokens":     0,
+		"month_total_cost": 0,
+	})
+	if result.Error != nil {
+		return fmt.Errorf("重置账号月度统计失败: %w", result.Error)
+	}
+	common.SysLog(fmt.Sprintf("已重置 %d 个账号的月度统计数据", result.RowsAffected))
+	return nil
+}
+
+// refreshAccountSchedules 按账号的可用时间表标记账号进入或退出可用时间
+func (s *CronService) refreshAccountSchedules() error {
+	accounts, err := model.GetScheduledAccounts()
+	if err != nil {
+		return fmt.Errorf("查询设置了可用时间表的账号失败: %w", err)
+	}
+
+	now := time.Now()
+	var enterIDs, leaveIDs []uint
+	for _, acc := range accounts {
+		inSchedule := acc.CheckInSchedule(now)
+		if inSchedule == acc.InSchedule {
+			continue
+		}
+		if inSchedule {
+			enterIDs = append(enterIDs, acc.ID)
+			common.SysLog(fmt.Sprintf("账号 %s 进入可用时间", acc.Name))
+		} else {
+			leaveIDs = append(leaveIDs, acc.ID)
+			common.SysLog(fmt.Sprintf("账号 %s 退出可用时间", acc.Name))
+		}
+	}
+
+	if err := model.UpdateAccountsInSchedule(enterIDs, true); err != nil {
+		return fmt.Errorf("更新账号可用时间状态失败: %w", err)
+	}
+	if err := model.UpdateAccountsInSchedule(leaveIDs, false); err != nil {
+		return fmt.Errorf("更新账号可用时间状态失败: %w", err)
+	}
+	return nil
+}
+
 // cleanExpiredLogs 清理过期日志
 func (s *CronService) cleanExpiredLogs() error {
 	retentionMonths := getEnvInt("LOG_RETENTION_MONTHS", 3)
@@ -116,6 +164,11 @@ func (s *CronService) cleanExpiredLogs() error {
 	}
 	
 	common.SysLog(fmt.Sprintf("已清理 %d 条过期日志（%d个月前）", result.RowsAffected, retentionMonths))
+
+	result = model.DB.Where("created_at < ?", expiredDate).Delete(&model.RelayAttempt{})
+	if result.Error != nil {
+		return fmt.Errorf("清理中转尝试记录失败: %w", result.Error)
+	}
 	return nil
 }
 
@@ -207,8 +260,14 @@ func (s *CronService) testAccount(account *model.Account) bool {
 		statusCode, errMsg = relay.TestsHandleClaudeRequest(account)
 	case constant.PlatformClaudeConsole:
 		statusCode, errMsg = relay.TestHandleClaudeConsoleRequest(account)
-	case constant.PlatformOpenAI:
+	case constant.PlatformOpenAI, constant.PlatformAzureOpenAI:
 		statusCode, errMsg = relay.TestHandleOpenAIRequest(account)
+	case constant.PlatformGemini:
+		statusCode, errMsg = relay.TestHandleGeminiRequest(account)
+	case constant.PlatformBedrock:
+		statusCode, errMsg = relay.TestHandleBedrockRequest(account)
+	case constant.PlatformVertex:
+		statusCode, errMsg = relay.TestHandleVertexRequest(account)
 	default:
 		return false
 	}
@@ -256,6 +315,8 @@ func GetInstance() *CronService {
 func (s *CronService) ManualTrigger(taskName string) error {
 	tasks := map[string]func() error{
 		"reset_daily":     s.resetDailyStats,
+		"reset_monthly":   s.resetMonthlyStats,
+		"refresh_schedule": s.refreshAccountSchedules,
 		"clean_logs":      s.cleanExpiredLogs,
 		"recover_abnormal": s.recoverAbnormalAccounts,
 		"check_rate_limit": s.checkRateLimitExpiredAccounts,
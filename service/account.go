@@ -2,10 +2,14 @@ package service
 
 import (
 	"claude-code-relay/common"
+	"claude-code-relay/constant"
 	"claude-code-relay/model"
 	"errors"
 	"log"
+	"strings"
 	"time"
+
+	"github.com/tidwall/gjson"
 )
 
 type AccountService struct{}
@@ -28,6 +32,12 @@ func (s *AccountService) GetAccountList(page, limit int, userID *uint) (*model.A
 		return nil, errors.New("获取账号列表失败")
 	}
 
+	for i := range accounts {
+		accounts[i].CurrentConcurrency = GetAccountConcurrency(accounts[i].ID)
+		accounts[i].CircuitState = GetCircuitBreakerStatus(accounts[i].ID).State
+		accounts[i].Budget = accounts[i].GetBudget()
+	}
+
 	result := &model.AccountListResponse{
 		Accounts: accounts,
 		Total:    total,
@@ -38,6 +48,69 @@ func (s *AccountService) GetAccountList(page, limit int, userID *uint) (*model.A
 	return result, nil
 }
 
+// AccountHealthItem 账号健康度列表项
+type AccountHealthItem struct {
+	AccountID     uint                  `json:"account_id"`
+	Name          string                `json:"name"`
+	PlatformType  string                `json:"platform_type"`
+	GroupID       int                   `json:"group_id"`
+	Priority      int                   `json:"priority"`
+	CurrentStatus int                   `json:"current_status"`
+	Health        *AccountHealth        `json:"health"`
+	Circuit       *CircuitBreakerStatus `json:"circuit"`
+}
+
+// GetAccountsHealth 获取账号健康度列表
+func (s *AccountService) GetAccountsHealth(userID *uint) ([]AccountHealthItem, error) {
+	accounts, err := model.GetAccounts(userID)
+	if err != nil {
+		return nil, errors.New("获取账号列表失败")
+	}
+
+	items := make([]AccountHealthItem, 0, len(accounts))
+	for _, account := range accounts {
+		items = append(items, AccountHealthItem{
+			AccountID:     account.ID,
+			Name:          account.Name,
+			PlatformType:  account.PlatformType,
+			GroupID:       account.GroupID,
+			Priority:      account.Priority,
+			CurrentStatus: account.CurrentStatus,
+			Health:        GetAccountHealth(account.ID),
+			Circuit:       GetCircuitBreakerStatus(account.ID),
+		})
+	}
+	return items, nil
+}
+
+// GetAccountBudgets 获取设置了预算的账号及其剩余额度，userID 不为空时只返回该用户的账号
+func GetAccountBudgets(userID *uint) ([]model.AccountBudgetItem, error) {
+	accounts, err := model.GetBudgetedAccounts(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]model.AccountBudgetItem, 0, len(accounts))
+	for _, account := range accounts {
+		items = append(items, model.AccountBudgetItem{
+			AccountID:         account.ID,
+			AccountName:       account.Name,
+			PlatformType:      account.PlatformType,
+			GroupID:           account.GroupID,
+			TodayCost:         account.TodayTotalCost,
+			MonthCost:         account.MonthTotalCost,
+			TodayTokens:       account.TodayTokens(),
+			MonthTokens:       account.MonthTokens,
+			DailyCostLimit:    account.DailyCostLimit,
+			MonthlyCostLimit:  account.MonthlyCostLimit,
+			DailyTokenLimit:   account.DailyTokenLimit,
+			MonthlyTokenLimit: account.MonthlyTokenLimit,
+			Budget:            account.GetBudget(),
+		})
+	}
+	return items, nil
+}
+
 // CreateAccount 创建账号
 func (s *AccountService) CreateAccount(req *model.CreateAccountRequest, userID uint) (*model.Account, error) {
 	// 设置今日请求次数：获取同用户、同分组、同优先级可用账号的最大今日请求次数，然后减1
@@ -53,25 +126,53 @@ func (s *AccountService) CreateAccount(req *model.CreateAccountRequest, userID u
 		}
 	}
 
+	if err := validateAvailabilitySchedule(req.AvailabilitySchedule, req.ScheduleTimezone); err != nil {
+		return nil, err
+	}
+	if _, err := ParseBetaPolicy(req.BetaPolicy); err != nil {
+		return nil, err
+	}
+	if err := validatePlatformCredentials(req.PlatformType, req.Region, req.AccessKeyID, req.SecretKey); err != nil {
+		return nil, err
+	}
+
 	account := &model.Account{
-		Name:            req.Name,
-		PlatformType:    req.PlatformType,
-		RequestURL:      req.RequestURL,
-		SecretKey:       req.SecretKey,
-		GroupID:         req.GroupID,
-		Priority:        req.Priority,
-		Weight:          req.Weight,
-		EnableProxy:     req.EnableProxy,
-		ProxyURI:        req.ProxyURI,
-		ModelMapping:    req.ModelMapping,
-		ActiveStatus:    req.ActiveStatus,
-		IsMax:           req.IsMax,
-		AccessToken:     req.AccessToken,
-		RefreshToken:    req.RefreshToken,
-		ExpiresAt:       req.ExpiresAt,
-		TodayUsageCount: todayUsageCount,
-		UserID:          userID,
+		Name:              req.Name,
+		PlatformType:      req.PlatformType,
+		RequestURL:        req.RequestURL,
+		SecretKey:         req.SecretKey,
+		AccessKeyID:       req.AccessKeyID,
+		SessionToken:      req.SessionToken,
+		Region:            req.Region,
+		ApiVersion:        req.ApiVersion,
+		GroupID:           req.GroupID,
+		Priority:          req.Priority,
+		Weight:            req.Weight,
+		MaxConcurrency:    req.MaxConcurrency,
+		DailyCostLimit:    req.DailyCostLimit,
+		MonthlyCostLimit:  req.MonthlyCostLimit,
+		DailyTokenLimit:   req.DailyTokenLimit,
+		MonthlyTokenLimit: req.MonthlyTokenLimit,
+		EnableProxy:       req.EnableProxy,
+		ProxyURI:          req.ProxyURI,
+		ModelMapping:      req.ModelMapping,
+		BetaPolicy:        req.BetaPolicy,
+		UpstreamProtocol:  req.UpstreamProtocol,
+		ThinkingParam:     req.ThinkingParam,
+		SupportedModels:   req.SupportedModels,
+		ActiveStatus:      req.ActiveStatus,
+		IsMax:             req.IsMax,
+		AccessToken:       req.AccessToken,
+		RefreshToken:      req.RefreshToken,
+		ExpiresAt:         req.ExpiresAt,
+		TodayUsageCount:   todayUsageCount,
+		UserID:            userID,
+	}
+
+	if account.UpstreamProtocol == "" {
+		account.UpstreamProtocol = constant.UpstreamProtocolChatCompletions
 	}
+	account.InSchedule = account.CheckInSchedule(time.Now())
 
 	if err := model.CreateAccount(account); err != nil {
 		return nil, errors.New("创建账号失败")
@@ -80,6 +181,49 @@ func (s *AccountService) CreateAccount(req *model.CreateAccountRequest, userID u
 	return account, nil
 }
 
+// validatePlatformCredentials 校验Bedrock、Vertex账号必填的区域和凭证，其他平台不校验
+func validatePlatformCredentials(platformType, region, accessKeyID, secretKey string) error {
+	switch platformType {
+	case constant.PlatformBedrock:
+		if region == "" || accessKeyID == "" || secretKey == "" {
+			return errors.New("Bedrock账号需要填写区域、Access Key ID和Secret Access Key")
+		}
+	case constant.PlatformVertex:
+		return validateVertexAccount(region, secretKey)
+	}
+	return nil
+}
+
+// validateVertexAccount 校验Vertex账号的区域和服务账号JSON
+func validateVertexAccount(region, serviceAccount string) error {
+	if region == "" {
+		return errors.New("Vertex账号需要填写区域")
+	}
+	if !gjson.Valid(serviceAccount) {
+		return errors.New("Vertex账号的秘钥需要填写服务账号JSON")
+	}
+	for _, field := range []string{"client_email", "private_key", "project_id"} {
+		if gjson.Get(serviceAccount, field).String() == "" {
+			return errors.New("服务账号JSON缺少 " + field)
+		}
+	}
+	return nil
+}
+
+// validateAvailabilitySchedule 校验账号可用时间表配置
+func validateAvailabilitySchedule(spec, timezone string) error {
+	if strings.TrimSpace(spec) == "" {
+		if timezone != "" {
+			if _, err := time.LoadLocation(timezone); err != nil {
+				return errors.New("无效的时区: " + timezone)
+			}
+		}
+		return nil
+	}
+	_, err := common.ParseAvailabilitySchedule(spec, timezone)
+	return err
+}
+
 // GetAccountByID 根据ID获取账号详情
 func (s *AccountService) GetAccountByID(id uint, userID *uint) (*model.Account, error) {
 	account, err := model.GetAccountByID(id)
@@ -102,18 +246,50 @@ func (s *AccountService) UpdateAccount(id uint, req *model.UpdateAccountRequest,
 		return nil, err
 	}
 
+	if err := validateAvailabilitySchedule(req.AvailabilitySchedule, req.ScheduleTimezone); err != nil {
+		return nil, err
+	}
+	if _, err := ParseBetaPolicy(req.BetaPolicy); err != nil {
+		return nil, err
+	}
+	// 未填写秘钥时沿用原秘钥，平台类型变更时原秘钥不适用于新平台
+	secretKey := req.SecretKey
+	if secretKey == "" && account.PlatformType == req.PlatformType {
+		secretKey = account.SecretKey
+	}
+	if err := validatePlatformCredentials(req.PlatformType, req.Region, req.AccessKeyID, secretKey); err != nil {
+		return nil, err
+	}
+
 	// 更新字段
 	account.Name = req.Name
 	account.PlatformType = req.PlatformType
 	account.RequestURL = req.RequestURL
+	account.AccessKeyID = req.AccessKeyID
+	account.Region = req.Region
+	account.ApiVersion = req.ApiVersion
 	if req.GroupID != nil {
 		account.GroupID = *req.GroupID
 	}
 	account.Priority = req.Priority
 	account.Weight = req.Weight
+	account.MaxConcurrency = req.MaxConcurrency
+	account.DailyCostLimit = req.DailyCostLimit
+	account.MonthlyCostLimit = req.MonthlyCostLimit
+	account.DailyTokenLimit = req.DailyTokenLimit
+	account.MonthlyTokenLimit = req.MonthlyTokenLimit
 	account.EnableProxy = req.EnableProxy
 	account.ProxyURI = req.ProxyURI
 	account.ModelMapping = req.ModelMapping
+	account.BetaPolicy = req.BetaPolicy
+	if req.UpstreamProtocol != "" {
+		account.UpstreamProtocol = req.UpstreamProtocol
+	}
+	account.ThinkingParam = req.ThinkingParam
+	account.SupportedModels = req.SupportedModels
+	account.AvailabilitySchedule = req.AvailabilitySchedule
+	account.ScheduleTimezone = req.ScheduleTimezone
+	account.InSchedule = account.CheckInSchedule(time.Now())
 	account.ActiveStatus = req.ActiveStatus
 	account.IsMax = req.IsMax
 
@@ -121,6 +297,10 @@ func (s *AccountService) UpdateAccount(id uint, req *model.UpdateAccountRequest,
 		account.SecretKey = req.SecretKey
 	}
 
+	if req.SessionToken != "" {
+		account.SessionToken = req.SessionToken
+	}
+
 	if req.AccessToken != "" {
 		account.AccessToken = req.AccessToken
 	}
@@ -215,6 +395,11 @@ func (s *AccountService) UpdateAccountCurrentStatus(id uint, currentStatus int,
 		return errors.New("更新账号当前状态失败")
 	}
 
+	// 手动恢复为正常状态时同时重置熔断器
+	if currentStatus == 1 {
+		ResetCircuitBreaker(account.ID)
+	}
+
 	return nil
 }
 
@@ -225,9 +410,12 @@ func (s *AccountService) UpdateAccountStatus(account *model.Account, statusCode
 	case statusCode == 429:
 		// 限流状态
 		account.CurrentStatus = 3
-	case statusCode >= 400:
-		// 接口异常
+	case statusCode == 401 || statusCode == 403:
+		// 认证失败或账号被禁用，重试无法恢复，标记为接口异常
 		account.CurrentStatus = 2
+	case statusCode >= 400:
+		// 其他接口异常（5xx、超时等）由熔断器按连续失败次数处理，避免单次偶发错误导致账号长时间不可用
+		return
 	case statusCode == 200 || statusCode == 201:
 		// 正常状态
 		account.CurrentStatus = 1
@@ -285,6 +473,16 @@ func (s *AccountService) UpdateAccountStatus(account *model.Account, statusCode
 				account.TodayCacheCreationInputTokens = usage.CacheCreationInputTokens
 				account.TodayTotalCost = currentCost
 			}
+
+			// 本月累计（用于月度预算），跨月时重置
+			tokens := int64(usage.InputTokens + usage.OutputTokens + usage.CacheReadInputTokens + usage.CacheCreationInputTokens)
+			if account.LastUsedTime != nil && time.Time(*account.LastUsedTime).Format("2006-01") == now.Format("2006-01") {
+				account.MonthTokens += tokens
+				account.MonthTotalCost += currentCost
+			} else {
+				account.MonthTokens = tokens
+				account.MonthTotalCost = currentCost
+			}
 		}
 
 		// 更新最后使用时间
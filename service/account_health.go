@@ -0,0 +1,241 @@
+package service
+
+import (
+	"claude-code-relay/common"
+	"claude-code-relay/model"
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// 账号健康度在Redis中的键前缀（哈希，多实例共享同一份滑动平均值）
+	accountHealthKeyPrefix = "account_health:"
+	accountHealthKeyTTL    = 24 * time.Hour
+
+	// 指数加权移动平均的平滑系数，越大越偏向最近的请求
+	healthEWMAAlpha = 0.2
+
+	// 本地缓存从Redis刷新的间隔
+	healthCacheRefresh = 10 * time.Second
+
+	// 样本数不足时不参与评分，视为健康
+	healthMinSamples = 5
+
+	// 首字时间低于该值时不扣分
+	healthTTFTTarget = 5 * time.Second
+
+	// 总耗时低于该值时不扣分（总耗时受输出长度影响，阈值放宽）
+	healthLatencyTarget = 60 * time.Second
+
+	// 同一优先级内按健康分分档，每档宽度（分档内保持调度策略原有顺序）
+	healthScoreBucket = 20
+)
+
+// updateHealthScript 原子地更新账号的滑动平均值，参数为-1表示本次没有该项样本
+var updateHealthScript = redis.NewScript(`
+local key = KEYS[1]
+local alpha = tonumber(ARGV[1])
+local ttft = tonumber(ARGV[2])
+local latency = tonumber(ARGV[3])
+local failed = tonumber(ARGV[4])
+local now = ARGV[5]
+local ttl = tonumber(ARGV[6])
+
+local cur = redis.call('HMGET', key, 'ttft', 'latency', 'error_rate', 'samples')
+local function ewma(old, new)
+	old = tonumber(old)
+	if not old or old < 0 then
+		return new
+	end
+	return old + alpha * (new - old)
+end
+
+local newTTFT = cur[1] and tonumber(cur[1]) or -1
+if ttft >= 0 then
+	newTTFT = ewma(cur[1], ttft)
+end
+local newLatency = cur[2] and tonumber(cur[2]) or -1
+if latency >= 0 then
+	newLatency = ewma(cur[2], latency)
+end
+local newErrorRate = ewma(cur[3], failed)
+local samples = (tonumber(cur[4]) or 0) + 1
+
+redis.call('HSET', key, 'ttft', tostring(newTTFT), 'latency', tostring(newLatency),
+	'error_rate', tostring(newErrorRate), 'samples', samples, 'updated_at', now)
+redis.call('EXPIRE', key, ttl)
+return {tostring(newTTFT), tostring(newLatency), tostring(newErrorRate), tostring(samples)}
+`)
+
+// AccountHealth 账号健康度（滑动平均值），-1 表示尚无该项数据
+type AccountHealth struct {
+	AccountID uint      `json:"account_id"`
+	TTFT      float64   `json:"ttft"`       // 首字时间(毫秒)
+	Latency   float64   `json:"latency"`    // 总耗时(毫秒)
+	ErrorRate float64   `json:"error_rate"` // 错误率(0-1)
+	Samples   int64     `json:"samples"`    // 累计样本数
+	Score     float64   `json:"score"`      // 健康分(0-100)
+	UpdatedAt time.Time `json:"updated_at"`
+
+	loadedAt time.Time
+}
+
+// accountHealthCache 本地缓存，调度时直接读取，避免每次请求访问Redis
+var (
+	accountHealthCache   = make(map[uint]*AccountHealth)
+	accountHealthCacheMu sync.RWMutex
+)
+
+// RecordAccountHealth 记录一次请求结果，ttft/latency 小于0表示本次没有对应样本
+func RecordAccountHealth(accountID uint, failed bool, ttft, latency time.Duration) {
+	ttftMs, latencyMs := durationMs(ttft), durationMs(latency)
+	failedValue := 0.0
+	if failed {
+		failedValue = 1
+	}
+
+	if common.RDB != nil {
+		values, err := updateHealthScript.Run(context.Background(), common.RDB, []string{accountHealthKey(accountID)},
+			healthEWMAAlpha, ttftMs, latencyMs, failedValue, time.Now().Unix(), int(accountHealthKeyTTL.Seconds())).StringSlice()
+		if err == nil && len(values) == 4 {
+			health := &AccountHealth{AccountID: accountID, UpdatedAt: time.Now(), loadedAt: time.Now()}
+			health.TTFT, _ = strconv.ParseFloat(values[0], 64)
+			health.Latency, _ = strconv.ParseFloat(values[1], 64)
+			health.ErrorRate, _ = strconv.ParseFloat(values[2], 64)
+			health.Samples, _ = strconv.ParseInt(values[3], 10, 64)
+			storeAccountHealth(health)
+			return
+		}
+		log.Printf("更新账号 %d 健康度失败: %v", accountID, err)
+	}
+
+	// Redis不可用时仅在本地计算
+	accountHealthCacheMu.Lock()
+	defer accountHealthCacheMu.Unlock()
+
+	health, ok := accountHealthCache[accountID]
+	if !ok {
+		health = &AccountHealth{AccountID: accountID, TTFT: -1, Latency: -1, ErrorRate: failedValue}
+		accountHealthCache[accountID] = health
+	} else {
+		health.ErrorRate += healthEWMAAlpha * (failedValue - health.ErrorRate)
+	}
+	if ttftMs >= 0 {
+		health.TTFT = ewma(health.TTFT, ttftMs)
+	}
+	if latencyMs >= 0 {
+		health.Latency = ewma(health.Latency, latencyMs)
+	}
+	health.Samples++
+	health.UpdatedAt = time.Now()
+	health.loadedAt = time.Now()
+}
+
+// GetAccountHealth 获取账号健康度，本地缓存过期时从Redis刷新
+func GetAccountHealth(accountID uint) *AccountHealth {
+	accountHealthCacheMu.RLock()
+	cached, ok := accountHealthCache[accountID]
+	accountHealthCacheMu.RUnlock()
+
+	if ok && (common.RDB == nil || time.Since(cached.loadedAt) < healthCacheRefresh) {
+		return withScore(*cached)
+	}
+	if common.RDB == nil {
+		return withScore(AccountHealth{AccountID: accountID, TTFT: -1, Latency: -1})
+	}
+
+	health := loadAccountHealth(accountID)
+	storeAccountHealth(health)
+	return withScore(*health)
+}
+
+// orderByHealth 在同一优先级内把健康分高的账号排在前面
+// 健康分按档位比较，同一档位内保持调度策略给出的顺序，避免细微波动打乱负载分配
+func orderByHealth(accounts []model.Account) []model.Account {
+	buckets := make(map[uint]int, len(accounts))
+	for _, account := range accounts {
+		buckets[account.ID] = int(GetAccountHealth(account.ID).Score) / healthScoreBucket
+	}
+
+	sort.SliceStable(accounts, func(i, j int) bool {
+		if accounts[i].Priority != accounts[j].Priority {
+			return accounts[i].Priority < accounts[j].Priority
+		}
+		return buckets[accounts[i].ID] > buckets[accounts[j].ID]
+	})
+	return accounts
+}
+
+// withScore 计算健康分：100 × (1 - 错误率) × 首字时间系数 × 总耗时系数，样本不足时视为满分
+func withScore(health AccountHealth) *AccountHealth {
+	health.Score = 100
+	if health.Samples < healthMinSamples {
+		return &health
+	}
+
+	health.Score = 100 * (1 - health.ErrorRate) * latencyFactor(health.TTFT, healthTTFTTarget) * latencyFactor(health.Latency, healthLatencyTarget)
+	return &health
+}
+
+// latencyFactor 耗时超过目标值时按比例扣分，尚无数据时不扣分
+func latencyFactor(valueMs float64, target time.Duration) float64 {
+	targetMs := float64(target.Milliseconds())
+	if valueMs > targetMs {
+		return targetMs / valueMs
+	}
+	return 1
+}
+
+// loadAccountHealth 从Redis读取账号健康度
+func loadAccountHealth(accountID uint) *AccountHealth {
+	health := &AccountHealth{AccountID: accountID, TTFT: -1, Latency: -1, loadedAt: time.Now()}
+
+	values, err := common.RDB.HGetAll(context.Background(), accountHealthKey(accountID)).Result()
+	if err != nil || len(values) == 0 {
+		return health
+	}
+
+	health.TTFT, _ = strconv.ParseFloat(values["ttft"], 64)
+	health.Latency, _ = strconv.ParseFloat(values["latency"], 64)
+	health.ErrorRate, _ = strconv.ParseFloat(values["error_rate"], 64)
+	health.Samples, _ = strconv.ParseInt(values["samples"], 10, 64)
+	if updatedAt, err := strconv.ParseInt(values["updated_at"], 10, 64); err == nil {
+		health.UpdatedAt = time.Unix(updatedAt, 0)
+	}
+	return health
+}
+
+// storeAccountHealth 写入本地缓存
+func storeAccountHealth(health *AccountHealth) {
+	accountHealthCacheMu.Lock()
+	accountHealthCache[health.AccountID] = health
+	accountHealthCacheMu.Unlock()
+}
+
+// ewma 计算指数加权移动平均，旧值小于0表示尚无数据
+func ewma(old, value float64) float64 {
+	if old < 0 {
+		return value
+	}
+	return old + healthEWMAAlpha*(value-old)
+}
+
+// durationMs 转换为毫秒，小于0表示没有样本
+func durationMs(d time.Duration) float64 {
+	if d < 0 {
+		return -1
+	}
+	return float64(d.Milliseconds())
+}
+
+// accountHealthKey 构建账号健康度键
+func accountHealthKey(accountID uint) string {
+	return fmt.Sprintf("%s%d", accountHealthKeyPrefix, accountID)
+}
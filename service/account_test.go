@@ -0,0 +1,36 @@
+package service
+
+import (
+	"claude-code-relay/constant"
+	"testing"
+)
+
+func TestValidatePlatformCredentials(t *testing.T) {
+	serviceAccount := `{"client_email":"relay@example.iam.gserviceaccount.com","private_key":"key","project_id":"project"}`
+	tests := []struct {
+		name         string
+		platformType string
+		region       string
+		accessKeyID  string
+		secretKey    string
+		wantErr      bool
+	}{
+		{name: "bedrock complete", platformType: constant.PlatformBedrock, region: "us-east-1", accessKeyID: "AKID", secretKey: "secret"},
+		{name: "bedrock missing region", platformType: constant.PlatformBedrock, accessKeyID: "AKID", secretKey: "secret", wantErr: true},
+		{name: "bedrock missing access key", platformType: constant.PlatformBedrock, region: "us-east-1", secretKey: "secret", wantErr: true},
+		{name: "bedrock missing secret", platformType: constant.PlatformBedrock, region: "us-east-1", accessKeyID: "AKID", wantErr: true},
+		{name: "vertex complete", platformType: constant.PlatformVertex, region: "us-east5", secretKey: serviceAccount},
+		{name: "vertex missing region", platformType: constant.PlatformVertex, secretKey: serviceAccount, wantErr: true},
+		{name: "vertex invalid service account", platformType: constant.PlatformVertex, region: "us-east5", secretKey: "not json", wantErr: true},
+		{name: "other platforms not checked", platformType: constant.PlatformOpenAI},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validatePlatformCredentials(tt.platformType, tt.region, tt.accessKeyID, tt.secretKey)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validatePlatformCredentials() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"claude-code-relay/common"
+	"claude-code-relay/constant"
 	"claude-code-relay/model"
 	"errors"
 	"log"
@@ -31,18 +32,32 @@ func CreateApiKey(userID uint, req *model.CreateApiKeyRequest) (*model.ApiKey, e
 		return nil, errors.New("过期时间不能早于当前时间")
 	}
 
+	if err := validateClientPolicy(req.ClientPolicy, req.ClientAllowlist); err != nil {
+		return nil, err
+	}
+
 	apiKey := &model.ApiKey{
-		Name:      req.Name,
-		Key:       req.Key,
-		ExpiresAt: req.ExpiresAt,
-		Status:    req.Status,
-		GroupID:   req.GroupID,
-		UserID:    userID,
+		Name:                   req.Name,
+		Key:                    req.Key,
+		ExpiresAt:              req.ExpiresAt,
+		Status:                 req.Status,
+		GroupID:                req.GroupID,
+		UserID:                 userID,
+		PriorityClass:          req.PriorityClass,
+		ClientPolicy:           req.ClientPolicy,
+		ClientAllowlist:        req.ClientAllowlist,
+		DisableCompatEndpoints: req.DisableCompatEndpoints,
 	}
 
 	if apiKey.Status == 0 {
 		apiKey.Status = 1 // 默认启用
 	}
+	if apiKey.PriorityClass == "" {
+		apiKey.PriorityClass = constant.PriorityClassInteractive
+	}
+	if apiKey.ClientPolicy == "" {
+		apiKey.ClientPolicy = constant.ClientPolicyClaudeCode
+	}
 
 	err := model.CreateApiKey(apiKey)
 	if err != nil {
@@ -100,6 +115,21 @@ func UpdateApiKey(id, userID uint, req *model.UpdateApiKeyRequest) (*model.ApiKe
 	if req.DailyLimit != nil {
 		apiKey.DailyLimit = *req.DailyLimit
 	}
+	if req.PriorityClass != nil && *req.PriorityClass != "" {
+		apiKey.PriorityClass = *req.PriorityClass
+	}
+	if req.ClientPolicy != nil && *req.ClientPolicy != "" {
+		apiKey.ClientPolicy = *req.ClientPolicy
+	}
+	if req.ClientAllowlist != nil {
+		apiKey.ClientAllowlist = *req.ClientAllowlist
+	}
+	if req.DisableCompatEndpoints != nil {
+		apiKey.DisableCompatEndpoints = *req.DisableCompatEndpoints
+	}
+	if err := validateClientPolicy(apiKey.ClientPolicy, apiKey.ClientAllowlist); err != nil {
+		return nil, err
+	}
 
 	err = model.UpdateApiKey(apiKey)
 	if err != nil {
@@ -249,3 +279,18 @@ func UpdateApiKeyStatus(apiKey *model.ApiKey, statusCode int, usage *common.Toke
 		log.Printf("failed to update api key status: %v", err)
 	}
 }
+
+// RecordCountTokens 记录一次成功的 count_tokens 请求，只累加账号和API Key的count_tokens次数，
+// 不计费，也不计入今日使用次数，因此不影响最少使用调度
+func RecordCountTokens(account *model.Account, apiKey *model.ApiKey) {
+	if account != nil {
+		if err := model.IncrementAccountCountTokens(account.ID); err != nil {
+			log.Printf("failed to record account count_tokens: %v", err)
+		}
+	}
+	if apiKey != nil {
+		if err := model.IncrementApiKeyCountTokens(apiKey.ID); err != nil {
+			log.Printf("failed to record api key count_tokens: %v", err)
+		}
+	}
+}
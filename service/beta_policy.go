@@ -0,0 +1,139 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// betaFlagPattern beta 名称（或通配符模式）允许的字符
+var betaFlagPattern = regexp.MustCompile(`^[a-z0-9*][a-z0-9.*-]*$`)
+
+// BetaPolicy 账号或分组的 anthropic-beta 策略
+// 格式: 逗号分隔，不带前缀的为白名单（配置了白名单时只转发命中的 beta），"-"前缀表示去掉，"+"前缀表示追加，白名单和去掉支持*通配符
+// 例如 interleaved-thinking-*,fine-grained-tool-streaming-*,-context-1m-*,+token-efficient-tools-2025-02-19
+type BetaPolicy struct {
+	Allow []string
+	Strip []string
+	Add   []string
+}
+
+// ParseBetaPolicy 解析 anthropic-beta 策略，空字符串返回nil
+func ParseBetaPolicy(value string) (*BetaPolicy, error) {
+	policy := &BetaPolicy{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item == "" {
+			continue
+		}
+
+		target := &policy.Allow
+		switch item[0] {
+		case '-':
+			target, item = &policy.Strip, item[1:]
+		case '+':
+			target, item = &policy.Add, item[1:]
+		}
+		if !betaFlagPattern.MatchString(item) {
+			return nil, fmt.Errorf("无效的beta配置: %s", item)
+		}
+		if target == &policy.Add && strings.Contains(item, "*") {
+			return nil, fmt.Errorf("追加的beta不能使用通配符: %s", item)
+		}
+		*target = append(*target, item)
+	}
+
+	if len(policy.Allow) == 0 && len(policy.Strip) == 0 && len(policy.Add) == 0 {
+		return nil, nil
+	}
+	return policy, nil
+}
+
+// LoadBetaPolicies 解析多个 anthropic-beta 策略，忽略空配置和格式错误的配置
+func LoadBetaPolicies(values ...string) []*BetaPolicy {
+	var policies []*BetaPolicy
+	for _, value := range values {
+		policy, err := ParseBetaPolicy(value)
+		if err != nil {
+			log.Printf("忽略格式错误的beta策略 %q: %v", value, err)
+			continue
+		}
+		if policy != nil {
+			policies = append(policies, policy)
+		}
+	}
+	return policies
+}
+
+// permits 判断策略是否允许转发该 beta
+func (p *BetaPolicy) permits(flag string) bool {
+	if len(p.Allow) > 0 && !matchAnyBeta(p.Allow, flag) {
+		return false
+	}
+	return !matchAnyBeta(p.Strip, flag)
+}
+
+// FilterBetas 按策略的白名单和去掉规则过滤 beta 列表，flag 需要被所有策略允许
+func FilterBetas(flags []string, policies []*BetaPolicy) []string {
+	var filtered []string
+	for _, flag := range flags {
+		permitted := true
+		for _, policy := range policies {
+			if !policy.permits(flag) {
+				permitted = false
+				break
+			}
+		}
+		if permitted {
+			filtered = append(filtered, flag)
+		}
+	}
+	return filtered
+}
+
+// ApplyBetaPolicies 过滤 beta 列表后追加策略中配置的 beta，结果去重并保持顺序
+func ApplyBetaPolicies(flags []string, policies []*BetaPolicy) []string {
+	result := FilterBetas(flags, policies)
+	for _, policy := range policies {
+		result = append(result, policy.Add...)
+	}
+	return ParseBetaFlags(result)
+}
+
+// ParseBetaFlags 解析 anthropic-beta 请求头的值（可能有多个，每个逗号分隔），去重并保持顺序
+func ParseBetaFlags(values []string) []string {
+	var flags []string
+	seen := make(map[string]bool)
+	for _, value := range values {
+		for _, flag := range strings.Split(value, ",") {
+			flag = strings.ToLower(strings.TrimSpace(flag))
+			if flag == "" || seen[flag] {
+				continue
+			}
+			seen[flag] = true
+			flags = append(flags, flag)
+		}
+	}
+	return flags
+}
+
+// MatchBeta 判断 beta 是否命中模式，模式支持*通配符
+func MatchBeta(pattern, flag string) bool {
+	if pattern == flag {
+		return true
+	}
+	matched, err := path.Match(pattern, flag)
+	return err == nil && matched
+}
+
+// matchAnyBeta 判断 beta 是否命中任意一个模式
+func matchAnyBeta(patterns []string, flag string) bool {
+	for _, pattern := range patterns {
+		if MatchBeta(pattern, flag) {
+			return true
+		}
+	}
+	return false
+}
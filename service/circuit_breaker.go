@@ -0,0 +1,221 @@
+package service
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 熔断器状态
+const (
+	CircuitClosed   = "closed"    // 正常放行
+	CircuitOpen     = "open"      // 熔断中，不参与调度
+	CircuitHalfOpen = "half_open" // 冷却结束，放行少量真实请求作为探测
+)
+
+const (
+	// 默认连续失败多少次后熔断
+	defaultCircuitFailureThreshold = 5
+	// 默认熔断冷却时间，探测失败后按倍数递增，最长不超过 circuitMaxOpenDuration
+	defaultCircuitOpenDuration = 30 * time.Second
+	circuitMaxOpenDuration     = 10 * time.Minute
+	// 默认半开状态下同时放行的探测请求数
+	defaultCircuitHalfOpenProbes = 1
+)
+
+// circuitBreaker 单个账号的熔断器（进程内）
+type circuitBreaker struct {
+	mu                  sync.Mutex
+	state               string
+	consecutiveFailures int
+	openCount           int // 连续熔断次数，用于计算冷却时间
+	openedAt            time.Time
+	probesInFlight      int
+}
+
+// CircuitBreakerStatus 熔断器状态快照
+type CircuitBreakerStatus struct {
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	OpenUntil           *time.Time `json:"open_until,omitempty"`
+}
+
+// BreakerPermit 熔断器放行凭证，请求结束后必须调用 Success、Failure 或 Abandon 之一
+type BreakerPermit struct {
+	accountID uint
+	breaker   *circuitBreaker
+	probe     bool
+	once      sync.Once
+}
+
+var (
+	circuitBreakers   = make(map[uint]*circuitBreaker)
+	circuitBreakersMu sync.Mutex
+)
+
+// getCircuitBreaker 获取账号的熔断器，不存在时创建
+func getCircuitBreaker(accountID uint) *circuitBreaker {
+	circuitBreakersMu.Lock()
+	defer circuitBreakersMu.Unlock()
+
+	breaker, ok := circuitBreakers[accountID]
+	if !ok {
+		breaker = &circuitBreaker{state: CircuitClosed}
+		circuitBreakers[accountID] = breaker
+	}
+	return breaker
+}
+
+// IsCircuitAvailable 判断账号当前是否可以参与调度（不占用探测名额）
+func IsCircuitAvailable(accountID uint) bool {
+	breaker := getCircuitBreaker(accountID)
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	switch breaker.state {
+	case CircuitOpen:
+		return time.Since(breaker.openedAt) >= breaker.openDuration()
+	case CircuitHalfOpen:
+		return breaker.probesInFlight < getCircuitHalfOpenProbes()
+	default:
+		return true
+	}
+}
+
+// AcquireBreakerPermit 请求发往账号前获取放行凭证
+// 熔断中返回false；冷却结束后转为半开状态，并占用一个探测名额
+func AcquireBreakerPermit(accountID uint) (*BreakerPermit, bool) {
+	breaker := getCircuitBreaker(accountID)
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	if breaker.state == CircuitOpen {
+		if time.Since(breaker.openedAt) < breaker.openDuration() {
+			return nil, false
+		}
+		breaker.state = CircuitHalfOpen
+		breaker.probesInFlight = 0
+		log.Printf("账号 %d 熔断冷却结束，进入半开状态", accountID)
+	}
+
+	permit := &BreakerPermit{accountID: accountID, breaker: breaker}
+	if breaker.state == CircuitHalfOpen {
+		if breaker.probesInFlight >= getCircuitHalfOpenProbes() {
+			return nil, false
+		}
+		breaker.probesInFlight++
+		permit.probe = true
+	}
+	return permit, true
+}
+
+// Success 请求成功：半开状态下探测成功则关闭熔断器
+func (p *BreakerPermit) Success() {
+	p.finish(func(b *circuitBreaker) {
+		b.consecutiveFailures = 0
+		if b.state != CircuitClosed {
+			b.state = CircuitClosed
+			b.openCount = 0
+			log.Printf("账号 %d 探测成功，熔断器关闭", p.accountID)
+		}
+	})
+}
+
+// Failure 请求因账号或上游原因失败：半开状态下立即重新熔断，关闭状态下连续失败达到阈值后熔断
+func (p *BreakerPermit) Failure() {
+	p.finish(func(b *circuitBreaker) {
+		b.consecutiveFailures++
+		if b.state == CircuitHalfOpen || (b.state == CircuitClosed && b.consecutiveFailures >= getCircuitFailureThreshold()) {
+			b.state = CircuitOpen
+			b.openedAt = time.Now()
+			b.openCount++
+			log.Printf("账号 %d 连续失败 %d 次，熔断 %v", p.accountID, b.consecutiveFailures, b.openDuration())
+		}
+	})
+}
+
+// Abandon 结果不能说明账号健康状况（如客户端断开、请求参数错误），仅归还探测名额
+func (p *BreakerPermit) Abandon() {
+	p.finish(func(b *circuitBreaker) {})
+}
+
+// finish 归还探测名额并更新熔断器状态，只生效一次
+func (p *BreakerPermit) finish(update func(b *circuitBreaker)) {
+	if p == nil {
+		return
+	}
+
+	p.once.Do(func() {
+		b := p.breaker
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if p.probe && b.probesInFlight > 0 {
+			b.probesInFlight--
+		}
+		update(b)
+	})
+}
+
+// openDuration 当前熔断冷却时间，调用方需持有锁
+func (b *circuitBreaker) openDuration() time.Duration {
+	duration := getCircuitOpenDuration()
+	for i := 1; i < b.openCount && duration < circuitMaxOpenDuration; i++ {
+		duration *= 2
+	}
+	if duration > circuitMaxOpenDuration {
+		duration = circuitMaxOpenDuration
+	}
+	return duration
+}
+
+// GetCircuitBreakerStatus 获取账号熔断器状态
+func GetCircuitBreakerStatus(accountID uint) *CircuitBreakerStatus {
+	breaker := getCircuitBreaker(accountID)
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	status := &CircuitBreakerStatus{
+		State:               breaker.state,
+		ConsecutiveFailures: breaker.consecutiveFailures,
+	}
+	if breaker.state == CircuitOpen {
+		openUntil := breaker.openedAt.Add(breaker.openDuration())
+		status.OpenUntil = &openUntil
+	}
+	return status
+}
+
+// ResetCircuitBreaker 重置账号熔断器（管理员手动恢复账号时调用）
+func ResetCircuitBreaker(accountID uint) {
+	circuitBreakersMu.Lock()
+	delete(circuitBreakers, accountID)
+	circuitBreakersMu.Unlock()
+}
+
+// getCircuitFailureThreshold 连续失败阈值（环境变量 CIRCUIT_FAILURE_THRESHOLD）
+func getCircuitFailureThreshold() int {
+	return getPositiveIntEnv("CIRCUIT_FAILURE_THRESHOLD", defaultCircuitFailureThreshold)
+}
+
+// getCircuitOpenDuration 熔断冷却时间（环境变量 CIRCUIT_OPEN_DURATION，单位秒）
+func getCircuitOpenDuration() time.Duration {
+	return time.Duration(getPositiveIntEnv("CIRCUIT_OPEN_DURATION", int(defaultCircuitOpenDuration.Seconds()))) * time.Second
+}
+
+// getCircuitHalfOpenProbes 半开状态下的探测请求数（环境变量 CIRCUIT_HALF_OPEN_PROBES）
+func getCircuitHalfOpenProbes() int {
+	return getPositiveIntEnv("CIRCUIT_HALF_OPEN_PROBES", defaultCircuitHalfOpenProbes)
+}
+
+// getPositiveIntEnv 读取正整数环境变量，未设置或无效时返回默认值
+func getPositiveIntEnv(name string, defaultValue int) int {
+	if val := os.Getenv(name); val != "" {
+		if num, err := strconv.Atoi(val); err == nil && num > 0 {
+			return num
+		}
+	}
+	return defaultValue
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+// expireCooldown 把熔断时间提前到冷却时间之前，模拟冷却结束
+func expireCooldown(accountID uint) {
+	breaker := getCircuitBreaker(accountID)
+	breaker.mu.Lock()
+	breaker.openedAt = time.Now().Add(-breaker.openDuration() - time.Second)
+	breaker.mu.Unlock()
+}
+
+// failTimes 连续失败 n 次
+func failTimes(t *testing.T, accountID uint, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		permit, ok := AcquireBreakerPermit(accountID)
+		if !ok {
+			t.Fatalf("failure %d: permit refused, state %s", i+1, GetCircuitBreakerStatus(accountID).State)
+		}
+		permit.Failure()
+	}
+}
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	const accountID = 9001
+	t.Cleanup(func() { ResetCircuitBreaker(accountID) })
+
+	// closed：未达到阈值时保持放行
+	failTimes(t, accountID, defaultCircuitFailureThreshold-1)
+	if state := GetCircuitBreakerStatus(accountID).State; state != CircuitClosed {
+		t.Fatalf("state after %d failures = %s, want %s", defaultCircuitFailureThreshold-1, state, CircuitClosed)
+	}
+
+	// closed -> open：达到阈值后熔断，不再参与调度
+	failTimes(t, accountID, 1)
+	status := GetCircuitBreakerStatus(accountID)
+	if status.State != CircuitOpen || status.OpenUntil == nil {
+		t.Fatalf("state after threshold = %+v, want open with open_until", status)
+	}
+	if IsCircuitAvailable(accountID) {
+		t.Fatal("open circuit should not be available")
+	}
+	if _, ok := AcquireBreakerPermit(accountID); ok {
+		t.Fatal("open circuit should refuse permits during cooldown")
+	}
+
+	// open -> half_open：冷却结束后只放行一个探测请求
+	expireCooldown(accountID)
+	if !IsCircuitAvailable(accountID) {
+		t.Fatal("circuit should be available after cooldown")
+	}
+	probe, ok := AcquireBreakerPermit(accountID)
+	if !ok {
+		t.Fatal("first probe should be admitted after cooldown")
+	}
+	if state := GetCircuitBreakerStatus(accountID).State; state != CircuitHalfOpen {
+		t.Fatalf("state after cooldown = %s, want %s", state, CircuitHalfOpen)
+	}
+	if _, ok := AcquireBreakerPermit(accountID); ok {
+		t.Fatal("half-open circuit should admit only one probe at a time")
+	}
+
+	// 探测被放弃时归还名额，状态不变
+	probe.Abandon()
+	if state := GetCircuitBreakerStatus(accountID).State; state != CircuitHalfOpen {
+		t.Fatalf("state after abandoned probe = %s, want %s", state, CircuitHalfOpen)
+	}
+
+	// half_open -> closed：探测成功后关闭
+	probe, ok = AcquireBreakerPermit(accountID)
+	if !ok {
+		t.Fatal("probe slot should be returned after abandon")
+	}
+	probe.Success()
+	status = GetCircuitBreakerStatus(accountID)
+	if status.State != CircuitClosed || status.ConsecutiveFailures != 0 {
+		t.Fatalf("state after successful probe = %+v, want closed with no failures", status)
+	}
+}
+
+func TestCircuitBreakerHalfOpenFailureReopens(t *testing.T) {
+	const accountID = 9002
+	t.Cleanup(func() { ResetCircuitBreaker(accountID) })
+
+	failTimes(t, accountID, defaultCircuitFailureThreshold)
+	expireCooldown(accountID)
+
+	probe, ok := AcquireBreakerPermit(accountID)
+	if !ok {
+		t.Fatal("probe should be admitted after cooldown")
+	}
+	probe.Failure()
+	if state := GetCircuitBreakerStatus(accountID).State; state != CircuitOpen {
+		t.Fatalf("state after failed probe = %s, want %s", state, CircuitOpen)
+	}
+}
+
+func TestCircuitBreakerCooldownBackoff(t *testing.T) {
+	const accountID = 9003
+	t.Cleanup(func() { ResetCircuitBreaker(accountID) })
+
+	failTimes(t, accountID, defaultCircuitFailureThreshold)
+
+	want := defaultCircuitOpenDuration
+	for i := 1; i <= 10; i++ {
+		breaker := getCircuitBreaker(accountID)
+		breaker.mu.Lock()
+		got := breaker.openDuration()
+		breaker.mu.Unlock()
+		if got != want {
+			t.Fatalf("cooldown after %d opens = %v, want %v", i, got, want)
+		}
+
+		// 探测失败后冷却时间翻倍，不超过上限
+		expireCooldown(accountID)
+		failTimes(t, accountID, 1)
+		want *= 2
+		if want > circuitMaxOpenDuration {
+			want = circuitMaxOpenDuration
+		}
+	}
+	if want != circuitMaxOpenDuration {
+		t.Fatalf("cooldown never reached the cap, last %v", want)
+	}
+
+	// 探测成功后冷却时间恢复为初始值
+	expireCooldown(accountID)
+	probe, ok := AcquireBreakerPermit(accountID)
+	if !ok {
+		t.Fatal("probe should be admitted after cooldown")
+	}
+	probe.Success()
+	failTimes(t, accountID, defaultCircuitFailureThreshold)
+	breaker := getCircuitBreaker(accountID)
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	if got := breaker.openDuration(); got != defaultCircuitOpenDuration {
+		t.Fatalf("cooldown after recovery = %v, want %v", got, defaultCircuitOpenDuration)
+	}
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"claude-code-relay/constant"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// clientAllowlistCache 已编译的自定义客户端白名单，按白名单文本缓存
+var clientAllowlistCache sync.Map
+
+// validateClientPolicy 校验API Key的客户端策略，自定义策略需要至少一条有效的正则
+func validateClientPolicy(policy, allowlist string) error {
+	if policy != constant.ClientPolicyCustom {
+		return nil
+	}
+	patterns, err := compileClientAllowlist(allowlist)
+	if err != nil {
+		return err
+	}
+	if len(patterns) == 0 {
+		return errors.New("自定义客户端策略需要填写白名单")
+	}
+	return nil
+}
+
+// MatchClientAllowlist 判断 User-Agent 是否命中自定义客户端白名单（每行一个正则）
+func MatchClientAllowlist(allowlist, userAgent string) bool {
+	var patterns []*regexp.Regexp
+	if cached, ok := clientAllowlistCache.Load(allowlist); ok {
+		patterns = cached.([]*regexp.Regexp)
+	} else {
+		compiled, err := compileClientAllowlist(allowlist)
+		if err != nil {
+			return false
+		}
+		clientAllowlistCache.Store(allowlist, compiled)
+		patterns = compiled
+	}
+
+	for _, pattern := range patterns {
+		if pattern.MatchString(userAgent) {
+			return true
+		}
+	}
+	return false
+}
+
+// compileClientAllowlist 编译白名单中的正则，忽略空行
+func compileClientAllowlist(allowlist string) ([]*regexp.Regexp, error) {
+	var patterns []*regexp.Regexp
+	for _, line := range strings.Split(allowlist, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		pattern, err := regexp.Compile(line)
+		if err != nil {
+			return nil, fmt.Errorf("无效的客户端白名单正则 %s: %v", line, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
@@ -0,0 +1,135 @@
+package service
+
+import (
+	"claude-code-relay/common"
+	"claude-code-relay/model"
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// 账号并发计数在Redis中的键前缀（有序集合，成员为租约ID，分值为租约过期时间）
+	accountConcurrencyKeyPrefix = "account_concurrency:"
+
+	// 租约有效期及续期间隔，进程异常退出时租约会自动过期释放
+	concurrencyLeaseTTL     = 5 * time.Minute
+	concurrencyLeaseRefresh = time.Minute
+)
+
+// acquireSlotScript 原子地清理过期租约并在未达上限时占用一个并发槽位
+var acquireSlotScript = redis.NewScript(`
+local key = KEYS[1]
+local limit = tonumber(ARGV[1])
+local now = tonumber(ARGV[2])
+local expireAt = tonumber(ARGV[3])
+local leaseID = ARGV[4]
+
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now)
+if redis.call('ZCARD', key) >= limit then
+	return 0
+end
+redis.call('ZADD', key, expireAt, leaseID)
+redis.call('PEXPIREAT', key, expireAt)
+return 1
+`)
+
+// ConcurrencyLease 账号并发槽位租约
+type ConcurrencyLease struct {
+	key     string
+	leaseID string
+	once    sync.Once
+	done    chan struct{}
+}
+
+// AcquireAccountSlot 尝试为账号占用一个并发槽位
+// 低优先级通道只能使用并发上限的一部分，其余为更高通道预留
+// 账号未设置并发上限或Redis不可用时直接放行；ctx 结束（客户端断开）时自动释放
+func AcquireAccountSlot(ctx context.Context, account *model.Account, priorityClass string) (*ConcurrencyLease, bool) {
+	if account.MaxConcurrency <= 0 || common.RDB == nil {
+		return nil, true
+	}
+
+	lease := &ConcurrencyLease{
+		key:     accountConcurrencyKey(account.ID),
+		leaseID: common.GenerateUUID(),
+		done:    make(chan struct{}),
+	}
+
+	now := time.Now()
+	acquired, err := acquireSlotScript.Run(context.Background(), common.RDB, []string{lease.key},
+		laneCapacity(account.MaxConcurrency, priorityClass), now.UnixMilli(), now.Add(concurrencyLeaseTTL).UnixMilli(), lease.leaseID).Int()
+	if err != nil {
+		// Redis异常时不阻塞请求
+		log.Printf("占用账号 %s 并发槽位失败: %v", account.Name, err)
+		return nil, true
+	}
+	if acquired == 0 {
+		return nil, false
+	}
+
+	go lease.keepAlive(ctx)
+	return lease, true
+}
+
+// Release 释放并发槽位，可重复调用
+func (l *ConcurrencyLease) Release() {
+	if l == nil {
+		return
+	}
+
+	l.once.Do(func() {
+		close(l.done)
+		if err := common.RDB.ZRem(context.Background(), l.key, l.leaseID).Err(); err != nil {
+			log.Printf("释放并发槽位失败: %v", err)
+		}
+		notifyWaitQueues()
+	})
+}
+
+// keepAlive 长时间的流式请求定期续期租约，客户端断开时立即释放
+func (l *ConcurrencyLease) keepAlive(ctx context.Context) {
+	ticker := time.NewTicker(concurrencyLeaseRefresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ctx.Done():
+			l.Release()
+			return
+		case <-ticker.C:
+			expireAt := time.Now().Add(concurrencyLeaseTTL).UnixMilli()
+			pipe := common.RDB.Pipeline()
+			pipe.ZAddXX(context.Background(), l.key, &redis.Z{Score: float64(expireAt), Member: l.leaseID})
+			pipe.PExpireAt(context.Background(), l.key, time.UnixMilli(expireAt))
+			if _, err := pipe.Exec(context.Background()); err != nil {
+				log.Printf("续期并发槽位失败: %v", err)
+			}
+		}
+	}
+}
+
+// GetAccountConcurrency 获取账号当前正在处理的请求数
+func GetAccountConcurrency(accountID uint) int {
+	if common.RDB == nil {
+		return 0
+	}
+
+	count, err := common.RDB.ZCount(context.Background(), accountConcurrencyKey(accountID),
+		fmt.Sprintf("(%d", time.Now().UnixMilli()), "+inf").Result()
+	if err != nil {
+		return 0
+	}
+	return int(count)
+}
+
+// accountConcurrencyKey 构建账号并发计数键
+func accountConcurrencyKey(accountID uint) string {
+	return fmt.Sprintf("%s%d", accountConcurrencyKeyPrefix, accountID)
+}
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"claude-code-relay/constant"
 	"claude-code-relay/model"
 	"errors"
 	"strconv"
@@ -22,11 +23,39 @@ func CreateGroup(req *model.CreateGroupRequest, userID uint) (*model.Group, erro
 		return nil, err
 	}
 
+	if err := validateFallbackGroups(0, req.FallbackGroups, userID); err != nil {
+		return nil, err
+	}
+	if _, err := ParseBetaPolicy(req.BetaPolicy); err != nil {
+		return nil, err
+	}
+
 	group := &model.Group{
-		Name:   req.Name,
-		Remark: req.Remark,
-		Status: req.Status,
-		UserID: userID,
+		Name:             req.Name,
+		Remark:           req.Remark,
+		Status:           req.Status,
+		ScheduleStrategy: req.ScheduleStrategy,
+		QueueEnabled:     req.QueueEnabled,
+		QueueMaxWait:     req.QueueMaxWait,
+		QueueMaxDepth:    req.QueueMaxDepth,
+		FallbackGroups:   req.FallbackGroups,
+		HedgeEnabled:     req.HedgeEnabled,
+		HedgePercentile:  req.HedgePercentile,
+		BetaPolicy:       req.BetaPolicy,
+		UserID:           userID,
+	}
+
+	if group.ScheduleStrategy == "" {
+		group.ScheduleStrategy = constant.ScheduleStrategyLeastUsed
+	}
+	if group.QueueMaxWait == 0 {
+		group.QueueMaxWait = defaultQueueMaxWait
+	}
+	if group.QueueMaxDepth == 0 {
+		group.QueueMaxDepth = defaultQueueMaxDepth
+	}
+	if group.HedgePercentile == 0 {
+		group.HedgePercentile = defaultHedgePercentile
 	}
 
 	// 如果没有指定状态，默认为启用
@@ -85,6 +114,41 @@ func UpdateGroup(id string, req *model.UpdateGroupRequest, userID uint) (*model.
 		group.Status = *req.Status
 	}
 
+	if req.ScheduleStrategy != nil && *req.ScheduleStrategy != "" {
+		group.ScheduleStrategy = *req.ScheduleStrategy
+	}
+
+	if req.QueueEnabled != nil {
+		group.QueueEnabled = *req.QueueEnabled
+	}
+	if req.QueueMaxWait != nil && *req.QueueMaxWait > 0 {
+		group.QueueMaxWait = *req.QueueMaxWait
+	}
+	if req.QueueMaxDepth != nil && *req.QueueMaxDepth > 0 {
+		group.QueueMaxDepth = *req.QueueMaxDepth
+	}
+
+	if req.FallbackGroups != nil {
+		if err := validateFallbackGroups(group.ID, *req.FallbackGroups, userID); err != nil {
+			return nil, err
+		}
+		group.FallbackGroups = *req.FallbackGroups
+	}
+
+	if req.HedgeEnabled != nil {
+		group.HedgeEnabled = *req.HedgeEnabled
+	}
+	if req.HedgePercentile != nil && *req.HedgePercentile > 0 {
+		group.HedgePercentile = *req.HedgePercentile
+	}
+
+	if req.BetaPolicy != nil {
+		if _, err := ParseBetaPolicy(*req.BetaPolicy); err != nil {
+			return nil, err
+		}
+		group.BetaPolicy = *req.BetaPolicy
+	}
+
 	err = model.UpdateGroup(group)
 	if err != nil {
 		return nil, err
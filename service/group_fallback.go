@@ -0,0 +1,153 @@
+package service
+
+import (
+	"claude-code-relay/model"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// GroupHop 调度链中的一跳：API Key 所属的主分组或其配置的备用分组
+type GroupHop struct {
+	GroupID      int
+	Group        *model.Group
+	ModelMapping string // 进入该分组时的模型映射（格式同账号的 ModelMapping），主分组为空
+}
+
+// FallbackGroup 备用分组配置项
+type FallbackGroup struct {
+	GroupID      int
+	ModelMapping string
+}
+
+// ParseFallbackGroups 解析分组的备用分组配置
+// 格式: 多个分组按顺序用分号分隔，每个分组可用竖线附加模型映射，例如 3;5|claude-opus:claude-sonnet-4,haiku:glm-4.5-air
+func ParseFallbackGroups(value string) ([]FallbackGroup, error) {
+	var fallbacks []FallbackGroup
+	for _, item := range strings.Split(value, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		idPart, mapping, _ := strings.Cut(item, "|")
+		groupID, err := strconv.Atoi(strings.TrimSpace(idPart))
+		if err != nil || groupID <= 0 {
+			return nil, fmt.Errorf("无效的备用分组ID: %s", idPart)
+		}
+		if err := validateModelMapping(mapping); err != nil {
+			return nil, err
+		}
+
+		fallbacks = append(fallbacks, FallbackGroup{
+			GroupID:      groupID,
+			ModelMapping: strings.TrimSpace(mapping),
+		})
+	}
+	return fallbacks, nil
+}
+
+// validateFallbackGroups 校验备用分组配置：格式正确、分组属于当前用户且不包含自身
+func validateFallbackGroups(groupID uint, value string, userID uint) error {
+	fallbacks, err := ParseFallbackGroups(value)
+	if err != nil {
+		return err
+	}
+
+	for _, fallback := range fallbacks {
+		if groupID != 0 && uint(fallback.GroupID) == groupID {
+			return errors.New("备用分组不能包含自身")
+		}
+		if _, err := model.GetGroupById(fallback.GroupID, userID); err != nil {
+			return fmt.Errorf("备用分组 %d 不存在", fallback.GroupID)
+		}
+	}
+	return nil
+}
+
+// ResolveGroupChain 构建请求的分组调度链：主分组在前，随后按配置顺序排列启用中的备用分组
+// 只展开主分组的备用配置，不递归展开备用分组自己的备用分组
+func ResolveGroupChain(apiKey *model.ApiKey, group *model.Group) []GroupHop {
+	return resolveGroupChain(apiKey, group, model.GetGroupById)
+}
+
+// resolveGroupChain 构建分组调度链，loadGroup 按ID查询用户的分组
+func resolveGroupChain(apiKey *model.ApiKey, group *model.Group, loadGroup func(id int, userID uint) (*model.Group, error)) []GroupHop {
+	chain := []GroupHop{{GroupID: apiKey.GroupID, Group: group}}
+	if group == nil || group.FallbackGroups == "" {
+		return chain
+	}
+
+	fallbacks, err := ParseFallbackGroups(group.FallbackGroups)
+	if err != nil {
+		return chain
+	}
+
+	visited := map[int]bool{apiKey.GroupID: true}
+	for _, fallback := range fallbacks {
+		if visited[fallback.GroupID] {
+			continue
+		}
+		visited[fallback.GroupID] = true
+
+		fallbackGroup, err := loadGroup(fallback.GroupID, apiKey.UserID)
+		if err != nil || fallbackGroup.Status != 1 {
+			continue
+		}
+		chain = append(chain, GroupHop{
+			GroupID:      fallback.GroupID,
+			Group:        fallbackGroup,
+			ModelMapping: fallback.ModelMapping,
+		})
+	}
+	return chain
+}
+
+// MapModelName 按模型映射配置转换模型名称，源模型支持精确匹配或*通配符（与账号的 SupportedModels 一致，不区分大小写），未匹配时返回原模型
+func MapModelName(modelName, modelMapping string) string {
+	lowerModelName := strings.ToLower(modelName)
+	for _, mapping := range strings.Split(modelMapping, ",") {
+		sourceModel, targetModel, ok := splitModelMapping(mapping)
+		if !ok {
+			continue
+		}
+
+		sourceModel = strings.ToLower(sourceModel)
+		if sourceModel == lowerModelName {
+			return targetModel
+		}
+		if matched, err := path.Match(sourceModel, lowerModelName); err == nil && matched {
+			return targetModel
+		}
+	}
+	return modelName
+}
+
+// validateModelMapping 校验备用分组的模型映射：每项都需要源模型和目标模型，源模型的通配符格式正确
+func validateModelMapping(modelMapping string) error {
+	for _, mapping := range strings.Split(modelMapping, ",") {
+		if strings.TrimSpace(mapping) == "" {
+			continue
+		}
+		sourceModel, _, ok := splitModelMapping(mapping)
+		if !ok {
+			return fmt.Errorf("无效的模型映射: %s", strings.TrimSpace(mapping))
+		}
+		if _, err := path.Match(sourceModel, ""); err != nil {
+			return fmt.Errorf("无效的模型映射: %s", strings.TrimSpace(mapping))
+		}
+	}
+	return nil
+}
+
+// splitModelMapping 拆分一项模型映射，目标模型可能包含冒号（如 Bedrock 模型ID），只按第一个冒号分割
+func splitModelMapping(mapping string) (sourceModel, targetModel string, ok bool) {
+	sourceModel, targetModel, found := strings.Cut(mapping, ":")
+	sourceModel, targetModel = strings.TrimSpace(sourceModel), strings.TrimSpace(targetModel)
+	if !found || sourceModel == "" || targetModel == "" {
+		return "", "", false
+	}
+	return sourceModel, targetModel, true
+}
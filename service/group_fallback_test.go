@@ -0,0 +1,144 @@
+package service
+
+import (
+	"claude-code-relay/model"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseFallbackGroups(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []FallbackGroup
+		wantErr bool
+	}{
+		{name: "empty", value: "", want: nil},
+		{name: "single", value: "3", want: []FallbackGroup{{GroupID: 3}}},
+		{
+			name:  "ordered with mapping",
+			value: " 3 ; 5|claude-opus-*:claude-sonnet-4, haiku:glm-4.5-air ",
+			want: []FallbackGroup{
+				{GroupID: 3},
+				{GroupID: 5, ModelMapping: "claude-opus-*:claude-sonnet-4, haiku:glm-4.5-air"},
+			},
+		},
+		{
+			name:  "target containing colon",
+			value: "7|claude-sonnet-4-*:anthropic.claude-sonnet-4-20250514-v1:0",
+			want:  []FallbackGroup{{GroupID: 7, ModelMapping: "claude-sonnet-4-*:anthropic.claude-sonnet-4-20250514-v1:0"}},
+		},
+		{name: "empty items skipped", value: "3;;5;", want: []FallbackGroup{{GroupID: 3}, {GroupID: 5}}},
+		{name: "mapping without target", value: "3;;5|a:", wantErr: true},
+		{name: "mapping without source", value: "5|:b", wantErr: true},
+		{name: "mapping without colon", value: "5|claude", wantErr: true},
+		{name: "malformed pattern", value: "5|claude-[:b", wantErr: true},
+		{name: "non numeric id", value: "3;abc", wantErr: true},
+		{name: "zero id", value: "0", wantErr: true},
+		{name: "negative id", value: "-2", wantErr: true},
+		{name: "missing id", value: "|a:b", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseFallbackGroups(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseFallbackGroups(%q) = %+v, want error", tt.value, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseFallbackGroups(%q) unexpected error: %v", tt.value, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseFallbackGroups(%q) = %+v, want %+v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveGroupChain(t *testing.T) {
+	groups := map[int]*model.Group{
+		1: {ID: 1, Status: 1, FallbackGroups: "2"},
+		2: {ID: 2, Status: 1, FallbackGroups: "1"},
+		3: {ID: 3, Status: 1, FallbackGroups: "3;2"},
+		4: {ID: 4, Status: 0},
+		5: {ID: 5, Status: 1, FallbackGroups: "2;2;4;9;1"},
+	}
+	loadGroup := func(id int, userID uint) (*model.Group, error) {
+		if group, ok := groups[id]; ok {
+			return group, nil
+		}
+		return nil, errors.New("record not found")
+	}
+
+	tests := []struct {
+		name  string
+		group int
+		want  []int
+	}{
+		{name: "cycle is not expanded", group: 1, want: []int{1, 2}},
+		{name: "reverse cycle", group: 2, want: []int{2, 1}},
+		{name: "self reference skipped", group: 3, want: []int{3, 2}},
+		{name: "duplicates, disabled, missing and primary skipped", group: 5, want: []int{5, 2, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apiKey := &model.ApiKey{GroupID: tt.group, UserID: 1}
+			chain := resolveGroupChain(apiKey, groups[tt.group], loadGroup)
+
+			got := make([]int, len(chain))
+			for i, hop := range chain {
+				got[i] = hop.GroupID
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("chain = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("malformed config keeps primary only", func(t *testing.T) {
+		group := &model.Group{ID: 6, Status: 1, FallbackGroups: "2;;5|a:"}
+		chain := resolveGroupChain(&model.ApiKey{GroupID: 6}, group, loadGroup)
+		if len(chain) != 1 || chain[0].GroupID != 6 {
+			t.Errorf("chain = %+v, want primary group only", chain)
+		}
+	})
+
+	t.Run("ungrouped key", func(t *testing.T) {
+		chain := resolveGroupChain(&model.ApiKey{}, nil, loadGroup)
+		if len(chain) != 1 || chain[0].Group != nil {
+			t.Errorf("chain = %+v, want single hop without group", chain)
+		}
+	})
+}
+
+func TestMapModelName(t *testing.T) {
+	tests := []struct {
+		name    string
+		model   string
+		mapping string
+		want    string
+	}{
+		{name: "no mapping", model: "claude-opus-4-20250514", mapping: "", want: "claude-opus-4-20250514"},
+		{name: "exact", model: "claude-opus-4-20250514", mapping: "claude-opus-4-20250514:claude-sonnet-4-20250514", want: "claude-sonnet-4-20250514"},
+		{name: "case insensitive", model: "Claude-Opus-4", mapping: "claude-opus-4:glm-4.5", want: "glm-4.5"},
+		{name: "wildcard", model: "claude-3-5-haiku-20241022", mapping: "claude-opus-*:a,*haiku*:glm-4.5-air", want: "glm-4.5-air"},
+		{name: "substring does not match", model: "claude-3-5-haiku-20241022", mapping: "haiku:glm-4.5-air", want: "claude-3-5-haiku-20241022"},
+		{name: "prefix does not match", model: "claude-opus-4-1", mapping: "claude-opus-4:glm-4.5", want: "claude-opus-4-1"},
+		{name: "first match wins", model: "claude-opus-4", mapping: "claude-*:a,claude-opus-4:b", want: "a"},
+		{name: "target with colon", model: "claude-sonnet-4", mapping: "claude-sonnet-4:anthropic.claude-sonnet-4-v1:0", want: "anthropic.claude-sonnet-4-v1:0"},
+		{name: "malformed items ignored", model: "claude-opus-4", mapping: "claude-opus-4:, :x,claude-opus-4:b", want: "b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MapModelName(tt.model, tt.mapping); got != tt.want {
+				t.Errorf("MapModelName(%q, %q) = %q, want %q", tt.model, tt.mapping, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"claude-code-relay/model"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// 默认对冲触发的延迟百分位
+	defaultHedgePercentile = 95
+	// 每个分组保留的最近非流式请求耗时样本数
+	hedgeLatencyWindow = 200
+	// 样本数不足时使用默认对冲延迟
+	hedgeMinSamples   = 20
+	defaultHedgeDelay = 3 * time.Second
+	// 对冲延迟下限，避免延迟过短导致几乎每个请求都被对冲
+	minHedgeDelay = 500 * time.Millisecond
+)
+
+// latencyWindow 分组最近请求耗时的环形缓冲区（进程内）
+type latencyWindow struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+var (
+	groupLatencies   = make(map[int]*latencyWindow)
+	groupLatenciesMu sync.Mutex
+)
+
+// getLatencyWindow 获取分组的耗时窗口，不存在时创建
+func getLatencyWindow(groupID int) *latencyWindow {
+	groupLatenciesMu.Lock()
+	defer groupLatenciesMu.Unlock()
+
+	window, ok := groupLatencies[groupID]
+	if !ok {
+		window = &latencyWindow{}
+		groupLatencies[groupID] = window
+	}
+	return window
+}
+
+// RecordGroupLatency 记录分组内一次成功的非流式请求耗时，用于计算对冲延迟
+func RecordGroupLatency(groupID int, duration time.Duration) {
+	window := getLatencyWindow(groupID)
+	window.mu.Lock()
+	defer window.mu.Unlock()
+
+	if len(window.samples) < hedgeLatencyWindow {
+		window.samples = append(window.samples, duration)
+		return
+	}
+	window.samples[window.next] = duration
+	window.next = (window.next + 1) % hedgeLatencyWindow
+}
+
+// IsHedgeEnabled 判断分组是否对非流式请求启用对冲
+func IsHedgeEnabled(group *model.Group) bool {
+	return group != nil && group.HedgeEnabled
+}
+
+// GetHedgeDelay 获取分组的对冲延迟：主账号在该时间内未完成时向第二个账号发出同样的请求
+// 取分组最近请求耗时的指定百分位，样本不足时使用默认值
+func GetHedgeDelay(groupID int, group *model.Group) time.Duration {
+	percentile := defaultHedgePercentile
+	if group != nil && group.HedgePercentile > 0 && group.HedgePercentile < 100 {
+		percentile = group.HedgePercentile
+	}
+
+	window := getLatencyWindow(groupID)
+	window.mu.Lock()
+	samples := append([]time.Duration(nil), window.samples...)
+	window.mu.Unlock()
+
+	if len(samples) < hedgeMinSamples {
+		return defaultHedgeDelay
+	}
+
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	delay := samples[(len(samples)-1)*percentile/100]
+	if delay < minHedgeDelay {
+		delay = minHedgeDelay
+	}
+	return delay
+}
@@ -30,7 +30,7 @@ func (s *LogService) CreateLog(req *model.LogCreateRequest) (*model.Log, error)
 }
 
 // CreateLogFromTokenUsage 根据TokenUsage创建日志记录（推荐使用）
-func (s *LogService) CreateLogFromTokenUsage(usage *common.TokenUsage, userID, apiKeyID, accountID uint, duration int64, isStream bool) (*model.Log, error) {
+func (s *LogService) CreateLogFromTokenUsage(usage *common.TokenUsage, userID, apiKeyID, accountID uint, groupID int, duration int64, isStream bool, client common.ClientInfo) (*model.Log, error) {
 	if usage == nil {
 		return nil, errors.New("TokenUsage不能为空")
 	}
@@ -38,7 +38,7 @@ func (s *LogService) CreateLogFromTokenUsage(usage *common.TokenUsage, userID, a
 		return nil, errors.New("用户ID不能为空")
 	}
 
-	log, err := model.CreateLogFromTokenUsage(usage, userID, apiKeyID, accountID, duration, isStream)
+	log, err := model.CreateLogFromTokenUsage(usage, userID, apiKeyID, accountID, groupID, duration, isStream, client)
 	if err != nil {
 		return nil, errors.New("创建日志失败: " + err.Error())
 	}
@@ -258,12 +258,34 @@ func (s *LogService) GetCompleteStats(req *model.StatsQueryRequest) (*model.Stat
 	return result, nil
 }
 
-// GetDashboardStats 获取仪表盘统计数据
-func (s *LogService) GetDashboardStats() (*model.DashboardStats, error) {
+// GetDashboardStats 获取仪表盘统计数据，userID 不为空时账号预算只包含该用户的账号
+func (s *LogService) GetDashboardStats(userID *uint) (*model.DashboardStats, error) {
 	stats, err := model.GetDashboardStats()
 	if err != nil {
 		return nil, errors.New("获取仪表盘统计数据失败: " + err.Error())
 	}
 
+	stats.SessionAffinity = GetSessionAffinityStats()
+
+	budgets, err := GetAccountBudgets(userID)
+	if err != nil {
+		return nil, errors.New("获取账号预算失败: " + err.Error())
+	}
+	stats.AccountBudgets = budgets
+
 	return stats, nil
 }
+
+// GetRelayAttempts 获取指定请求ID的故障转移尝试记录
+func (s *LogService) GetRelayAttempts(requestID string) ([]model.RelayAttempt, error) {
+	if requestID == "" {
+		return nil, errors.New("请求ID不能为空")
+	}
+
+	attempts, err := model.GetRelayAttemptsByRequestID(requestID)
+	if err != nil {
+		return nil, errors.New("获取尝试记录失败: " + err.Error())
+	}
+
+	return attempts, nil
+}
@@ -0,0 +1,405 @@
+package service
+
+import (
+	"claude-code-relay/common"
+	"claude-code-relay/constant"
+	"claude-code-relay/model"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// 单个批处理最多包含的请求数，与官方接口一致
+	maxMessageBatchRequests = 100000
+	// 批处理创建后的有效期，到期仍未执行的请求标记为 expired
+	messageBatchTTL = 24 * time.Hour
+
+	// 批处理执行的默认并发数（进程内），可通过 MESSAGE_BATCH_CONCURRENCY 调整
+	defaultMessageBatchConcurrency = 4
+	// 批处理执行器轮询待执行请求的间隔
+	messageBatchPollInterval = 5 * time.Second
+	// 每次轮询最多查询待执行请求的轮数（跳过超出限额的批处理后继续查询）
+	messageBatchPollRounds = 5
+	// 执行中超过该时长的请求视为实例已退出，放回待执行队列
+	messageBatchItemStaleAfter = 30 * time.Minute
+	// 单条请求的执行时限，短于 messageBatchItemStaleAfter，保证请求放回队列时原来的执行已经取消，不会重复执行和计费
+	messageBatchItemTimeout = 20 * time.Minute
+	// 所有账号都失败时单条请求最多执行的次数
+	messageBatchItemMaxAttempts = 3
+
+	// 导出结果时每次从数据库读取的请求数
+	messageBatchResultPageSize = 500
+)
+
+// customIDPattern 批处理请求的 custom_id 格式
+var customIDPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// MessageBatchRequest 创建批处理时的单条请求
+type MessageBatchRequest struct {
+	CustomID string          `json:"custom_id"`
+	Params   json.RawMessage `json:"params"`
+}
+
+// MessageBatchRequestCounts 批处理中各状态的请求数
+type MessageBatchRequestCounts struct {
+	Processing int `json:"processing"`
+	Succeeded  int `json:"succeeded"`
+	Errored    int `json:"errored"`
+	Canceled   int `json:"canceled"`
+	Expired    int `json:"expired"`
+}
+
+// MessageBatchInfo Anthropic 格式的批处理信息
+type MessageBatchInfo struct {
+	ID                string                    `json:"id"`
+	Type              string                    `json:"type"`
+	ProcessingStatus  string                    `json:"processing_status"`
+	RequestCounts     MessageBatchRequestCounts `json:"request_counts"`
+	EndedAt           *string                   `json:"ended_at"`
+	CreatedAt         string                    `json:"created_at"`
+	ExpiresAt         string                    `json:"expires_at"`
+	ArchivedAt        *string                   `json:"archived_at"`
+	CancelInitiatedAt *string                   `json:"cancel_initiated_at"`
+	ResultsURL        *string                   `json:"results_url"`
+}
+
+// ValidateMessageBatchRequests 校验批处理请求，返回的错误信息可直接返回给客户端
+func ValidateMessageBatchRequests(apiKey *model.ApiKey, requests []MessageBatchRequest) error {
+	if len(requests) == 0 {
+		return errors.New("requests: at least one request is required")
+	}
+	if len(requests) > maxMessageBatchRequests {
+		return fmt.Errorf("requests: a batch may contain at most %d requests", maxMessageBatchRequests)
+	}
+
+	customIDs := make(map[string]struct{}, len(requests))
+	for i, request := range requests {
+		if !customIDPattern.MatchString(request.CustomID) {
+			return fmt.Errorf("requests.%d.custom_id: must be 1-64 characters of letters, digits, underscores or hyphens", i)
+		}
+		if _, exists := customIDs[request.CustomID]; exists {
+			return fmt.Errorf("requests.%d.custom_id: duplicate custom_id %q", i, request.CustomID)
+		}
+		customIDs[request.CustomID] = struct{}{}
+
+		params := gjson.ParseBytes(request.Params)
+		if !params.IsObject() {
+			return fmt.Errorf("requests.%d.params: must be an object", i)
+		}
+		modelName := params.Get("model").String()
+		if modelName == "" {
+			return fmt.Errorf("requests.%d.params.model: field required", i)
+		}
+		if !IsModelAllowedForKey(apiKey, modelName) {
+			return fmt.Errorf("requests.%d.params.model: model %q is not allowed for this API key", i, modelName)
+		}
+		if !params.Get("messages").IsArray() {
+			return fmt.Errorf("requests.%d.params.messages: field required", i)
+		}
+		if params.Get("max_tokens").Int() <= 0 {
+			return fmt.Errorf("requests.%d.params.max_tokens: field required", i)
+		}
+		if params.Get("stream").Bool() {
+			return fmt.Errorf("requests.%d.params.stream: streaming is not supported in batches", i)
+		}
+	}
+	return nil
+}
+
+// CreateMessageBatch 保存批处理及其请求，由后台执行器异步执行，client 为提交批处理的客户端
+func CreateMessageBatch(apiKey *model.ApiKey, requests []MessageBatchRequest, client common.ClientInfo) (*model.MessageBatch, error) {
+	batch := &model.MessageBatch{
+		BatchID:          "msgbatch_" + common.GenerateRandomString(24),
+		ApiKeyID:         apiKey.ID,
+		UserID:           apiKey.UserID,
+		ProcessingStatus: model.BatchStatusInProgress,
+		ClientType:       client.Type,
+		ClientVersion:    client.Version,
+		ExpiresAt:        model.Time(time.Now().Add(messageBatchTTL)),
+		CreatedAt:        model.Time(time.Now()),
+	}
+
+	items := make([]model.MessageBatchItem, 0, len(requests))
+	for _, request := range requests {
+		items = append(items, model.MessageBatchItem{
+			CustomID: request.CustomID,
+			Params:   string(request.Params),
+			Status:   model.BatchItemPending,
+		})
+	}
+
+	if err := model.CreateMessageBatch(batch, items); err != nil {
+		return nil, err
+	}
+	return batch, nil
+}
+
+// BuildMessageBatchInfos 转换为 Anthropic 格式的批处理信息，resultsURL 根据批处理ID生成结果下载地址
+func BuildMessageBatchInfos(batches []model.MessageBatch, resultsURL func(batchID string) string) ([]MessageBatchInfo, error) {
+	batchIDs := make([]uint, 0, len(batches))
+	for _, batch := range batches {
+		batchIDs = append(batchIDs, batch.ID)
+	}
+	counts, err := model.CountMessageBatchItems(batchIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]MessageBatchInfo, 0, len(batches))
+	for _, batch := range batches {
+		count := counts[batch.ID]
+		info := MessageBatchInfo{
+			ID:               batch.BatchID,
+			Type:             "message_batch",
+			ProcessingStatus: batch.ProcessingStatus,
+			RequestCounts: MessageBatchRequestCounts{
+				Processing: count[model.BatchItemPending] + count[model.BatchItemRunning],
+				Succeeded:  count[model.BatchItemSucceeded],
+				Errored:    count[model.BatchItemErrored],
+				Canceled:   count[model.BatchItemCanceled],
+				Expired:    count[model.BatchItemExpired],
+			},
+			CreatedAt:         formatBatchTime(&batch.CreatedAt),
+			ExpiresAt:         formatBatchTime(&batch.ExpiresAt),
+			EndedAt:           optionalBatchTime(batch.EndedAt),
+			CancelInitiatedAt: optionalBatchTime(batch.CancelInitiatedAt),
+		}
+		if batch.ProcessingStatus == model.BatchStatusEnded {
+			url := resultsURL(batch.BatchID)
+			info.ResultsURL = &url
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// formatBatchTime 按官方接口使用 RFC 3339 格式
+func formatBatchTime(t *model.Time) string {
+	return time.Time(*t).UTC().Format(time.RFC3339)
+}
+
+// optionalBatchTime 可为空的时间字段
+func optionalBatchTime(t *model.Time) *string {
+	if t == nil {
+		return nil
+	}
+	formatted := formatBatchTime(t)
+	return &formatted
+}
+
+// WriteMessageBatchResults 按提交顺序逐行输出批处理结果（JSONL），每行为 {"custom_id", "result"}
+func WriteMessageBatchResults(batch *model.MessageBatch, write func(line []byte) error) error {
+	var afterID uint
+	for {
+		items, err := model.GetMessageBatchItems(batch.ID, afterID, messageBatchResultPageSize)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			result := json.RawMessage(item.Result)
+			if item.Result == "" {
+				result, _ = json.Marshal(map[string]string{"type": item.Status})
+			}
+			line, err := json.Marshal(map[string]any{
+				"custom_id": item.CustomID,
+				"result":    result,
+			})
+			if err != nil {
+				return err
+			}
+			if err := write(append(line, '\n')); err != nil {
+				return err
+			}
+		}
+		if len(items) < messageBatchResultPageSize {
+			return nil
+		}
+		afterID = items[len(items)-1].ID
+	}
+}
+
+// BatchItemOutcome 单条批处理请求的执行结果
+type BatchItemOutcome struct {
+	Message json.RawMessage // 成功时的 Message 对象
+	Error   map[string]any  // 失败时的错误对象，包含 type 和 message
+	Retry   bool            // 暂时无法完成（账号繁忙或全部失败），放回队列稍后重试
+	Counted bool            // 本次是否实际向账号发出了请求，计入执行次数
+}
+
+// BatchItemExecutor 以API Key的身份执行一条消息请求，client 为提交批处理的客户端，记录到请求日志
+type BatchItemExecutor func(ctx context.Context, apiKey *model.ApiKey, client common.ClientInfo, params []byte) BatchItemOutcome
+
+// StartMessageBatchWorker 启动批处理后台执行器，按有限的并发从数据库中领取待执行的请求
+// 多实例部署时各实例分别领取，同一请求只会被一个实例执行
+func StartMessageBatchWorker(executor BatchItemExecutor) {
+	worker := &messageBatchWorker{
+		executor: executor,
+		slots:    make(chan struct{}, getPositiveIntEnv("MESSAGE_BATCH_CONCURRENCY", defaultMessageBatchConcurrency)),
+	}
+	go worker.run()
+	common.SysLog(fmt.Sprintf("批处理执行器已启动，并发数: %d", cap(worker.slots)))
+}
+
+// messageBatchWorker 批处理后台执行器
+type messageBatchWorker struct {
+	executor BatchItemExecutor
+	slots    chan struct{}
+}
+
+// run 定时轮询并执行待执行的请求
+func (w *messageBatchWorker) run() {
+	ticker := time.NewTicker(messageBatchPollInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		w.poll()
+	}
+}
+
+// poll 维护批处理状态，并在有空闲并发时领取新的请求
+func (w *messageBatchWorker) poll() {
+	now := time.Now()
+	if requeued, err := model.RequeueStaleMessageBatchItems(now.Add(-messageBatchItemStaleAfter)); err != nil {
+		log.Printf("批处理超时请求放回队列失败: %v", err)
+	} else if requeued > 0 {
+		log.Printf("批处理有 %d 个执行超时的请求已放回队列", requeued)
+	}
+	if err := model.ExpireMessageBatches(now); err != nil {
+		log.Printf("批处理过期请求标记失败: %v", err)
+	}
+	if err := model.CancelPendingMessageBatchItems(); err != nil {
+		log.Printf("批处理取消请求失败: %v", err)
+	}
+	if err := model.EndFinishedMessageBatches(now); err != nil {
+		log.Printf("批处理结束状态更新失败: %v", err)
+	}
+
+	// 超出每日限额的Key暂不执行，其批处理在后续查询中跳过，避免阻塞其他批处理
+	owners := make(map[uint]*messageBatchOwner)
+	var skipped []uint
+	for round := 0; round < messageBatchPollRounds; round++ {
+		free := cap(w.slots) - len(w.slots)
+		if free <= 0 {
+			return
+		}
+		items, err := model.GetPendingMessageBatchItems(skipped, free)
+		if err != nil {
+			log.Printf("查询批处理待执行请求失败: %v", err)
+			return
+		}
+		if len(items) == 0 {
+			return
+		}
+		for i := range items {
+			w.dispatch(&items[i], owners, &skipped)
+		}
+	}
+}
+
+// dispatch 领取单条请求并交给执行器，所属Key超出每日限额时记录到 skipped
+func (w *messageBatchWorker) dispatch(item *model.MessageBatchItem, owners map[uint]*messageBatchOwner, skipped *[]uint) {
+	owner, ok := owners[item.BatchID]
+	if !ok {
+		owner = loadMessageBatchOwner(item.BatchID)
+		owners[item.BatchID] = owner
+	}
+	apiKey := owner.apiKey
+	if apiKey != nil && apiKey.DailyLimit > 0 && apiKey.TodayTotalCost >= apiKey.DailyLimit {
+		for _, id := range *skipped {
+			if id == item.BatchID {
+				return
+			}
+		}
+		*skipped = append(*skipped, item.BatchID)
+		return
+	}
+
+	claimed, err := model.ClaimMessageBatchItem(item)
+	if err != nil {
+		log.Printf("领取批处理请求 %d 失败: %v", item.ID, err)
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	if apiKey == nil {
+		w.finish(item, BatchItemOutcome{Error: map[string]any{
+			"type":    "authentication_error",
+			"message": "API Key is disabled, expired or deleted",
+		}})
+		return
+	}
+
+	w.slots <- struct{}{}
+	go w.execute(owner, *item)
+}
+
+// messageBatchOwner 批处理所属的API Key及提交批处理的客户端
+type messageBatchOwner struct {
+	apiKey *model.ApiKey // 已禁用、过期或删除时为nil
+	client common.ClientInfo
+}
+
+// loadMessageBatchOwner 获取批处理所属的API Key及提交批处理的客户端
+func loadMessageBatchOwner(batchID uint) *messageBatchOwner {
+	batch, err := model.GetMessageBatchByID(batchID)
+	if err != nil {
+		return &messageBatchOwner{}
+	}
+	owner := &messageBatchOwner{client: common.ClientInfo{Type: batch.ClientType, Version: batch.ClientVersion}}
+	apiKey, err := model.GetApiKeyById(batch.ApiKeyID, batch.UserID)
+	if err != nil || apiKey.Status != 1 {
+		return owner
+	}
+	if apiKey.ExpiresAt != nil && time.Time(*apiKey.ExpiresAt).Before(time.Now()) {
+		return owner
+	}
+	// 批处理是离线任务，交互式通道的Key也按批处理通道调度，避免挤占实时请求
+	if !IsLowPriorityClass(apiKey.PriorityClass) {
+		apiKey.PriorityClass = constant.PriorityClassBatch
+	}
+	owner.apiKey = apiKey
+	return owner
+}
+
+// execute 执行单条请求并保存结果
+func (w *messageBatchWorker) execute(owner *messageBatchOwner, item model.MessageBatchItem) {
+	defer func() { <-w.slots }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), messageBatchItemTimeout)
+	defer cancel()
+
+	outcome := w.executor(ctx, owner.apiKey, owner.client, []byte(item.Params))
+	if outcome.Retry && (!outcome.Counted || item.Attempts < messageBatchItemMaxAttempts) {
+		if err := model.ReleaseMessageBatchItem(&item, outcome.Counted); err != nil {
+			log.Printf("批处理请求 %d 放回队列失败: %v", item.ID, err)
+		}
+		return
+	}
+	w.finish(&item, outcome)
+}
+
+// finish 保存请求的最终结果，格式与官方接口的结果文件一致
+func (w *messageBatchWorker) finish(item *model.MessageBatchItem, outcome BatchItemOutcome) {
+	status := model.BatchItemSucceeded
+	result := map[string]any{"type": "succeeded", "message": outcome.Message}
+	if outcome.Message == nil {
+		status = model.BatchItemErrored
+		result = map[string]any{
+			"type":  "errored",
+			"error": map[string]any{"type": "error", "error": outcome.Error},
+		}
+	}
+
+	resultJSON, _ := json.Marshal(result)
+	if err := model.FinishMessageBatchItem(item, status, string(resultJSON)); err != nil {
+		log.Printf("保存批处理请求 %d 结果失败: %v", item.ID, err)
+	}
+}
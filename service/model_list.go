@@ -0,0 +1,123 @@
+package service
+
+import (
+	"claude-code-relay/common"
+	"claude-code-relay/constant"
+	"claude-code-relay/model"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ModelInfo Anthropic 格式的模型信息
+type ModelInfo struct {
+	Type        string `json:"type"`
+	ID          string `json:"id"`
+	DisplayName string `json:"display_name"`
+	CreatedAt   string `json:"created_at"`
+}
+
+// ListApiKeyModels 计算API Key可以使用的模型列表
+// 结果为三者的交集：价格表中的模型、Key的模型限制、Key的分组调度链（主分组及备用分组）内账号能够处理的模型
+// 备用分组按其模型映射转换后判断，账号的模型映射规则与转发时一致
+func ListApiKeyModels(apiKey *model.ApiKey) ([]ModelInfo, error) {
+	type hopAccounts struct {
+		modelMapping string
+		accounts     []model.Account
+	}
+
+	var hops []hopAccounts
+	for _, hop := range ResolveGroupChain(apiKey, GetApiKeyGroup(apiKey)) {
+		accounts, err := model.GetActiveAccountsByGroupID(hop.GroupID)
+		if err != nil {
+			return nil, err
+		}
+		hops = append(hops, hopAccounts{modelMapping: hop.ModelMapping, accounts: accounts})
+	}
+
+	models := make([]ModelInfo, 0)
+	for modelName := range common.MODEL_PRICING {
+		if modelName == "unknown" || !IsModelAllowedForKey(apiKey, modelName) {
+			continue
+		}
+		for _, hop := range hops {
+			if anyAccountServesModel(hop.accounts, MapModelName(modelName, hop.modelMapping)) {
+				models = append(models, newModelInfo(modelName))
+				break
+			}
+		}
+	}
+
+	// 与官方接口一致，较新的模型排在前面
+	sort.Slice(models, func(i, j int) bool {
+		if models[i].CreatedAt != models[j].CreatedAt {
+			return models[i].CreatedAt > models[j].CreatedAt
+		}
+		return models[i].ID < models[j].ID
+	})
+	return models, nil
+}
+
+// IsModelAllowedForKey 判断模型是否在API Key的模型限制内，未设置限制时允许所有模型
+func IsModelAllowedForKey(apiKey *model.ApiKey, modelName string) bool {
+	if apiKey.ModelRestriction == "" {
+		return true
+	}
+	for _, allowedModel := range strings.Split(apiKey.ModelRestriction, ",") {
+		if strings.EqualFold(strings.TrimSpace(allowedModel), modelName) {
+			return true
+		}
+	}
+	return false
+}
+
+// anyAccountServesModel 判断是否有账号能够处理该模型
+func anyAccountServesModel(accounts []model.Account, modelName string) bool {
+	for i := range accounts {
+		if accountServesModel(&accounts[i], modelName) {
+			return true
+		}
+	}
+	return false
+}
+
+// accountServesModel 判断账号能否处理该模型
+// OpenAI、Azure OpenAI、Gemini、Bedrock、Vertex账号配置了模型映射时，只有命中映射的模型才会被转发，未配置时处理所有请求（OpenAI、Gemini使用账号的默认模型）
+func accountServesModel(account *model.Account, modelName string) bool {
+	if !account.SupportsModel(modelName) {
+		return false
+	}
+	isMappedPlatform := account.PlatformType == constant.PlatformOpenAI || account.PlatformType == constant.PlatformAzureOpenAI || account.PlatformType == constant.PlatformGemini ||
+		account.PlatformType == constant.PlatformBedrock || account.PlatformType == constant.PlatformVertex
+	if !isMappedPlatform || strings.TrimSpace(account.ModelMapping) == "" {
+		return true
+	}
+	return matchesAccountModelMapping(modelName, account.ModelMapping)
+}
+
+// matchesAccountModelMapping 判断模型是否命中账号的模型映射，规则与转发时一致（包含关键字即匹配）
+func matchesAccountModelMapping(modelName, modelMapping string) bool {
+	for _, mapping := range strings.Split(modelMapping, ",") {
+		sourceModel, _, ok := splitModelMapping(mapping)
+		if ok && strings.Contains(modelName, sourceModel) {
+			return true
+		}
+	}
+	return false
+}
+
+// newModelInfo 构造模型信息，创建时间取自模型名称末尾的日期
+func newModelInfo(modelName string) ModelInfo {
+	info := ModelInfo{
+		Type:        "model",
+		ID:          modelName,
+		DisplayName: modelName,
+		CreatedAt:   time.Unix(0, 0).UTC().Format(time.RFC3339),
+	}
+	if idx := strings.LastIndex(modelName, "-"); idx >= 0 {
+		if date, err := time.Parse("20060102", modelName[idx+1:]); err == nil {
+			info.CreatedAt = date.UTC().Format(time.RFC3339)
+		}
+	}
+	return info
+}
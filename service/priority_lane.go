@@ -0,0 +1,65 @@
+package service
+
+import (
+	"claude-code-relay/constant"
+)
+
+const (
+	// 默认低优先级通道可使用的容量比例（百分比），剩余部分为更高通道预留
+	defaultBatchCapacityPercent      = 75
+	defaultBackgroundCapacityPercent = 50
+)
+
+// NormalizePriorityClass 规范化优先级通道，未设置或未知时视为交互式
+func NormalizePriorityClass(priorityClass string) string {
+	switch priorityClass {
+	case constant.PriorityClassBatch, constant.PriorityClassBackground:
+		return priorityClass
+	default:
+		return constant.PriorityClassInteractive
+	}
+}
+
+// IsLowPriorityClass 判断是否为低优先级通道（资源紧张时应先返回429）
+func IsLowPriorityClass(priorityClass string) bool {
+	return NormalizePriorityClass(priorityClass) != constant.PriorityClassInteractive
+}
+
+// priorityRank 通道排序值，越小优先级越高
+func priorityRank(priorityClass string) int {
+	switch NormalizePriorityClass(priorityClass) {
+	case constant.PriorityClassBatch:
+		return 1
+	case constant.PriorityClassBackground:
+		return 2
+	default:
+		return 0
+	}
+}
+
+// priorityCapacityPercent 通道可使用的容量比例
+// 环境变量 PRIORITY_BATCH_CAPACITY / PRIORITY_BACKGROUND_CAPACITY，取值1-100
+func priorityCapacityPercent(priorityClass string) int {
+	var percent int
+	switch NormalizePriorityClass(priorityClass) {
+	case constant.PriorityClassBatch:
+		percent = getPositiveIntEnv("PRIORITY_BATCH_CAPACITY", defaultBatchCapacityPercent)
+	case constant.PriorityClassBackground:
+		percent = getPositiveIntEnv("PRIORITY_BACKGROUND_CAPACITY", defaultBackgroundCapacityPercent)
+	default:
+		return 100
+	}
+	if percent > 100 {
+		percent = 100
+	}
+	return percent
+}
+
+// laneCapacity 按通道比例计算可使用的容量，至少为1
+func laneCapacity(total int, priorityClass string) int {
+	capacity := total * priorityCapacityPercent(priorityClass) / 100
+	if capacity < 1 {
+		capacity = 1
+	}
+	return capacity
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"claude-code-relay/constant"
+	"testing"
+)
+
+func TestNormalizePriorityClass(t *testing.T) {
+	tests := map[string]string{
+		"":                                constant.PriorityClassInteractive,
+		"unknown":                         constant.PriorityClassInteractive,
+		constant.PriorityClassInteractive: constant.PriorityClassInteractive,
+		constant.PriorityClassBatch:       constant.PriorityClassBatch,
+		constant.PriorityClassBackground:  constant.PriorityClassBackground,
+	}
+	for input, want := range tests {
+		if got := NormalizePriorityClass(input); got != want {
+			t.Errorf("NormalizePriorityClass(%q) = %q, want %q", input, got, want)
+		}
+	}
+
+	if IsLowPriorityClass("") || IsLowPriorityClass(constant.PriorityClassInteractive) {
+		t.Error("interactive lane should not be low priority")
+	}
+	if !IsLowPriorityClass(constant.PriorityClassBatch) || !IsLowPriorityClass(constant.PriorityClassBackground) {
+		t.Error("batch and background lanes should be low priority")
+	}
+}
+
+func TestLaneCapacity(t *testing.T) {
+	tests := []struct {
+		name          string
+		total         int
+		priorityClass string
+		env           map[string]string
+		want          int
+	}{
+		{name: "interactive uses full capacity", total: 8, priorityClass: constant.PriorityClassInteractive, want: 8},
+		{name: "batch reserves a quarter", total: 8, priorityClass: constant.PriorityClassBatch, want: 6},
+		{name: "background reserves half", total: 8, priorityClass: constant.PriorityClassBackground, want: 4},
+		{name: "rounds down", total: 5, priorityClass: constant.PriorityClassBackground, want: 2},
+		{name: "at least one", total: 1, priorityClass: constant.PriorityClassBackground, want: 1},
+		{
+			name: "env override", total: 10, priorityClass: constant.PriorityClassBatch,
+			env: map[string]string{"PRIORITY_BATCH_CAPACITY": "30"}, want: 3,
+		},
+		{
+			name: "env capped at 100", total: 10, priorityClass: constant.PriorityClassBackground,
+			env: map[string]string{"PRIORITY_BACKGROUND_CAPACITY": "150"}, want: 10,
+		},
+		{
+			name: "invalid env ignored", total: 10, priorityClass: constant.PriorityClassBackground,
+			env: map[string]string{"PRIORITY_BACKGROUND_CAPACITY": "-5"}, want: 5,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PRIORITY_BATCH_CAPACITY", "")
+			t.Setenv("PRIORITY_BACKGROUND_CAPACITY", "")
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			if got := laneCapacity(tt.total, tt.priorityClass); got != tt.want {
+				t.Errorf("laneCapacity(%d, %q) = %d, want %d", tt.total, tt.priorityClass, got, tt.want)
+			}
+		})
+	}
+}
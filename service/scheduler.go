@@ -0,0 +1,146 @@
+package service
+
+import (
+	"claude-code-relay/constant"
+	"claude-code-relay/model"
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// AccountScheduler 账号调度策略，返回候选账号的尝试顺序
+type AccountScheduler interface {
+	// Order 对同一分组下的可用账号排序，排在前面的账号优先使用
+	Order(accounts []model.Account) []model.Account
+}
+
+// ErrModelNotAvailable 分组内没有账号支持所请求的模型
+var ErrModelNotAvailable = errors.New("model not available")
+
+// schedulers 已注册的调度策略
+var schedulers = map[string]AccountScheduler{
+	constant.ScheduleStrategyLeastUsed:      &leastUsedScheduler{},
+	constant.ScheduleStrategyWeightedRandom: &weightedRandomScheduler{random: rand.Float64},
+}
+
+// GetScheduler 根据策略名称获取调度器，未知策略回退为最少使用策略
+func GetScheduler(strategy string) AccountScheduler {
+	if scheduler, ok := schedulers[strategy]; ok {
+		return scheduler
+	}
+	return schedulers[constant.ScheduleStrategyLeastUsed]
+}
+
+// GetApiKeyGroup 获取API Key所属的分组，未分组或分组不存在时返回nil
+func GetApiKeyGroup(apiKey *model.ApiKey) *model.Group {
+	if apiKey.GroupID <= 0 {
+		return nil
+	}
+	group, err := model.GetGroupById(apiKey.GroupID, apiKey.UserID)
+	if err != nil {
+		return nil
+	}
+	return group
+}
+
+// ScheduleAccounts 获取分组下可用且支持所请求模型的账号，并按分组配置的调度策略排序
+// 分组内没有任何账号支持该模型时返回 ErrModelNotAvailable
+func ScheduleAccounts(groupID int, group *model.Group, modelName string) ([]model.Account, error) {
+	accounts, err := model.GetAvailableAccountsByGroupID(groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	accounts = filterAccountsByModel(accounts, modelName)
+	if len(accounts) == 0 && modelName != "" {
+		// 区分"暂时没有空闲账号"和"根本没有账号支持该模型"，后者排队也无意义
+		supported, err := groupSupportsModel(groupID, modelName)
+		if err != nil {
+			return nil, err
+		}
+		if !supported {
+			return nil, ErrModelNotAvailable
+		}
+	}
+
+	strategy := constant.ScheduleStrategyLeastUsed
+	if group != nil && group.ScheduleStrategy != "" {
+		strategy = group.ScheduleStrategy
+	}
+
+	return orderByHealth(GetScheduler(strategy).Order(accounts)), nil
+}
+
+// filterAccountsByModel 过滤掉不支持所请求模型、处于熔断中或已超出预算的账号
+func filterAccountsByModel(accounts []model.Account, modelName string) []model.Account {
+	filtered := make([]model.Account, 0, len(accounts))
+	for _, account := range accounts {
+		if account.HasBudget() && account.GetBudget().Exhausted {
+			continue
+		}
+		if account.SupportsModel(modelName) && IsCircuitAvailable(account.ID) {
+			filtered = append(filtered, account)
+		}
+	}
+	return filtered
+}
+
+// groupSupportsModel 判断分组内是否有激活的账号支持该模型（包括当前限流或异常的账号）
+func groupSupportsModel(groupID int, modelName string) (bool, error) {
+	accounts, err := model.GetActiveAccountsByGroupID(groupID)
+	if err != nil {
+		return false, err
+	}
+	for _, account := range accounts {
+		if account.SupportsModel(modelName) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// leastUsedScheduler 按优先级升序，同优先级内今日使用次数少的优先
+type leastUsedScheduler struct{}
+
+func (s *leastUsedScheduler) Order(accounts []model.Account) []model.Account {
+	ordered := make([]model.Account, len(accounts))
+	copy(ordered, accounts)
+
+	sort.SliceStable(ordered, func(i, j int) bool {
+		if ordered[i].Priority != ordered[j].Priority {
+			return ordered[i].Priority < ordered[j].Priority
+		}
+		return ordered[i].TodayUsageCount < ordered[j].TodayUsageCount
+	})
+	return ordered
+}
+
+// weightedRandomScheduler 按优先级升序，同优先级内按权重进行加权随机排列
+// 采用 Efraimidis-Spirakis 算法：每个账号取 key = u^(1/weight)，按 key 降序排列，
+// 每个账号排在第一位的概率与其权重成正比，排在后面的账号作为故障转移的候选
+type weightedRandomScheduler struct {
+	random func() float64
+}
+
+func (s *weightedRandomScheduler) Order(accounts []model.Account) []model.Account {
+	ordered := make([]model.Account, len(accounts))
+	copy(ordered, accounts)
+
+	keys := make(map[uint]float64, len(ordered))
+	for _, account := range ordered {
+		weight := account.Weight
+		if weight < 1 {
+			weight = 1
+		}
+		keys[account.ID] = math.Pow(s.random(), 1/float64(weight))
+	}
+
+	sort.SliceStable(ordered, func(i, j int) bool {
+		if ordered[i].Priority != ordered[j].Priority {
+			return ordered[i].Priority < ordered[j].Priority
+		}
+		return keys[ordered[i].ID] > keys[ordered[j].ID]
+	})
+	return ordered
+}
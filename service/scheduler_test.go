@@ -0,0 +1,68 @@
+package service
+
+import (
+	"claude-code-relay/model"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestWeightedRandomSchedulerDistribution(t *testing.T) {
+	const samples = 20000
+	const tolerance = 0.02
+
+	tests := []struct {
+		name    string
+		weights []int
+		// want 每个账号排在第一位的期望比例
+		want []float64
+	}{
+		{name: "proportional", weights: []int{1, 3}, want: []float64{0.25, 0.75}},
+		{name: "three accounts", weights: []int{2, 3, 5}, want: []float64{0.2, 0.3, 0.5}},
+		{name: "all equal", weights: []int{4, 4, 4, 4}, want: []float64{0.25, 0.25, 0.25, 0.25}},
+		{name: "zero weight treated as one", weights: []int{0, 1}, want: []float64{0.5, 0.5}},
+		{name: "zero weight against heavier", weights: []int{0, 3}, want: []float64{0.25, 0.75}},
+		{name: "single account", weights: []int{7}, want: []float64{1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			accounts := make([]model.Account, len(tt.weights))
+			for i, weight := range tt.weights {
+				accounts[i] = model.Account{ID: uint(i + 1), Weight: weight}
+			}
+
+			scheduler := &weightedRandomScheduler{random: rand.New(rand.NewSource(1)).Float64}
+			firsts := make(map[uint]int)
+			for i := 0; i < samples; i++ {
+				ordered := scheduler.Order(accounts)
+				if len(ordered) != len(accounts) {
+					t.Fatalf("Order returned %d accounts, want %d", len(ordered), len(accounts))
+				}
+				firsts[ordered[0].ID]++
+			}
+
+			for i, want := range tt.want {
+				got := float64(firsts[uint(i+1)]) / samples
+				if math.Abs(got-want) > tolerance {
+					t.Errorf("account %d (weight %d) first share = %.3f, want %.3f±%.2f", i+1, tt.weights[i], got, want, tolerance)
+				}
+			}
+		})
+	}
+}
+
+func TestWeightedRandomSchedulerPriority(t *testing.T) {
+	accounts := []model.Account{
+		{ID: 1, Priority: 2, Weight: 100},
+		{ID: 2, Priority: 1, Weight: 1},
+		{ID: 3, Priority: 2, Weight: 1},
+	}
+	scheduler := &weightedRandomScheduler{random: rand.New(rand.NewSource(1)).Float64}
+	for i := 0; i < 1000; i++ {
+		ordered := scheduler.Order(accounts)
+		if ordered[0].ID != 2 {
+			t.Fatalf("higher priority account should always come first, got %d", ordered[0].ID)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"claude-code-relay/common"
+	"claude-code-relay/model"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// 会话粘性在Redis中的键前缀
+	sessionAffinityKeyPrefix   = "session_affinity:"
+	sessionAffinityStatsPrefix = "session_affinity:stats:"
+
+	// 默认会话粘性有效期
+	defaultSessionAffinityTTL = time.Hour
+)
+
+// SessionHashFromBody 根据原始请求体计算会话标识
+// 优先使用 metadata.user_id（Claude Code 会在其中携带会话ID），否则使用系统提示词和第一条消息的哈希
+func SessionHashFromBody(body []byte) string {
+	if userID := gjson.GetBytes(body, "metadata.user_id").String(); userID != "" {
+		return hashSessionContent("user_id:" + userID)
+	}
+
+	system := gjson.GetBytes(body, "system").Raw
+	firstMessage := gjson.GetBytes(body, "messages.0").Raw
+	if system == "" && firstMessage == "" {
+		return ""
+	}
+	return hashSessionContent("prompt:" + system + "\n" + firstMessage)
+}
+
+// hashSessionContent 计算会话内容的哈希值
+func hashSessionContent(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:16])
+}
+
+// ApplySessionAffinity 如果会话已绑定账号且该账号仍在可用列表中，将其移动到首位
+// 返回调整后的账号列表及会话绑定的账号ID（没有绑定记录时为0），只调整顺序，命中统计由 RecordSessionAffinity 在请求完成后记录
+func ApplySessionAffinity(groupID int, sessionHash string, accounts []model.Account) ([]model.Account, uint) {
+	if sessionHash == "" || common.RDB == nil || len(accounts) == 0 {
+		return accounts, 0
+	}
+
+	ctx := context.Background()
+	boundID, err := common.RDB.Get(ctx, sessionAffinityKey(groupID, sessionHash)).Uint64()
+	if err != nil {
+		// 没有绑定记录，视为新会话
+		return accounts, 0
+	}
+
+	for i := range accounts {
+		if accounts[i].ID != uint(boundID) {
+			continue
+		}
+
+		ordered := make([]model.Account, 0, len(accounts))
+		ordered = append(ordered, accounts[i])
+		ordered = append(ordered, accounts[:i]...)
+		ordered = append(ordered, accounts[i+1:]...)
+		return ordered, uint(boundID)
+	}
+
+	// 绑定的账号当前不可用，回退到调度顺序，成功后会重新绑定
+	return accounts, uint(boundID)
+}
+
+// RecordSessionAffinity 每个客户端请求在最终选定账号后记录一次会话粘性结果
+// boundID 为调度时会话绑定的账号ID，accountID 为最终处理请求的账号ID
+func RecordSessionAffinity(sessionHash string, boundID, accountID uint) {
+	if sessionHash == "" || common.RDB == nil {
+		return
+	}
+	recordSessionAffinity(sessionAffinityOutcome(boundID, accountID))
+}
+
+// sessionAffinityOutcome 判断会话粘性结果：没有绑定记录为new，由绑定账号处理为hit，否则为fallback
+func sessionAffinityOutcome(boundID, accountID uint) string {
+	switch boundID {
+	case 0:
+		return "new"
+	case accountID:
+		return "hit"
+	default:
+		return "fallback"
+	}
+}
+
+// BindSession 将会话绑定到实际处理请求的账号，并刷新有效期
+func BindSession(groupID int, sessionHash string, accountID uint) {
+	if sessionHash == "" || common.RDB == nil {
+		return
+	}
+
+	ctx := context.Background()
+	if err := common.RDB.Set(ctx, sessionAffinityKey(groupID, sessionHash), accountID, getSessionAffinityTTL()).Err(); err != nil {
+		log.Printf("保存会话粘性失败: %v", err)
+	}
+}
+
+// GetSessionAffinityStats 获取今日会话粘性命中统计
+func GetSessionAffinityStats() *model.SessionAffinityStats {
+	stats := &model.SessionAffinityStats{}
+	if common.RDB == nil {
+		return stats
+	}
+
+	ctx := context.Background()
+	prefix := sessionAffinityStatsPrefix + time.Now().Format("2006-01-02") + ":"
+	stats.Hits, _ = common.RDB.Get(ctx, prefix+"hit").Int64()
+	stats.Fallbacks, _ = common.RDB.Get(ctx, prefix+"fallback").Int64()
+	stats.NewSessions, _ = common.RDB.Get(ctx, prefix+"new").Int64()
+
+	total := stats.Hits + stats.Fallbacks + stats.NewSessions
+	if total > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(total) * 100
+	}
+	return stats
+}
+
+// recordSessionAffinity 记录会话粘性结果（hit/fallback/new），按天统计
+func recordSessionAffinity(result string) {
+	ctx := context.Background()
+	key := sessionAffinityStatsPrefix + time.Now().Format("2006-01-02") + ":" + result
+
+	pipe := common.RDB.Pipeline()
+	pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, 48*time.Hour)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("记录会话粘性统计失败: %v", err)
+	}
+}
+
+// sessionAffinityKey 构建会话粘性键
+func sessionAffinityKey(groupID int, sessionHash string) string {
+	return fmt.Sprintf("%s%d:%s", sessionAffinityKeyPrefix, groupID, sessionHash)
+}
+
+// getSessionAffinityTTL 获取会话粘性有效期（环境变量 SESSION_AFFINITY_TTL，单位秒）
+func getSessionAffinityTTL() time.Duration {
+	if val := os.Getenv("SESSION_AFFINITY_TTL"); val != "" {
+		if seconds, err := strconv.Atoi(val); err == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	return defaultSessionAffinityTTL
+}
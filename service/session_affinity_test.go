@@ -0,0 +1,24 @@
+package service
+
+import "testing"
+
+func TestSessionAffinityOutcome(t *testing.T) {
+	tests := []struct {
+		name      string
+		boundID   uint
+		accountID uint
+		want      string
+	}{
+		{name: "no binding", boundID: 0, accountID: 7, want: "new"},
+		{name: "served by bound account", boundID: 7, accountID: 7, want: "hit"},
+		{name: "served by another account", boundID: 7, accountID: 8, want: "fallback"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sessionAffinityOutcome(tt.boundID, tt.accountID); got != tt.want {
+				t.Errorf("sessionAffinityOutcome(%d, %d) = %q, want %q", tt.boundID, tt.accountID, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package service
+
+import (
+	"claude-code-relay/model"
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// 分组排队的默认配置
+	defaultQueueMaxWait  = 60
+	defaultQueueMaxDepth = 100
+
+	// 队首请求轮询重新调度的间隔，用于感知限流到期等没有主动通知的状态变化
+	queuePollInterval = time.Second
+
+	// 无法估算时建议客户端重试的等待秒数
+	defaultRetryAfterSeconds = 10
+)
+
+// ErrWaitQueueFull 排队人数已达上限
+var ErrWaitQueueFull = errors.New("wait queue is full")
+
+// waitQueues 各分组的排队队列（进程内），多实例部署时每个实例各自排队
+var (
+	waitQueues   = make(map[uint]*waitQueue)
+	waitQueuesMu sync.Mutex
+)
+
+// waitQueue 单个分组的先进先出排队队列
+type waitQueue struct {
+	mu      sync.Mutex
+	tickets []*WaitTicket
+}
+
+// WaitTicket 排队凭证，只有位于队首的请求才会尝试重新调度账号
+type WaitTicket struct {
+	queue  *waitQueue
+	rank   int
+	notify chan struct{}
+	once   sync.Once
+}
+
+// EnterWaitQueue 进入分组的排队队列，队列已满时返回 ErrWaitQueueFull
+// 高优先级通道排在低优先级通道之前，同一通道内先进先出；低优先级通道只能占用部分队列深度
+func EnterWaitQueue(group *model.Group, priorityClass string) (*WaitTicket, error) {
+	maxDepth := group.QueueMaxDepth
+	if maxDepth <= 0 {
+		maxDepth = defaultQueueMaxDepth
+	}
+	maxDepth = laneCapacity(maxDepth, priorityClass)
+
+	waitQueuesMu.Lock()
+	queue, ok := waitQueues[group.ID]
+	if !ok {
+		queue = &waitQueue{}
+		waitQueues[group.ID] = queue
+	}
+	waitQueuesMu.Unlock()
+
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	if len(queue.tickets) >= maxDepth {
+		return nil, ErrWaitQueueFull
+	}
+
+	ticket := &WaitTicket{
+		queue:  queue,
+		rank:   priorityRank(priorityClass),
+		notify: make(chan struct{}, 1),
+	}
+
+	// 插入到同级及更高优先级请求之后
+	position := len(queue.tickets)
+	for i, waiting := range queue.tickets {
+		if waiting.rank > ticket.rank {
+			position = i
+			break
+		}
+	}
+	queue.tickets = append(queue.tickets, nil)
+	copy(queue.tickets[position+1:], queue.tickets[position:])
+	queue.tickets[position] = ticket
+
+	if position == 0 {
+		queue.signalHead()
+	}
+	return ticket, nil
+}
+
+// HasPriorityWaiters 判断分组队列中是否有优先级不低于当前通道的请求在等待
+// 此时新请求应进入队列排队，而不是直接抢占刚释放的账号
+func HasPriorityWaiters(groupID uint, priorityClass string) bool {
+	waitQueuesMu.Lock()
+	queue, ok := waitQueues[groupID]
+	waitQueuesMu.Unlock()
+	if !ok {
+		return false
+	}
+
+	rank := priorityRank(priorityClass)
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	for _, waiting := range queue.tickets {
+		if waiting.rank <= rank {
+			return true
+		}
+	}
+	return false
+}
+
+// Wait 等待轮到当前请求重新调度：位于队首且收到槽位释放通知或到达轮询间隔时返回true
+// 超过截止时间或客户端断开时返回false
+func (t *WaitTicket) Wait(ctx context.Context, deadline time.Time) bool {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	poll := time.NewTicker(queuePollInterval)
+	defer poll.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timer.C:
+			return false
+		case <-t.notify:
+		case <-poll.C:
+		}
+
+		if t.isHead() {
+			return true
+		}
+	}
+}
+
+// Leave 离开排队队列并唤醒新的队首，可重复调用
+func (t *WaitTicket) Leave() {
+	if t == nil {
+		return
+	}
+
+	t.once.Do(func() {
+		q := t.queue
+		q.mu.Lock()
+		defer q.mu.Unlock()
+
+		for i, ticket := range q.tickets {
+			if ticket == t {
+				q.tickets = append(q.tickets[:i], q.tickets[i+1:]...)
+				break
+			}
+		}
+		q.signalHead()
+	})
+}
+
+// isHead 判断当前请求是否位于队首
+func (t *WaitTicket) isHead() bool {
+	t.queue.mu.Lock()
+	defer t.queue.mu.Unlock()
+	return len(t.queue.tickets) > 0 && t.queue.tickets[0] == t
+}
+
+// signalHead 通知队首请求重新调度，调用方需持有队列锁
+func (q *waitQueue) signalHead() {
+	if len(q.tickets) == 0 {
+		return
+	}
+	select {
+	case q.tickets[0].notify <- struct{}{}:
+	default:
+	}
+}
+
+// notifyWaitQueues 有账号并发槽位释放时唤醒所有分组的队首请求
+func notifyWaitQueues() {
+	waitQueuesMu.Lock()
+	queues := make([]*waitQueue, 0, len(waitQueues))
+	for _, queue := range waitQueues {
+		queues = append(queues, queue)
+	}
+	waitQueuesMu.Unlock()
+
+	for _, queue := range queues {
+		queue.mu.Lock()
+		queue.signalHead()
+		queue.mu.Unlock()
+	}
+}
+
+// GetQueueMaxWait 获取分组排队的最长等待时间
+func GetQueueMaxWait(group *model.Group) time.Duration {
+	if group.QueueMaxWait <= 0 {
+		return defaultQueueMaxWait * time.Second
+	}
+	return time.Duration(group.QueueMaxWait) * time.Second
+}
+
+// EstimateRetryAfter 估算客户端应在多少秒后重试：优先使用分组内最早的限流结束时间
+func EstimateRetryAfter(groupID int) int {
+	return estimateRetryAfter(groupID, model.GetEarliestRateLimitEndTime)
+}
+
+// estimateRetryAfter 估算重试等待秒数，loadEndTime 查询分组内最早的限流结束时间
+func estimateRetryAfter(groupID int, loadEndTime func(groupID int) (*time.Time, error)) int {
+	endTime, err := loadEndTime(groupID)
+	if err != nil {
+		log.Printf("查询分组 %d 限流结束时间失败: %v", groupID, err)
+		return defaultRetryAfterSeconds
+	}
+	if endTime == nil {
+		return defaultRetryAfterSeconds
+	}
+
+	seconds := int(time.Until(*endTime).Seconds()) + 1
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
@@ -0,0 +1,164 @@
+package service
+
+import (
+	"claude-code-relay/constant"
+	"claude-code-relay/model"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// newTestQueueGroup 创建测试用分组并在结束时清理其排队队列
+func newTestQueueGroup(t *testing.T, id uint, maxDepth int) *model.Group {
+	t.Helper()
+	t.Setenv("PRIORITY_BATCH_CAPACITY", "")
+	t.Setenv("PRIORITY_BACKGROUND_CAPACITY", "")
+	t.Cleanup(func() {
+		waitQueuesMu.Lock()
+		delete(waitQueues, id)
+		waitQueuesMu.Unlock()
+	})
+	return &model.Group{ID: id, QueueMaxDepth: maxDepth}
+}
+
+// mustEnter 进入排队队列，失败时终止测试
+func mustEnter(t *testing.T, group *model.Group, priorityClass string) *WaitTicket {
+	t.Helper()
+	ticket, err := EnterWaitQueue(group, priorityClass)
+	if err != nil {
+		t.Fatalf("EnterWaitQueue(%s) unexpected error: %v", priorityClass, err)
+	}
+	return ticket
+}
+
+func TestWaitQueueReservedCapacity(t *testing.T) {
+	group := newTestQueueGroup(t, 9101, 4)
+
+	// 深度4：background 只能占用2个位置，batch 3个，interactive 4个
+	mustEnter(t, group, constant.PriorityClassBackground)
+	mustEnter(t, group, constant.PriorityClassBackground)
+	if _, err := EnterWaitQueue(group, constant.PriorityClassBackground); !errors.Is(err, ErrWaitQueueFull) {
+		t.Fatalf("background beyond its share: err = %v, want ErrWaitQueueFull", err)
+	}
+
+	mustEnter(t, group, constant.PriorityClassBatch)
+	if _, err := EnterWaitQueue(group, constant.PriorityClassBatch); !errors.Is(err, ErrWaitQueueFull) {
+		t.Fatalf("batch beyond its share: err = %v, want ErrWaitQueueFull", err)
+	}
+
+	// 预留给交互式请求的位置仍然可用
+	mustEnter(t, group, constant.PriorityClassInteractive)
+	if _, err := EnterWaitQueue(group, constant.PriorityClassInteractive); !errors.Is(err, ErrWaitQueueFull) {
+		t.Fatalf("interactive beyond max depth: err = %v, want ErrWaitQueueFull", err)
+	}
+}
+
+func TestWaitQueueOrdering(t *testing.T) {
+	group := newTestQueueGroup(t, 9102, 20)
+
+	background1 := mustEnter(t, group, constant.PriorityClassBackground)
+	batch1 := mustEnter(t, group, constant.PriorityClassBatch)
+	interactive1 := mustEnter(t, group, "")
+	background2 := mustEnter(t, group, constant.PriorityClassBackground)
+	interactive2 := mustEnter(t, group, constant.PriorityClassInteractive)
+	batch2 := mustEnter(t, group, constant.PriorityClassBatch)
+
+	// 高优先级通道在前，同一通道内先进先出
+	want := []*WaitTicket{interactive1, interactive2, batch1, batch2, background1, background2}
+	for i, ticket := range want {
+		if !ticket.isHead() {
+			t.Fatalf("step %d: expected ticket to be at the head", i)
+		}
+		for _, other := range want[i+1:] {
+			if other.isHead() {
+				t.Fatalf("step %d: later ticket is at the head", i)
+			}
+		}
+		ticket.Leave()
+	}
+}
+
+func TestHasPriorityWaiters(t *testing.T) {
+	group := newTestQueueGroup(t, 9103, 20)
+
+	if HasPriorityWaiters(group.ID, constant.PriorityClassBackground) {
+		t.Fatal("empty queue should have no waiters")
+	}
+
+	ticket := mustEnter(t, group, constant.PriorityClassBatch)
+	if HasPriorityWaiters(group.ID, constant.PriorityClassInteractive) {
+		t.Error("interactive request should not wait behind batch requests")
+	}
+	if !HasPriorityWaiters(group.ID, constant.PriorityClassBatch) {
+		t.Error("batch request should wait behind queued batch requests")
+	}
+	if !HasPriorityWaiters(group.ID, constant.PriorityClassBackground) {
+		t.Error("background request should wait behind queued batch requests")
+	}
+
+	ticket.Leave()
+	ticket.Leave()
+	if HasPriorityWaiters(group.ID, constant.PriorityClassBackground) {
+		t.Error("queue should be empty after leave")
+	}
+}
+
+func TestWaitTicketWait(t *testing.T) {
+	group := newTestQueueGroup(t, 9104, 20)
+
+	first := mustEnter(t, group, constant.PriorityClassInteractive)
+	second := mustEnter(t, group, constant.PriorityClassInteractive)
+
+	if !first.Wait(context.Background(), time.Now().Add(time.Second)) {
+		t.Fatal("head ticket should be woken immediately")
+	}
+	if second.Wait(context.Background(), time.Now().Add(50*time.Millisecond)) {
+		t.Fatal("non-head ticket should time out")
+	}
+
+	// 队首离开后唤醒下一个请求
+	done := make(chan bool)
+	go func() { done <- second.Wait(context.Background(), time.Now().Add(time.Second)) }()
+	first.Leave()
+	if !<-done {
+		t.Fatal("next ticket should be woken after the head leaves")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	third := mustEnter(t, group, constant.PriorityClassInteractive)
+	if third.Wait(ctx, time.Now().Add(time.Second)) {
+		t.Fatal("wait should stop when the client disconnects")
+	}
+}
+
+func TestEstimateRetryAfter(t *testing.T) {
+	tests := []struct {
+		name    string
+		endTime *time.Time
+		err     error
+		want    int
+	}{
+		{name: "no rate limited account", want: defaultRetryAfterSeconds},
+		{name: "query error", err: errors.New("db down"), want: defaultRetryAfterSeconds},
+		{name: "rate limit ends later", endTime: timePtr(time.Now().Add(30 * time.Second)), want: 30},
+		{name: "rate limit already ended", endTime: timePtr(time.Now().Add(-time.Minute)), want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := estimateRetryAfter(1, func(groupID int) (*time.Time, error) {
+				return tt.endTime, tt.err
+			})
+			// 计算时向上取整，允许1秒误差
+			if got != tt.want && got != tt.want+1 {
+				t.Errorf("estimateRetryAfter = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}